@@ -0,0 +1,381 @@
+package ccipv4
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// 比較用に、区間配列に置き換える前の 4 段のマップによる検索を再現したもの。
+// start のアドレスを８ビットで分割し、マップのキーとする。
+type legacyIPBlocks map[uint8]map[uint8]map[uint8]map[uint8]block
+
+// 一時保存用データベースのデータから 4 段のマップを作成する。
+func newLegacyIPBlocks(data map[uint32]block) legacyIPBlocks {
+	lb := legacyIPBlocks{}
+	for k, v := range data {
+		a4 := uint32ToAddr(k).As4()
+		if _, ok := lb[a4[0]]; !ok {
+			lb[a4[0]] = map[uint8]map[uint8]map[uint8]block{}
+		}
+		if _, ok := lb[a4[0]][a4[1]]; !ok {
+			lb[a4[0]][a4[1]] = map[uint8]map[uint8]block{}
+		}
+		if _, ok := lb[a4[0]][a4[1]][a4[2]]; !ok {
+			lb[a4[0]][a4[1]][a4[2]] = map[uint8]block{}
+		}
+		lb[a4[0]][a4[1]][a4[2]][a4[3]] = v
+	}
+
+	return lb
+}
+
+func (lb legacyIPBlocks) checkFirst8Bit(as4 [4]byte) ([4]byte, bool) {
+	if _, ok := lb[as4[0]]; ok {
+		return as4, true
+	}
+	i := as4[0]
+	for {
+		if _, ok := lb[i]; ok {
+			as4[0] = i
+			as4[1] = 255
+			as4[2] = 255
+			as4[3] = 255
+			return as4, true
+		}
+		if i == 0 {
+			return as4, false
+		}
+		i--
+	}
+}
+
+func (lb legacyIPBlocks) checkSecond8Bit(as4 [4]byte) ([4]byte, bool) {
+	if _, ok := lb[as4[0]][as4[1]]; ok {
+		return as4, true
+	}
+	if _, ok := lb[as4[0]][0]; ok && len(lb[as4[0]]) == 1 {
+		as4[1] = 0
+		as4[2] = 255
+		as4[3] = 255
+		return as4, true
+	}
+	i := as4[1]
+	for {
+		if _, ok := lb[as4[0]][i]; ok {
+			as4[1] = i
+			as4[2] = 255
+			as4[3] = 255
+			return as4, true
+		}
+		if i == 0 {
+			return as4, false
+		}
+		i--
+	}
+}
+
+func (lb legacyIPBlocks) checkThird8Bit(as4 [4]byte) ([4]byte, bool) {
+	if _, ok := lb[as4[0]][as4[1]][as4[2]]; ok {
+		return as4, true
+	}
+	if _, ok := lb[as4[0]][as4[1]][0]; ok && len(lb[as4[0]][as4[1]]) == 1 {
+		as4[2] = 0
+		as4[3] = 255
+		return as4, true
+	}
+	i := as4[2]
+	for {
+		if _, ok := lb[as4[0]][as4[1]][i]; ok {
+			as4[2] = i
+			as4[3] = 255
+			return as4, true
+		}
+		if i == 0 {
+			return as4, false
+		}
+		i--
+	}
+}
+
+func (lb legacyIPBlocks) checkLast8Bit(as4 [4]byte) ([4]byte, bool) {
+	if _, ok := lb[as4[0]][as4[1]][as4[2]][as4[3]]; ok {
+		return as4, true
+	}
+	if _, ok := lb[as4[0]][as4[1]][as4[2]][0]; ok && len(lb[as4[0]][as4[1]][as4[2]]) == 1 {
+		as4[3] = 0
+		return as4, true
+	}
+	i := as4[3]
+	for {
+		if _, ok := lb[as4[0]][as4[1]][as4[2]][i]; ok {
+			as4[3] = i
+			return as4, true
+		}
+		if i == 0 {
+			return as4, false
+		}
+		i--
+	}
+}
+
+func (lb legacyIPBlocks) searchBlockStart(addr netip.Addr) [4]byte {
+	var (
+		as4        [4]byte = addr.As4()
+		checkLevel int     = 2
+		found      bool    = true
+	)
+
+	for {
+		if checkLevel == 2 {
+			as4, found = lb.checkFirst8Bit(as4)
+			if !found {
+				return [4]byte{}
+			}
+		}
+
+		if checkLevel >= 1 {
+			as4, found = lb.checkSecond8Bit(as4)
+			if !found {
+				if as4[0] == 0 {
+					return [4]byte{}
+				}
+				as4[0]--
+				as4[1] = 255
+				as4[2] = 255
+				as4[3] = 255
+				checkLevel = 2
+				continue
+			}
+		}
+
+		as4, found = lb.checkThird8Bit(as4)
+		if !found {
+			if as4[1] == 0 {
+				if as4[0] == 0 {
+					return [4]byte{}
+				}
+				as4[0]--
+				as4[1] = 255
+				as4[2] = 255
+				as4[3] = 255
+				checkLevel = 2
+				continue
+			}
+			as4[1]--
+			as4[2] = 255
+			as4[3] = 255
+			checkLevel = 1
+			continue
+		}
+
+		as4, found = lb.checkLast8Bit(as4)
+		if found {
+			return as4
+		}
+		if as4[2] == 0 {
+			if as4[1] == 0 {
+				if as4[0] == 0 {
+					return [4]byte{}
+				}
+				as4[0]--
+				as4[1] = 255
+				as4[2] = 255
+				as4[3] = 255
+				checkLevel = 2
+				continue
+			}
+			as4[1]--
+			as4[2] = 255
+			as4[3] = 255
+			checkLevel = 1
+			continue
+		}
+		as4[2]--
+		as4[3] = 255
+		checkLevel = 0
+	}
+}
+
+// 従来の SearchInfo と同じ方法で、所属ブロックの開始アドレスと終了アドレスを返す。
+func (lb legacyIPBlocks) search(addr netip.Addr) (netip.Addr, netip.Addr, bool) {
+	as4 := lb.searchBlockStart(addr)
+	if as4 == [4]byte{} {
+		return netip.Addr{}, netip.Addr{}, false
+	}
+	oO := getOneOutside(as4, lb[as4[0]][as4[1]][as4[2]][as4[3]].value)
+	if addr.Less(oO) {
+		return netip.AddrFrom4(as4), oO.Prev(), true
+	}
+
+	return netip.Addr{}, netip.Addr{}, false
+}
+
+// 比較に使用する testdata のファイル
+var benchIPBlockFiles = []string{
+	"testdata/validIPBlockFile-1",
+	"testdata/validIPBlockFile-2",
+	"testdata/validIPBlockFile-3",
+	"testdata/validIPBlockFile-4",
+	"testdata/validIPBlockFile-5",
+	"testdata/validIPBlockFile-6",
+	"testdata/validIPBlockFile-7",
+}
+
+// testdata のファイルを読み込んだ一時保存用データベースのデータと、
+// 各ブロックの境界前後およびデータの疎な範囲の検索対象アドレスを返す。
+func loadBenchData(tb testing.TB, file string) (map[uint32]block, []netip.Addr) {
+	db := GetDB()
+	if err := db.LoadIPBDataByFile(file); err != nil {
+		tb.Fatalf("load %s, but error: %v", file, err)
+	}
+	data := db.tmpIB.data
+
+	targets := []netip.Addr{
+		netip.MustParseAddr("0.0.0.1"),
+		netip.MustParseAddr("1.0.0.1"),
+		netip.MustParseAddr("100.100.100.100"),
+		netip.MustParseAddr("200.200.200.200"),
+		netip.MustParseAddr("223.255.255.255"),
+	}
+	for k, v := range data {
+		for _, x := range []uint32{k - 1, k, k + v.value/2, k + v.value - 1, k + v.value} {
+			targets = append(targets, uint32ToAddr(x))
+		}
+	}
+
+	return data, targets
+}
+
+func TestSearchRangeCompatibility(t *testing.T) {
+	for _, file := range benchIPBlockFiles {
+		data, targets := loadBenchData(t, file)
+		lb := newLegacyIPBlocks(data)
+		ranges, _, _ := buildRanges(data, nil)
+
+		for _, target := range targets {
+			ws, we, wf := lb.search(target)
+
+			x := addrToUint32(target)
+			i := searchRange(ranges, x)
+			gf := i >= 0 && ranges[i].end >= x
+			if gf != wf {
+				t.Errorf("%s: %s: found want %v, but %v", file, target, wf, gf)
+				continue
+			}
+			if !gf {
+				continue
+			}
+			if uint32ToAddr(ranges[i].start) != ws || uint32ToAddr(ranges[i].end) != we {
+				t.Errorf("%s: %s: want %s - %s, but %s - %s", file, target, ws, we, uint32ToAddr(ranges[i].start), uint32ToAddr(ranges[i].end))
+			}
+		}
+	}
+}
+
+func BenchmarkBuildLegacyMap(b *testing.B) {
+	for _, file := range benchIPBlockFiles {
+		data, _ := loadBenchData(b, file)
+		b.Run(file, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				newLegacyIPBlocks(data)
+			}
+		})
+	}
+}
+
+func BenchmarkBuildRanges(b *testing.B) {
+	for _, file := range benchIPBlockFiles {
+		data, _ := loadBenchData(b, file)
+		b.Run(file, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buildRanges(data, nil)
+			}
+		})
+	}
+}
+
+func BenchmarkSearchLegacyMap(b *testing.B) {
+	for _, file := range benchIPBlockFiles {
+		data, targets := loadBenchData(b, file)
+		lb := newLegacyIPBlocks(data)
+		b.Run(file, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				lb.search(targets[i%len(targets)])
+			}
+		})
+	}
+}
+
+func BenchmarkSearchRanges(b *testing.B) {
+	for _, file := range benchIPBlockFiles {
+		data, targets := loadBenchData(b, file)
+		ranges, _, _ := buildRanges(data, nil)
+		b.Run(file, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				x := addrToUint32(targets[i%len(targets)])
+				if j := searchRange(ranges, x); j >= 0 && ranges[j].end >= x {
+					uint32ToAddr(ranges[j].start)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSearchInfo(b *testing.B) {
+	for _, file := range benchIPBlockFiles {
+		db := GetDB()
+		if err := db.LoadIPBDataByFile(file); err != nil {
+			b.Fatalf("load %s, but error: %v", file, err)
+		}
+		_, targets := loadBenchData(b, file)
+		db.SwitchIPBData()
+		adrs := make([]string, len(targets))
+		for i := range targets {
+			adrs[i] = targets[i].String()
+		}
+		b.Run(file, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				db.SearchInfo(adrs[i%len(adrs)])
+			}
+		})
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	for _, file := range benchIPBlockFiles {
+		db := GetDB()
+		if err := db.LoadIPBDataByFile(file); err != nil {
+			b.Fatalf("load %s, but error: %v", file, err)
+		}
+		_, targets := loadBenchData(b, file)
+		db.SwitchIPBData()
+		b.Run(file, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				db.Lookup(targets[i%len(targets)])
+			}
+		})
+	}
+}
+
+func BenchmarkLookupCode(b *testing.B) {
+	for _, file := range benchIPBlockFiles {
+		db := GetDB()
+		if err := db.LoadIPBDataByFile(file); err != nil {
+			b.Fatalf("load %s, but error: %v", file, err)
+		}
+		_, targets := loadBenchData(b, file)
+		db.SwitchIPBData()
+		b.Run(file, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				db.LookupCode(targets[i%len(targets)])
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package ccipv4
+
+import (
+	"net/netip"
+	"slices"
+	"time"
+)
+
+// 検索用データベースの割当ブロック
+type Block struct {
+	// 割当ブロックの最初と最後のアドレス
+	Start netip.Addr
+	End   netip.Addr
+	// RIR statistics exchange format の value 。
+	// ipv4 の場合はアドレスの個数、 ipv6 の場合はプレフィックス長。
+	Value            int
+	Code             string
+	Registry         string
+	AllocationStatus string
+	AllocatedOn      time.Time
+	OpaqueID         string
+}
+
+// 割当ブロックを覆う最小の個数のプレフィックスの一覧を返す。
+func (b Block) Prefixes() []netip.Prefix {
+	return RangeToPrefixes(b.Start, b.End)
+}
+
+// カントリーコードが code の割当ブロックを、アドレス順に返す。
+// ipv4 の割当ブロックが ipv6 の割当ブロックより先になる。
+// 該当するものがない場合は nil を返す。
+func (db *DB) BlocksByCountry(code string) []Block {
+	v := db.snapshot()
+	cc, ok := v.dicCCStrToInt[code]
+	if !ok {
+		return nil
+	}
+
+	var bs []Block
+	v.eachBlock(func(country uint16) bool { return country == cc }, func(b Block) {
+		bs = append(bs, b)
+	})
+
+	return bs
+}
+
+// 割当ブロックを、カントリーコードごとにアドレス順に返す。
+// 同じ時点の検索用データベースから、全ての割当ブロックを一度に取得する。
+// カントリーコードが空の割当ブロックは含めない。
+func (db *DB) BlocksByCountries() map[string][]Block {
+	v := db.snapshot()
+	m := map[string][]Block{}
+	v.eachBlock(func(country uint16) bool { return v.dicCCIntToStr[country] != "" }, func(b Block) {
+		m[b.Code] = append(m[b.Code], b)
+	})
+
+	return m
+}
+
+// カントリーコードが code の割当ブロックを覆う、最小の個数のプレフィックスの一覧を返す。
+// 隣接する割当ブロックはまとめる。戻り値はアドレス順。
+func (db *DB) PrefixesByCountry(code string) []netip.Prefix {
+	return aggregateBlocks(db.BlocksByCountry(code))
+}
+
+// 割当ブロックを覆う最小の個数のプレフィックスの一覧を、カントリーコードごとに返す。
+// 同じ時点の検索用データベースから、全てのカントリーコードのものを一度に取得する。
+// 隣接する割当ブロックはまとめる。各一覧はアドレス順。
+func (db *DB) PrefixesByCountries() map[string][]netip.Prefix {
+	m := map[string][]netip.Prefix{}
+	for cc, bs := range db.BlocksByCountries() {
+		m[cc] = aggregateBlocks(bs)
+	}
+
+	return m
+}
+
+// 割当ブロックを覆う、最小の個数のプレフィックスの一覧を返す。
+func aggregateBlocks(bs []Block) []netip.Prefix {
+	rs := make([]AddrRange, len(bs))
+	for i, b := range bs {
+		rs[i] = AddrRange{Start: b.Start, End: b.End}
+	}
+
+	return AggregateRanges(rs)
+}
+
+// 割当ブロックのうちカントリーコードが match に一致するものを、
+// ipv4 、 ipv6 の順にアドレス順で fn に渡す。
+func (v ipbView) eachBlock(match func(country uint16) bool, fn func(b Block)) {
+	for _, r := range v.ranges {
+		a := v.attrs[r.attrs]
+		if !match(a.country) {
+			continue
+		}
+		fn(toBlock(uint32ToAddr(r.start), uint32ToAddr(r.end), int(r.end-r.start)+1, v.dicCCIntToStr[a.country], a))
+	}
+
+	var ps []netip.Prefix
+	for p, b := range v.data6 {
+		if match(b.country) {
+			ps = append(ps, p)
+		}
+	}
+	slices.SortFunc(ps, func(a, b netip.Prefix) int {
+		return a.Addr().Compare(b.Addr())
+	})
+	for _, p := range ps {
+		b := v.data6[p]
+		fn(toBlock(p.Addr(), getLastAddrOfPrefix(p), p.Bits(), v.dicCCIntToStr[b.country], b.blockAttrs))
+	}
+}
+
+// 検索用データベースの割当ブロックのカントリーコードの一覧を、辞書順に返す。
+// カントリーコードが空の割当ブロックは含めない。
+func (db *DB) CountryCodes() []string {
+	v := db.snapshot()
+	ccs := make([]string, 0, len(v.dicCCStrToInt))
+	for cc := range v.dicCCStrToInt {
+		if cc != "" {
+			ccs = append(ccs, cc)
+		}
+	}
+	slices.Sort(ccs)
+
+	return ccs
+}
+
+// 割当ブロックの範囲と属性から Block を作成する。
+func toBlock(start, end netip.Addr, value int, code string, a blockAttrs) Block {
+	return Block{
+		Start:            start,
+		End:              end,
+		Value:            value,
+		Code:             code,
+		Registry:         a.registry,
+		AllocationStatus: a.status,
+		AllocatedOn:      dateToTime(a.date),
+		OpaqueID:         a.opaqueID,
+	}
+}
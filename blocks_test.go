@@ -0,0 +1,128 @@
+package ccipv4
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBlocksByCountry(t *testing.T) {
+	db := GetDB()
+	err := db.setTmpIPBlocks(strings.NewReader(strings.Join([]string{
+		"apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated|A91A7381",
+		"apnic|JP|ipv4|1.0.0.0|768|20110811|assigned",
+		"apnic|CN|ipv4|124.147.128.0|32768|20060306|allocated",
+		"apnic|JP|ipv6|2001:df2:6200::|48|20160525|assigned",
+		"apnic|JP|ipv6|2001:df2:6180::|48|20191216|assigned",
+		"apnic|JP|ipv4|1.0.3.0|256|20110811|assigned",
+		"apnic|JP|asn|173|1|20020801|allocated",
+	}, "\n")), "")
+	if err != nil {
+		t.Fatalf("setTmpIPBlocks: but error: %v", err)
+	}
+	db.SwitchIPBData()
+
+	bs := db.BlocksByCountry("JP")
+	want := []Block{
+		{Start: netip.MustParseAddr("1.0.0.0"), End: netip.MustParseAddr("1.0.2.255"), Value: 768, Code: "JP", Registry: "apnic", AllocationStatus: "assigned", AllocatedOn: dateToTime(20110811)},
+		{Start: netip.MustParseAddr("1.0.3.0"), End: netip.MustParseAddr("1.0.3.255"), Value: 256, Code: "JP", Registry: "apnic", AllocationStatus: "assigned", AllocatedOn: dateToTime(20110811)},
+		{Start: netip.MustParseAddr("114.48.0.0"), End: netip.MustParseAddr("114.51.255.255"), Value: 262144, Code: "JP", Registry: "apnic", AllocationStatus: "allocated", AllocatedOn: dateToTime(20080422), OpaqueID: "A91A7381"},
+		{Start: netip.MustParseAddr("2001:df2:6180::"), End: netip.MustParseAddr("2001:df2:6180:ffff:ffff:ffff:ffff:ffff"), Value: 48, Code: "JP", Registry: "apnic", AllocationStatus: "assigned", AllocatedOn: dateToTime(20191216)},
+		{Start: netip.MustParseAddr("2001:df2:6200::"), End: netip.MustParseAddr("2001:df2:6200:ffff:ffff:ffff:ffff:ffff"), Value: 48, Code: "JP", Registry: "apnic", AllocationStatus: "assigned", AllocatedOn: dateToTime(20160525)},
+	}
+	if !slices.Equal(bs, want) {
+		t.Errorf("BlocksByCountry: want %v, but %v", want, bs)
+	}
+	if ps := bs[0].Prefixes(); !slices.Equal(ps, []netip.Prefix{netip.MustParsePrefix("1.0.0.0/23"), netip.MustParsePrefix("1.0.2.0/24")}) {
+		t.Errorf("Block.Prefixes: invalid prefixes: %v", ps)
+	}
+
+	// 隣接するブロックはまとめる。
+	ps := db.PrefixesByCountry("JP")
+	wantPs := []netip.Prefix{
+		netip.MustParsePrefix("1.0.0.0/22"),
+		netip.MustParsePrefix("114.48.0.0/14"),
+		netip.MustParsePrefix("2001:df2:6180::/48"),
+		netip.MustParsePrefix("2001:df2:6200::/48"),
+	}
+	if !slices.Equal(ps, wantPs) {
+		t.Errorf("PrefixesByCountry: want %v, but %v", wantPs, ps)
+	}
+
+	if bs := db.BlocksByCountry("CN"); len(bs) != 1 || bs[0].Start != netip.MustParseAddr("124.147.128.0") {
+		t.Errorf("BlocksByCountry: CN is invalid: %v", bs)
+	}
+
+	// 該当なし
+	for _, code := range []string{"US", "", "jp"} {
+		if bs := db.BlocksByCountry(code); bs != nil {
+			t.Errorf("BlocksByCountry: %q, want nil, but %v", code, bs)
+		}
+		if ps := db.PrefixesByCountry(code); ps != nil {
+			t.Errorf("PrefixesByCountry: %q, want nil, but %v", code, ps)
+		}
+	}
+	if bs := GetDB().BlocksByCountry("JP"); bs != nil {
+		t.Errorf("BlocksByCountry: db is empty, but %v", bs)
+	}
+}
+
+func TestBlocksByCountries(t *testing.T) {
+	db := GetDB()
+	if m := db.BlocksByCountries(); len(m) != 0 {
+		t.Errorf("BlocksByCountries: empty db, but %v", m)
+	}
+
+	err := db.setTmpIPBlocks(strings.NewReader(strings.Join([]string{
+		"apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated",
+		"apnic|JP|ipv4|1.0.0.0|768|20110811|assigned",
+		"apnic|CN|ipv4|124.147.128.0|32768|20060306|allocated",
+		"apnic|JP|ipv6|2001:df2:6180::|48|20191216|assigned",
+		"apnic|JP|ipv4|1.0.3.0|256|20110811|assigned",
+		"apnic||ipv4|1.0.4.0|256||reserved",
+		"apnic|AU|asn|173|1|20020801|allocated",
+	}, "\n")), "")
+	if err != nil {
+		t.Fatalf("setTmpIPBlocks: but error: %v", err)
+	}
+	db.SwitchIPBData()
+
+	// カントリーコードごとに BlocksByCountry と同じ内容を返す。
+	// カントリーコードが空のものと、 asn のみのものは含めない。
+	m := db.BlocksByCountries()
+	if len(m) != 2 {
+		t.Errorf("BlocksByCountries: want JP and CN, but %v", m)
+	}
+	for _, cc := range []string{"JP", "CN"} {
+		if want := db.BlocksByCountry(cc); !slices.Equal(m[cc], want) {
+			t.Errorf("BlocksByCountries: %s, want %v, but %v", cc, want, m[cc])
+		}
+	}
+
+	pm := db.PrefixesByCountries()
+	if len(pm) != 2 {
+		t.Errorf("PrefixesByCountries: want JP and CN, but %v", pm)
+	}
+	for _, cc := range []string{"JP", "CN"} {
+		if want := db.PrefixesByCountry(cc); !slices.Equal(pm[cc], want) {
+			t.Errorf("PrefixesByCountries: %s, want %v, but %v", cc, want, pm[cc])
+		}
+	}
+}
+
+func TestCountryCodes(t *testing.T) {
+	db := GetDB()
+	if ccs := db.CountryCodes(); len(ccs) != 0 {
+		t.Errorf("CountryCodes: empty db, but %v", ccs)
+	}
+
+	if err := db.LoadIPBDataByFile("testdata/validIPBlockFile-1"); err != nil {
+		t.Fatalf("LoadIPBDataByFile: but error: %v", err)
+	}
+	db.SwitchIPBData()
+	want := []string{"AU", "BD", "HK", "IN", "JP", "NZ", "PH"}
+	if ccs := db.CountryCodes(); !slices.Equal(ccs, want) {
+		t.Errorf("CountryCodes: want %v, but %v", want, ccs)
+	}
+}
@@ -0,0 +1,91 @@
+package ccipv4
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/suka-test/ccipv4/internal/atomicfile"
+)
+
+// キャッシュしたデータの取得元と、条件付きの取得に使う情報
+type cacheMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+type cacheEntry struct {
+	meta cacheMeta
+	body []byte
+}
+
+// URL に対応するキャッシュファイルのパスを返す。
+// キャッシュファイルの名前は URL の SHA-256 とする。
+func (db *DB) cachePath(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return filepath.Join(db.cacheDir, hex.EncodeToString(sum[:])+".cache")
+}
+
+// URL に対応するキャッシュを読み込む。
+// キャッシュディレクトリが未設定の場合や、キャッシュがないか
+// 読み込めない場合は nil を返す。
+func (db *DB) readCache(u string) *cacheEntry {
+	if db.cacheDir == "" {
+		return nil
+	}
+	b, err := os.ReadFile(db.cachePath(u))
+	if err != nil {
+		return nil
+	}
+
+	// キャッシュファイルは、１行目が JSON 形式の cacheMeta 、
+	// ２行目以降が取得したデータ。
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		return nil
+	}
+	var ce cacheEntry
+	if err := json.Unmarshal(b[:i], &ce.meta); err != nil || ce.meta.URL != u {
+		return nil
+	}
+	ce.body = b[i+1:]
+
+	return &ce
+}
+
+// URL から取得したデータと応答のヘッダの ETag / Last-Modified を
+// キャッシュに保存する。
+// キャッシュディレクトリが未設定の場合は何もしない。
+func (db *DB) writeCache(u string, body []byte, header http.Header) error {
+	if db.cacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(db.cacheDir, 0o755); err != nil {
+		return err
+	}
+	meta, err := json.Marshal(cacheMeta{
+		URL:          u,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.Write(db.cachePath(u), func(w io.Writer) error {
+		if _, err := w.Write(meta); err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+		_, err := w.Write(body)
+		return err
+	})
+}
@@ -0,0 +1,235 @@
+package ccipv4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLoadIPBDataByURLWithCache(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		body   string = "apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated\n"
+		etag   string = `"v1"`
+		status int
+		reqs   []*http.Request
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"/etag",
+		func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			reqs = append(reqs, r)
+			if status != 0 {
+				w.WriteHeader(status)
+				return
+			}
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			fmt.Fprint(w, body)
+		},
+	)
+	mux.HandleFunc(
+		"/last-modified",
+		func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			reqs = append(reqs, r)
+			if r.Header.Get("If-Modified-Since") == "Mon, 05 Aug 2024 00:00:00 GMT" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Last-Modified", "Mon, 05 Aug 2024 00:00:00 GMT")
+			fmt.Fprintln(w, "apnic|CN|ipv4|124.147.128.0|32768|20060306|allocated")
+		},
+	)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	dir := filepath.Join(t.TempDir(), "cache")
+	db := NewDB(WithCacheDir(dir))
+
+	// キャッシュなし。取得したデータをキャッシュに保存する。
+	if err := db.LoadIPBDataByURL(ts.URL + "/etag"); err != nil {
+		t.Fatalf("LoadIPBDataByURL: url is valid, but error: %v", err)
+	}
+	if reqs[0].Header.Get("If-None-Match") != "" {
+		t.Errorf("LoadIPBDataByURL: If-None-Match want empty, but %s", reqs[0].Header.Get("If-None-Match"))
+	}
+	ce := db.readCache(ts.URL + "/etag")
+	if ce == nil {
+		t.Fatal("LoadIPBDataByURL: cache doesn't exist")
+	}
+	if ce.meta.ETag != etag || string(ce.body) != body {
+		t.Errorf("LoadIPBDataByURL: cache is invalid: %v, %s", ce.meta, ce.body)
+	}
+	db.ClearTmpIPBData()
+
+	// 更新なし。キャッシュのデータを使う。
+	if err := db.LoadIPBDataByURL(ts.URL + "/etag"); err != nil {
+		t.Fatalf("LoadIPBDataByURL: not modified, but error: %v", err)
+	}
+	if reqs[1].Header.Get("If-None-Match") != etag {
+		t.Errorf("LoadIPBDataByURL: If-None-Match want %s, but %s", etag, reqs[1].Header.Get("If-None-Match"))
+	}
+	if _, ok := db.tmpIB.data[u32("114.48.0.0")]; !ok {
+		t.Error("LoadIPBDataByURL: not modified, but tmpIB.data[114.48.0.0] doesn't exist")
+	}
+	db.ClearTmpIPBData()
+
+	// Last-Modified による条件付きの取得
+	if err := db.LoadIPBDataByURL(ts.URL + "/last-modified"); err != nil {
+		t.Fatalf("LoadIPBDataByURL: url is valid, but error: %v", err)
+	}
+	db.ClearTmpIPBData()
+	if err := db.LoadIPBDataByURL(ts.URL + "/last-modified"); err != nil {
+		t.Fatalf("LoadIPBDataByURL: not modified, but error: %v", err)
+	}
+	if reqs[3].Header.Get("If-Modified-Since") != "Mon, 05 Aug 2024 00:00:00 GMT" {
+		t.Errorf("LoadIPBDataByURL: If-Modified-Since is invalid: %s", reqs[3].Header.Get("If-Modified-Since"))
+	}
+	if _, ok := db.tmpIB.data[u32("124.147.128.0")]; !ok {
+		t.Error("LoadIPBDataByURL: not modified, but tmpIB.data[124.147.128.0] doesn't exist")
+	}
+	db.ClearTmpIPBData()
+
+	// 更新あり。読み込めないデータはキャッシュに保存しない。
+	mu.Lock()
+	body = "apnic|JP|ipv4|114.48.0.0|262144|20080422\n"
+	etag = `"v2"`
+	mu.Unlock()
+	if err := db.LoadIPBDataByURL(ts.URL + "/etag"); err == nil {
+		t.Error("LoadIPBDataByURL: bad data, but no error")
+	}
+	if ce := db.readCache(ts.URL + "/etag"); ce == nil || ce.meta.ETag != `"v1"` {
+		t.Errorf("LoadIPBDataByURL: cache is overwritten by bad data: %v", ce)
+	}
+
+	// サーバの異常。キャッシュのデータを使う。
+	mu.Lock()
+	status = http.StatusInternalServerError
+	mu.Unlock()
+	if err := db.LoadIPBDataByURL(ts.URL + "/etag"); err != nil {
+		t.Errorf("LoadIPBDataByURL: server error with cache, but error: %v", err)
+	}
+	if _, ok := db.tmpIB.data[u32("114.48.0.0")]; !ok {
+		t.Error("LoadIPBDataByURL: server error with cache, but tmpIB.data[114.48.0.0] doesn't exist")
+	}
+	db.ClearTmpIPBData()
+
+	// キャッシュがなければエラー
+	if err := NewDB().LoadIPBDataByURL(ts.URL + "/etag"); err == nil {
+		t.Error("LoadIPBDataByURL: server error without cache, but no error")
+	} else if !strings.Contains(err.Error(), "unexpected status: 500") {
+		t.Errorf("LoadIPBDataByURL: invalid error: %v", err)
+	}
+
+	// キャンセルされた場合はキャッシュのデータを使わない。
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.LoadIPBDataByURLContext(ctx, ts.URL+"/etag"); !errors.Is(err, context.Canceled) {
+		t.Errorf("LoadIPBDataByURLContext: canceled, but invalid error: %v", err)
+	}
+
+	// サーバに接続できない。キャッシュのデータからデータベースを作成する。
+	urls := []string{ts.URL + "/etag", ts.URL + "/last-modified"}
+	ts.Close()
+	db = NewDB(WithCacheDir(dir), WithSourceURLs(urls...))
+	if err := db.SetIPBData(); err != nil {
+		t.Fatalf("SetIPBData: unreachable with cache, but error: %v", err)
+	}
+	if sr := db.SearchInfo("114.48.0.1"); sr.Code != "JP" {
+		t.Errorf("SetIPBData: unreachable with cache, but invalid result: %v", sr)
+	}
+	if sr := db.SearchInfo("124.147.128.1"); sr.Code != "CN" {
+		t.Errorf("SetIPBData: unreachable with cache, but invalid result: %v", sr)
+	}
+}
+
+func TestReadCache(t *testing.T) {
+	// キャッシュディレクトリが未設定
+	db := NewDB()
+	if ce := db.readCache("http://example.com/a"); ce != nil {
+		t.Errorf("readCache: no cache dir, but %v", ce)
+	}
+	if err := db.writeCache("http://example.com/a", []byte("a"), http.Header{}); err != nil {
+		t.Errorf("writeCache: no cache dir, but error: %v", err)
+	}
+
+	db = NewDB(WithCacheDir(t.TempDir()))
+	if ce := db.readCache("http://example.com/a"); ce != nil {
+		t.Errorf("readCache: no cache, but %v", ce)
+	}
+
+	// 不正なキャッシュファイル
+	for _, b := range []string{"", "{}", "not json\nbody", `{"url":"http://example.com/b"}` + "\nbody"} {
+		if err := os.WriteFile(db.cachePath("http://example.com/a"), []byte(b), 0o644); err != nil {
+			t.Fatalf("failed to write cache: %v", err)
+		}
+		if ce := db.readCache("http://example.com/a"); ce != nil {
+			t.Errorf("readCache: invalid cache (%s), but %v", b, ce)
+		}
+	}
+
+	// 正常
+	h := http.Header{}
+	h.Set("ETag", `W/"abc"`)
+	if err := db.writeCache("http://example.com/a", []byte("line1\nline2\n"), h); err != nil {
+		t.Fatalf("writeCache: but error: %v", err)
+	}
+	ce := db.readCache("http://example.com/a")
+	if ce == nil {
+		t.Fatal("readCache: cache doesn't exist")
+	}
+	if ce.meta != (cacheMeta{URL: "http://example.com/a", ETag: `W/"abc"`}) {
+		t.Errorf("readCache: invalid meta: %v", ce.meta)
+	}
+	if string(ce.body) != "line1\nline2\n" {
+		t.Errorf("readCache: invalid body: %s", ce.body)
+	}
+}
+
+func TestLoadIPBDataByURLCacheWriteError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprintln(w, "apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated")
+	}))
+	defer ts.Close()
+
+	// キャッシュディレクトリのパスが通常のファイルで、キャッシュに保存できない。
+	dir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(dir, nil, 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	db := NewDB(WithCacheDir(dir), WithSourceURLs(ts.URL))
+	if err := db.LoadIPBDataByURL(ts.URL); err != nil {
+		t.Fatalf("LoadIPBDataByURL: cache isn't writable, but error: %v", err)
+	}
+	hs := db.tmpIB.headers
+	if len(hs) != 1 || len(hs[0].Warnings) != 1 || !strings.Contains(hs[0].Warnings[0].Error(), "write cache") {
+		t.Errorf("LoadIPBDataByURL: want a cache warning, but %v", hs)
+	}
+	db.ClearTmpIPBData()
+
+	// データベースの更新は失敗しない。
+	if err := db.SetIPBData(); err != nil {
+		t.Fatalf("SetIPBData: cache isn't writable, but error: %v", err)
+	}
+	if sr := db.SearchInfo("114.48.0.1"); sr.Code != "JP" {
+		t.Errorf("SetIPBData: cache isn't writable, but invalid result: %v", sr)
+	}
+	if hs := db.GetSourceHeaders(); len(hs) != 1 || len(hs[0].Warnings) != 1 {
+		t.Errorf("GetSourceHeaders: want a cache warning, but %v", hs)
+	}
+}
@@ -2,17 +2,20 @@ package ccipv4
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/netip"
 	"net/url"
 	"os"
 	"regexp"
 	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -29,6 +32,8 @@ const (
 	URLDelegatedLacnicExtendedLatest  string = "https://ftp.lacnic.net/pub/stats/lacnic/delegated-lacnic-extended-latest"
 	URLDelegatedRipenccExtendedLatest string = "https://ftp.ripe.net/pub/stats/ripencc/delegated-ripencc-extended-latest"
 	// エラーメッセージ
+	//
+	// Deprecated: エラーの種類は errors.Is と ErrInvalidIPAddress などで判定する。
 	ErrorMessageUnexpected               string = "unexpected error: %v: %v"
 	ErrorMessageWrongNumberOfFields      string = "the number(%d) of the line's fields is invalid: %v"
 	ErrorMessageInvalidIPAddress         string = "invalid ipv4 address:%v: %v"
@@ -38,9 +43,31 @@ const (
 	ErrorMessageSecondArgumentOutOfRange string = "second argument out of range"
 )
 
+// ipv4, ipv6, asn のデータに含まれるカントリーコードの種類の上限
+const maxCountryCodes = math.MaxUint16 + 1
+
 type block struct {
-	value   uint32
-	country uint8
+	value uint32
+	blockAttrs
+}
+
+// block の value 以外の属性。
+// 検索用のデータでは同じ属性を一つにまとめて共有する。
+type blockAttrs struct {
+	country  uint16
+	registry string
+	status   string
+	// 日付は YYYYMMDD の形式の数値。不明な場合は 0 。
+	date     uint32
+	opaqueID string
+}
+
+// 検索用に block の範囲を開始アドレス（ asn の場合は開始番号）順に並べたもの。
+// attrs は ipBlocks.attrs の添字。
+type ipRange struct {
+	start uint32
+	end   uint32
+	attrs uint32
 }
 
 type CountryCodeInfo struct {
@@ -48,23 +75,109 @@ type CountryCodeInfo struct {
 	AltName string
 }
 
+// 検索結果の状態
+type Status int
+
+const (
+	// 該当するブロックがない。
+	StatusNotFound Status = iota
+	// 該当するブロックがある。
+	StatusFound
+	// IP アドレスではない。
+	StatusInvalidAddress
+	// ループバックアドレス
+	StatusLoopback
+	// マルチキャストアドレス
+	StatusMulticast
+	// プライベートアドレス
+	StatusPrivate
+	// IANA IPv4 Special-Purpose Address Registry に登録された、
+	// グローバルに到達可能でないアドレス
+	StatusReserved
+)
+
+// 検索結果の状態を SearchResult.Message と同じ文字列で返す。
+func (s Status) String() string {
+	switch s {
+	case StatusNotFound:
+		return "Not Found"
+	case StatusFound:
+		return "Found"
+	case StatusInvalidAddress:
+		return "Invalid IP Address"
+	case StatusLoopback:
+		return "Loopback Address"
+	case StatusMulticast:
+		return "Multicast Address"
+	case StatusPrivate:
+		return "Private Address"
+	case StatusReserved:
+		return "Reserved Address"
+	}
+
+	return "Status(" + strconv.Itoa(int(s)) + ")"
+}
+
 type SearchResult struct {
-	IsFound    bool
-	Message    string
-	BlockStart string
-	BlockEnd   string
-	Code       string
-	Name       string
-	AltName    string
+	IsFound bool
+	Status  Status
+	// Status.String() と同じ。
+	// 互換性のために残しているので、判定には Status を使う。
+	Message string
+	// IANA IPv4 Special-Purpose Address Registry に登録されたアドレスの場合は、
+	// その登録内容。登録されていない場合は nil 。
+	SpecialPurpose *SpecialPurpose
+	BlockStart     string
+	BlockEnd       string
+	// 割当ブロックを覆う最小の個数のプレフィックスの一覧
+	Prefixes []netip.Prefix
+	// Prefixes のうち、検索したアドレスを含むもの
+	Prefix           netip.Prefix
+	Code             string
+	Name             string
+	AltName          string
+	Registry         string
+	AllocationStatus string
+	AllocatedOn      time.Time
+	OpaqueID         string
+	// 上書きのデータベースで決まった場合は、その上書き。それ以外は nil 。
+	// 上書きで決まった場合、 Registry などの RIR のデータによる項目は空。
+	Override *Override
+}
+
+type ASNResult struct {
+	IsFound bool
+	// StatusFound または StatusNotFound
+	Status Status
+	// Status.String() と同じ。
+	// 互換性のために残しているので、判定には Status を使う。
+	Message          string
+	ASNStart         uint32
+	ASNEnd           uint32
+	Code             string
+	Name             string
+	AltName          string
+	Registry         string
+	AllocationStatus string
+	AllocatedOn      time.Time
+	OpaqueID         string
 }
 
 type ipBlocks struct {
 	l             sync.RWMutex
-	data          map[uint8]map[uint8]map[uint8]map[uint8]block
-	dicCCStrToInt map[string]uint8
-	dicCCIntToStr map[uint8]string
+	data          map[uint32]block
+	data6         map[netip.Prefix]block
+	asn           map[uint32]block
+	ranges        []ipRange
+	asnRanges     []ipRange
+	attrs         []blockAttrs
+	dicCCStrToInt map[string]uint16
+	dicCCIntToStr map[uint16]string
 	totalBlocks   map[string]int
 	totalValue    map[string]int
+	dicStr        map[string]string
+	headers       []SourceHeader
+	diagnostics   []Diagnostic
 }
 
 type countryCodes struct {
@@ -73,18 +186,37 @@ type countryCodes struct {
 }
 
 type DB struct {
-	ib     ipBlocks
-	tmpIB  ipBlocks
-	cc     countryCodes
-	tmpCC  countryCodes
-	reg    *regexp.Regexp
-	urlRIR []string
+	ib          ipBlocks
+	tmpIB       ipBlocks
+	cc          countryCodes
+	tmpCC       countryCodes
+	reg         *regexp.Regexp
+	urlRIR      []string
+	client      *http.Client
+	concurrency int
+	userAgent   string
+	cacheDir    string
+	checksum    bool
+	headerCheck HeaderCheck
+	lenient     bool
+	maxInvalid  int
+	switchHooks []func(*DB)
+	ov          overrides
 }
 
+// NewDB でデータベースを取得する際の設定
+type Option func(*DB)
+
 var regForCountryCode = regexp.MustCompile(`^[A-Z]{2}$`)
 
 // 初期状態のデータベースを取得する。
 func GetDB() *DB {
+	return NewDB()
+}
+
+// 初期状態のデータベースを取得する。
+// opts で既定の設定を変更できる。
+func NewDB(opts ...Option) *DB {
 	var db DB = DB{
 		tmpCC: countryCodes{
 			data: map[string]CountryCodeInfo{},
@@ -97,29 +229,111 @@ func GetDB() *DB {
 			URLDelegatedLacnicExtendedLatest,
 			URLDelegatedAfrinicExtendedLatest,
 		},
+		client:      newDefaultHTTPClient(),
+		concurrency: 3,
+	}
+	for _, opt := range opts {
+		opt(&db)
 	}
 	db.ClearTmpIPBData()
 
 	return &db
 }
 
+// データの取得に使用する http.Client を設定する。
+// nil の場合は既定のものを使用する。
+func WithHTTPClient(c *http.Client) Option {
+	return func(db *DB) {
+		db.client = c
+	}
+}
+
+// SetIPBData で取得する delegation file の URL を設定する。
+// 既定では各 RIR の最新版の URL 。
+func WithSourceURLs(urls ...string) Option {
+	return func(db *DB) {
+		db.urlRIR = slices.Clone(urls)
+	}
+}
+
+// SetIPBData で同時に取得する URL の数の上限を設定する。
+// 既定では 3 。1 未満の場合は制限しない。
+func WithConcurrency(n int) Option {
+	return func(db *DB) {
+		db.concurrency = n
+	}
+}
+
+// データの取得時に送信する User-Agent を設定する。
+// 空文字列の場合は http.Client の既定のものを使用する。
+func WithUserAgent(ua string) Option {
+	return func(db *DB) {
+		db.userAgent = ua
+	}
+}
+
+// ダウンロードしたデータを保存するキャッシュディレクトリを設定する。
+// 設定した場合は、保存したデータの ETag / Last-Modified を使って条件付きで取得し、
+// 更新がなければ保存したデータを使う。
+// URL からデータを取得できない場合も保存したデータを使う。
+// 空文字列の場合はキャッシュしない。
+func WithCacheDir(dir string) Option {
+	return func(db *DB) {
+		db.cacheDir = dir
+	}
+}
+
+// 取得したデータを、同じ場所に公開されている MD5 のチェックサムファイル
+// （ URL の末尾に .md5 を付けたもの）で検証するかを設定する。
+// 一致しない場合は *ChecksumError を返し、データを読み込まない。
+// 既定では検証しない。
+func WithChecksumVerification(enabled bool) Option {
+	return func(db *DB) {
+		db.checksum = enabled
+	}
+}
+
+// SwitchIPBData で検索用データベースを更新した後に実行する関数を追加する。
+// 関数はロックの解除後に、追加した順に同じ goroutine で実行する。
+// SetIPBData などから SwitchIPBData を実行した場合も同じ。
+func WithSwitchHook(fn func(db *DB)) Option {
+	return func(db *DB) {
+		db.switchHooks = append(db.switchHooks, fn)
+	}
+}
+
+// 既定の http.Client を返す。
+func newDefaultHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: 60 * time.Second,
+	}
+}
+
 // 一時保存用データベースを空データベースにする。
 func (db *DB) ClearTmpIPBData() {
-	db.tmpIB.data = map[uint8]map[uint8]map[uint8]map[uint8]block{}
-	db.tmpIB.dicCCIntToStr = map[uint8]string{}
-	db.tmpIB.dicCCStrToInt = map[string]uint8{}
+	db.tmpIB.data = map[uint32]block{}
+	db.tmpIB.data6 = map[netip.Prefix]block{}
+	db.tmpIB.asn = map[uint32]block{}
+	db.tmpIB.dicCCIntToStr = map[uint16]string{}
+	db.tmpIB.dicCCStrToInt = map[string]uint16{}
 	db.tmpIB.totalBlocks = map[string]int{"ALL": 0}
 	db.tmpIB.totalValue = map[string]int{"ALL": 0}
+	db.tmpIB.dicStr = map[string]string{}
+	db.tmpIB.headers = nil
+	db.tmpIB.diagnostics = nil
 }
 
 // io.Reader を使って RIR statistics exchange format を読み込む。
 // RIR statistics exchange format については下記を参照。
 // http://www.apnic.net/db/rir-stats-format.html
-func (db *DB) setTmpIPBlocks(r io.Reader) error {
+// source は読み込むデータの URL またはファイルのパスで、
+// header の情報とともに記録する。
+func (db *DB) setTmpIPBlocks(r io.Reader, source string) error {
 	var (
-		countForDic uint8
-		reader      *csv.Reader = csv.NewReader(r)
-		group8Bits  []byte      = make([]byte, 4)
+		reader *csv.Reader  = csv.NewReader(r)
+		header SourceHeader = newSourceHeader(source)
+		// 異常のある record の件数
+		invalid int
 	)
 	// ファイルを csv として読込。
 	// format に従い、コメント・フィールド区切りの文字を設定。
@@ -130,105 +344,250 @@ func (db *DB) setTmpIPBlocks(r io.Reader) error {
 	db.tmpIB.l.Lock()
 	defer db.tmpIB.l.Unlock()
 
-	// カントリーコード文字列にひも付けする uint8 用の値（後述）を
-	// 設定するために使用。
-	countForDic = uint8(len(db.tmpIB.dicCCStrToInt))
+	// 異常のある record の読込元と行番号を設定し、 skipRecord で処理する。
+	skip := func(line []string, pe *ParseError) error {
+		pe.Source = source
+		pe.Line = recordLine(reader, line, pe.Err)
+		pe.Record = line
+		return db.skipRecord(pe, &invalid)
+	}
 
 	// ファイルを一行単位で読込。
 	// 異常が発生した場合はその行で処理を中止し、
 	// 一時保存用データベースを空にする。
+	// lenient mode の場合は、異常のある record を読み飛ばして診断情報を記録する。
 	for {
 		line, err := reader.Read()
 		if err != nil {
 			if err == io.EOF {
 				break
 			} else {
-				// Field が６つで最後に文字列 "summary" が設定されている場合は
-				// record ではなく header の summary line で処理対象外だが異常
-				// ではないので invalidDataSet に追記しない。
-				if len(line) == 6 && line[5] == "summary" {
+				if len(line) < 6 || !errors.Is(err, csv.ErrFieldCount) {
+					if err := skip(line, newParseError(-1, ErrMalformedRecord, err)); err != nil {
+						return err
+					}
 					continue
 				}
-				if len(line) < 6 || !strings.Contains(err.Error(), "wrong number of fields") {
-					db.ClearTmpIPBData()
-					return fmt.Errorf(ErrorMessageUnexpected, err, line)
-				}
 			}
 		}
+		// Field が６つで最後に文字列 "summary" が設定されている場合は
+		// record ではなく header の summary line なので、件数のみ記録する。
+		if len(line) == 6 && line[5] == "summary" {
+			header.addSummary(line)
+			continue
+		}
 		// 先頭の Field が registry ではなく version の場合は
-		// record ではなく header の version line で処理対象外。
+		// record ではなく header の version line なので、内容のみ記録する。
 		if !slices.Contains([]string{"afrinic", "apnic", "arin", "iana", "lacnic", "ripencc"}, line[0]) {
+			if len(line) >= 7 && regForVersion.MatchString(line[0]) {
+				header.setVersion(line)
+			}
 			continue
 		}
-		// Record format の３番めの Field は type 。
-		// asn,ipv4,ipv6 のいずれか。
-		if line[2] == "ipv4" {
-			// Record format の Field の個数は7以上。
-			if len(line) < 7 {
-				db.ClearTmpIPBData()
-				return fmt.Errorf(ErrorMessageWrongNumberOfFields, len(line), line)
-			}
-			// Record format の４番めの Field は start 。
-			// 対象範囲の最初のアドレスを示す。
-			ad, err := netip.ParseAddr(line[3])
-			if err != nil {
-				db.ClearTmpIPBData()
-				return fmt.Errorf(ErrorMessageInvalidIPAddress, err, line)
-			}
-			// 検索に使用するため、start のアドレスを８ビットで分割し、
-			// ipBlocks のマップのキーとする。
-			group8Bits = ad.AsSlice()
-			if _, ok := db.tmpIB.data[group8Bits[0]]; !ok {
-				db.tmpIB.data[group8Bits[0]] = map[uint8]map[uint8]map[uint8]block{}
-			}
-			if _, ok := db.tmpIB.data[group8Bits[0]][group8Bits[1]]; !ok {
-				db.tmpIB.data[group8Bits[0]][group8Bits[1]] = map[uint8]map[uint8]block{}
-			}
-			if _, ok := db.tmpIB.data[group8Bits[0]][group8Bits[1]][group8Bits[2]]; !ok {
-				db.tmpIB.data[group8Bits[0]][group8Bits[1]][group8Bits[2]] = map[uint8]block{}
-			}
-			// ISO 3166 2-letter に定義されるカントリーコードを示す。
-			// メモリ使用量削減のため、文字列からひも付けされた uint8 に
-			// 変換して格納。
-			if _, ok := db.tmpIB.dicCCStrToInt[line[1]]; !ok {
-				db.tmpIB.dicCCStrToInt[line[1]] = countForDic
-				countForDic++
-			}
-			// Record format の５番めの Field は value 。
-			// ipv4 の場合、対象範囲のアドレスの個数を示す。
-			// int に変換できる場合。
-			v, err := strconv.Atoi(line[4])
-			if err == nil {
-				// ここまで異常がなければ各データを格納する。
-				if _, ok := db.tmpIB.data[group8Bits[0]][group8Bits[1]][group8Bits[2]][group8Bits[3]]; ok {
-					db.tmpIB.totalValue["ALL"] = db.tmpIB.totalValue["ALL"] - int(db.tmpIB.data[group8Bits[0]][group8Bits[1]][group8Bits[2]][group8Bits[3]].value)
-					db.tmpIB.totalValue[line[1]] = db.tmpIB.totalValue[line[1]] - int(db.tmpIB.data[group8Bits[0]][group8Bits[1]][group8Bits[2]][group8Bits[3]].value)
-				} else {
-					db.tmpIB.totalBlocks["ALL"]++
-					db.tmpIB.totalBlocks[line[1]]++
-				}
-				db.tmpIB.totalValue["ALL"] = db.tmpIB.totalValue["ALL"] + v
-				db.tmpIB.totalValue[line[1]] = db.tmpIB.totalValue[line[1]] + v
-				db.tmpIB.data[group8Bits[0]][group8Bits[1]][group8Bits[2]][group8Bits[3]] = block{
-					country: db.tmpIB.dicCCStrToInt[line[1]],
-					// uint32 に変換して格納。
-					value: uint32(v),
-				}
-			} else {
-				db.ClearTmpIPBData()
-				return fmt.Errorf(ErrorMessageInvalidValue, err, line)
+		// header と比較するため、type ごとに record の件数を数える。
+		header.Counts[line[2]]++
+		if pe := db.setTmpIPBlock(line); pe != nil {
+			if err := skip(line, pe); err != nil {
+				return err
 			}
 		}
 	}
 
-	// ひも付けされた uint8 からカントリーコードの文字列を逆引きするために使用。
-	for k := range db.tmpIB.dicCCStrToInt {
-		db.tmpIB.dicCCIntToStr[db.tmpIB.dicCCStrToInt[k]] = k
+	// header で宣言された件数と読み込んだ件数を検証する。
+	if err := db.checkHeader(&header); err != nil {
+		db.ClearTmpIPBData()
+		return err
 	}
+	db.tmpIB.addSource(header)
 
 	return nil
 }
 
+// record の type に従い、一時保存用データベースに格納する。
+// 異常がある場合は *ParseError を返す。
+// 一時保存用データベースのロックは呼出元で行う。
+func (db *DB) setTmpIPBlock(line []string) *ParseError {
+	// Record format の３番めの Field は type 。
+	// asn,ipv4,ipv6 のいずれか。
+	if line[2] == "ipv4" {
+		// Record format の Field の個数は7以上。
+		if len(line) < 7 {
+			return newParseError(-1, ErrWrongNumberOfFields, fmt.Errorf("%d fields", len(line)))
+		}
+		// Record format の４番めの Field は start 。
+		// 対象範囲の最初のアドレスを示す。
+		ad, err := netip.ParseAddr(line[3])
+		if err != nil {
+			return newParseError(3, ErrInvalidIPAddress, err)
+		}
+		if !ad.Is4() {
+			return newParseError(3, ErrInvalidIPAddress, errors.New("not ipv4 address"))
+		}
+		// start のアドレスを uint32 に変換し、 ipBlocks のマップのキーとする。
+		start := addrToUint32(ad)
+		// ISO 3166 2-letter に定義されるカントリーコードを示す。
+		// メモリ使用量削減のため、文字列からひも付けされた uint16 に
+		// 変換して格納。
+		if !db.tmpIB.registerCountryCode(line[1]) {
+			return newParseError(1, ErrTooManyCountryCodes, fmt.Errorf("more than %d", maxCountryCodes))
+		}
+		// Record format の５番めの Field は value 。
+		// ipv4 の場合、対象範囲のアドレスの個数を示す。
+		// 範囲が 255.255.255.255 を超える場合は異常とする。
+		v, err := strconv.ParseUint(line[4], 10, 32)
+		if err == nil && (v == 0 || uint64(start)+v-1 > 4294967295) {
+			err = errors.New("value out of range")
+		}
+		if err != nil {
+			return newParseError(4, ErrInvalidValue, err)
+		}
+		// ここまで異常がなければ各データを格納する。
+		if b, ok := db.tmpIB.data[start]; ok {
+			db.tmpIB.totalValue["ALL"] = db.tmpIB.totalValue["ALL"] - int(b.value)
+			db.tmpIB.totalValue[line[1]] = db.tmpIB.totalValue[line[1]] - int(b.value)
+		} else {
+			db.tmpIB.totalBlocks["ALL"]++
+			db.tmpIB.totalBlocks[line[1]]++
+		}
+		db.tmpIB.totalValue["ALL"] = db.tmpIB.totalValue["ALL"] + int(v)
+		db.tmpIB.totalValue[line[1]] = db.tmpIB.totalValue[line[1]] + int(v)
+		// uint32 に変換して格納。
+		db.tmpIB.data[start] = db.tmpIB.newBlock(line, uint32(v))
+	} else if line[2] == "ipv6" {
+		// Record format の Field の個数は7以上。
+		if len(line) < 7 {
+			return newParseError(-1, ErrWrongNumberOfFields, fmt.Errorf("%d fields", len(line)))
+		}
+		// ipv6 の場合、value は対象範囲のアドレスの個数ではなく
+		// プレフィックス長を示すので、start と合わせてプレフィックスとする。
+		p, err := netip.ParsePrefix(line[3] + "/" + line[4])
+		if err != nil {
+			return newParseError(3, ErrInvalidIPv6Prefix, err)
+		}
+		if !p.Addr().Is6() {
+			return newParseError(3, ErrInvalidIPv6Prefix, errors.New("not ipv6 address"))
+		}
+		// start がプレフィックスの先頭のアドレスでない場合は検索できないので異常とする。
+		if p != p.Masked() {
+			return newParseError(3, ErrInvalidIPv6Prefix, errors.New("start is not the first address of the prefix"))
+		}
+		if !db.tmpIB.registerCountryCode(line[1]) {
+			return newParseError(1, ErrTooManyCountryCodes, fmt.Errorf("more than %d", maxCountryCodes))
+		}
+		db.tmpIB.data6[p] = db.tmpIB.newBlock(line, uint32(p.Bits()))
+	} else if line[2] == "asn" {
+		// Record format の Field の個数は7以上。
+		if len(line) < 7 {
+			return newParseError(-1, ErrWrongNumberOfFields, fmt.Errorf("%d fields", len(line)))
+		}
+		// asn の場合、start は最初の AS 番号。
+		start, err := strconv.ParseUint(line[3], 10, 32)
+		if err != nil {
+			return newParseError(3, ErrInvalidASN, err)
+		}
+		// value は対象範囲の AS 番号の個数。
+		// 範囲が 4294967295 を超える場合は異常とする。
+		v, err := strconv.ParseUint(line[4], 10, 32)
+		if err == nil && (v == 0 || start+v-1 > 4294967295) {
+			err = errors.New("value out of range")
+		}
+		if err != nil {
+			return newParseError(4, ErrInvalidValue, err)
+		}
+		if !db.tmpIB.registerCountryCode(line[1]) {
+			return newParseError(1, ErrTooManyCountryCodes, fmt.Errorf("more than %d", maxCountryCodes))
+		}
+		db.tmpIB.asn[uint32(start)] = db.tmpIB.newBlock(line, uint32(v))
+	}
+
+	return nil
+}
+
+// 読込元の header の情報を記録し、
+// カントリーコードの文字列を逆引きする辞書を更新する。
+// 一時保存用データベースへの各読込の最後に、ロックしたまま呼び出す。
+func (ib *ipBlocks) addSource(header SourceHeader) {
+	ib.headers = append(ib.headers, header)
+
+	// ひも付けされた uint16 からカントリーコードの文字列を逆引きするために使用。
+	for k := range ib.dicCCStrToInt {
+		ib.dicCCIntToStr[ib.dicCCStrToInt[k]] = k
+	}
+}
+
+// カントリーコードを辞書に登録し、ひも付けする uint16 の値を割り当てる。
+// 登録済の場合は何もしない。
+// 登録数が上限に達している場合は、既存の値と重複させずに false を返す。
+func (ib *ipBlocks) registerCountryCode(cc string) bool {
+	if _, ok := ib.dicCCStrToInt[cc]; ok {
+		return true
+	}
+	n := len(ib.dicCCStrToInt)
+	if n >= maxCountryCodes {
+		return false
+	}
+	ib.dicCCStrToInt[cc] = uint16(n)
+
+	return true
+}
+
+// record の各 Field から block を作成する。
+// カントリーコードは辞書に登録済であること。
+func (ib *ipBlocks) newBlock(line []string, value uint32) block {
+	b := block{
+		value: value,
+		blockAttrs: blockAttrs{
+			country:  ib.dicCCStrToInt[line[1]],
+			registry: ib.intern(line[0]),
+			// Record format の６番めの Field は date 。
+			date: parseDate(line[5]),
+			// Record format の７番めの Field は status 。
+			status: ib.intern(line[6]),
+		},
+	}
+	// extended format の場合、８番めの Field は opaque-id 。
+	if len(line) > 7 {
+		b.opaqueID = ib.intern(line[7])
+	}
+
+	return b
+}
+
+// 同じ内容の文字列を一つにまとめてメモリ使用量を削減する。
+// csv.Reader が返す Field は行全体の文字列を共有しているため、
+// 複製したものを格納する。
+func (ib *ipBlocks) intern(s string) string {
+	if ib.dicStr == nil {
+		ib.dicStr = map[string]string{}
+	}
+	if v, ok := ib.dicStr[s]; ok {
+		return v
+	}
+	v := strings.Clone(s)
+	ib.dicStr[v] = v
+
+	return v
+}
+
+// YYYYMMDD の形式の日付を数値に変換する。
+// 空文字列や日付として解釈できない場合は不明として 0 を返す。
+func parseDate(s string) uint32 {
+	t, err := time.Parse("20060102", s)
+	if err != nil {
+		return 0
+	}
+	return uint32(t.Year()*10000 + int(t.Month())*100 + t.Day())
+}
+
+// 数値に変換した日付を time.Time に戻す。
+// 不明（ 0 ）の場合はゼロ値を返す。
+func dateToTime(d uint32) time.Time {
+	if d == 0 {
+		return time.Time{}
+	}
+	return time.Date(int(d/10000), time.Month(d/100%100), int(d%100), 0, 0, 0, 0, time.UTC)
+}
+
 // カントリーコードの一覧ファイルを読み込む。
 func (db *DB) SetTmpCountryCodes(r io.Reader) error {
 	var reader *csv.Reader = csv.NewReader(r)
@@ -252,18 +611,18 @@ func (db *DB) SetTmpCountryCodes(r io.Reader) error {
 				break
 			} else {
 				db.tmpCC.data = map[string]CountryCodeInfo{}
-				return fmt.Errorf(ErrorMessageUnexpected, err, line)
+				return &ParseError{Line: recordLine(reader, line, err), Field: -1, Record: line, Err: fmt.Errorf("%w: %w", ErrMalformedRecord, err)}
 			}
 		}
 		// フィールド数は３。
 		if len(line) != 3 {
 			db.tmpCC.data = map[string]CountryCodeInfo{}
-			return fmt.Errorf(ErrorMessageWrongNumberOfFields, len(line), line)
+			return &ParseError{Line: recordLine(reader, line, nil), Field: -1, Record: line, Err: fmt.Errorf("%w: %d fields", ErrWrongNumberOfFields, len(line))}
 		}
 		// 先頭フィールドがカントリーコードで、英大文字２文字。
 		if !db.reg.MatchString(line[0]) {
 			db.tmpCC.data = map[string]CountryCodeInfo{}
-			return fmt.Errorf(ErrorMessageInvalidCountryCode, line[0], line)
+			return &ParseError{Line: recordLine(reader, line, nil), Field: 0, Record: line, Err: fmt.Errorf("%w: %s", ErrInvalidCountryCode, line[0])}
 		}
 
 		// カントリーコードをキーとする listCCName に
@@ -280,8 +639,16 @@ func (db *DB) SetTmpCountryCodes(r io.Reader) error {
 // 指定された URL のデータを取得し、
 // 一時保存用データベースに格納する。
 func (db *DB) LoadIPBDataByURL(u string) error {
-	var c *http.Client = &http.Client{
-		Timeout: 60 * time.Second,
+	return db.LoadIPBDataByURLContext(context.Background(), u)
+}
+
+// 指定された URL のデータを取得し、
+// 一時保存用データベースに格納する。
+// ctx がキャンセルされるか期限に達した場合は、取得を中止してエラーを返す。
+func (db *DB) LoadIPBDataByURLContext(ctx context.Context, u string) error {
+	var c *http.Client = db.client
+	if c == nil {
+		c = newDefaultHTTPClient()
 	}
 
 	// 指定された URL が適正なものかを確認
@@ -290,27 +657,106 @@ func (db *DB) LoadIPBDataByURL(u string) error {
 	}
 
 	// URL からデータを取得する。
-	resp, err := c.Get(u)
+	b, header, err := db.fetch(ctx, c, u)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	// レスポンスのボディはを全て読み取ってバッファに格納する。
-	b, err := io.ReadAll(resp.Body)
-	if err != nil {
+	// 取得後にキャンセルされた場合は書き込まない。
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
+	// 新しく取得したデータは、設定に従いチェックサムを検証する。
+	// キャッシュのデータは保存時に検証済。
+	if header != nil && db.checksum {
+		if err := db.verifyChecksum(ctx, c, u, b); err != nil {
+			return err
+		}
+	}
+
 	// 一時保存用データベースに書き込む。
-	err = db.setTmpIPBlocks(bytes.NewBuffer(b))
+	err = db.setTmpIPBlocks(bytes.NewBuffer(b), u)
 	if err != nil {
 		return err
 	}
 
+	// 新しく取得したデータは、正常に読み込めた場合のみキャッシュに保存する。
+	// キャッシュに保存できない場合もデータは読み込めているので、
+	// エラーは返さずに SourceHeader.Warnings に記録する。
+	if header != nil {
+		if err := db.writeCache(u, b, header); err != nil {
+			db.addSourceWarning(u, fmt.Errorf("write cache: %w", err))
+		}
+	}
+
 	return nil
 }
 
+// 設定済の User-Agent を付けた GET のリクエストを作成する。
+func (db *DB) newRequest(ctx context.Context, u string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if db.userAgent != "" {
+		req.Header.Set("User-Agent", db.userAgent)
+	}
+
+	return req, nil
+}
+
+// URL からデータを取得する。
+// キャッシュがある場合は条件付きで取得し、更新がなければキャッシュのデータを返す。
+// キャンセルされた場合を除き、取得できない場合もキャッシュのデータを返す。
+// 新しく取得したデータの場合は、応答のヘッダも返す。
+func (db *DB) fetch(ctx context.Context, c *http.Client, u string) ([]byte, http.Header, error) {
+	ce := db.readCache(u)
+
+	req, err := db.newRequest(ctx, u)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ce != nil {
+		if ce.meta.ETag != "" {
+			req.Header.Set("If-None-Match", ce.meta.ETag)
+		}
+		if ce.meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", ce.meta.LastModified)
+		}
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		if ce != nil && ctx.Err() == nil {
+			return ce.body, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	// 更新なし
+	case resp.StatusCode == http.StatusNotModified && ce != nil:
+		return ce.body, nil, nil
+	case resp.StatusCode == http.StatusOK:
+		// レスポンスのボディはを全て読み取ってバッファに格納する。
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			if ce != nil && ctx.Err() == nil {
+				return ce.body, nil, nil
+			}
+			return nil, nil, err
+		}
+		return b, resp.Header, nil
+	}
+
+	if ce != nil {
+		return ce.body, nil, nil
+	}
+	return nil, nil, &StatusError{URL: u, StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
 // 指定のファイルを読んでIPアドレスの国別ブロックのデータを取得し、
 // 一時保存用データベースに格納する。
 func (db *DB) LoadIPBDataByFile(ipbFile string) error {
@@ -320,7 +766,7 @@ func (db *DB) LoadIPBDataByFile(ipbFile string) error {
 	}
 	defer fp.Close()
 
-	err = db.setTmpIPBlocks(fp)
+	err = db.setTmpIPBlocks(fp, ipbFile)
 	if err != nil {
 		return err
 	}
@@ -332,33 +778,100 @@ func (db *DB) LoadIPBDataByFile(ipbFile string) error {
 // 国別ブロックデータベースをロックし、
 // 一時保存用のデータを検索用に渡す。
 // 一時保存用のデータは空にする。
+// WithSwitchHook で追加した関数があれば、ロックの解除後に実行する。
 func (db *DB) SwitchIPBData() {
 	db.tmpIB.l.Lock()
 	db.ib.l.Lock()
-	db.ib.data = db.tmpIB.data
+	db.ib.data6 = db.tmpIB.data6
+	db.ib.ranges, db.ib.asnRanges, db.ib.attrs = buildRanges(db.tmpIB.data, db.tmpIB.asn)
 	db.ib.dicCCIntToStr = db.tmpIB.dicCCIntToStr
 	db.ib.dicCCStrToInt = db.tmpIB.dicCCStrToInt
 	db.ib.totalBlocks = db.tmpIB.totalBlocks
 	db.ib.totalValue = db.tmpIB.totalValue
+	db.ib.headers = db.tmpIB.headers
+	db.ib.diagnostics = db.tmpIB.diagnostics
 	db.ClearTmpIPBData()
 	db.ib.l.Unlock()
 	db.tmpIB.l.Unlock()
+
+	for _, fn := range db.switchHooks {
+		fn(db)
+	}
+}
+
+// ipv4 と asn のデータをそれぞれ開始アドレス・開始番号順に並べ、
+// 検索用の配列を作成する。
+// 同じ属性は一つにまとめて返し、各範囲からは添字で参照する。
+func buildRanges(data map[uint32]block, asn map[uint32]block) ([]ipRange, []ipRange, []blockAttrs) {
+	var (
+		attrs []blockAttrs
+		index map[blockAttrs]uint32 = map[blockAttrs]uint32{}
+	)
+
+	build := func(m map[uint32]block) []ipRange {
+		r := make([]ipRange, 0, len(m))
+		for k, v := range m {
+			i, ok := index[v.blockAttrs]
+			if !ok {
+				i = uint32(len(attrs))
+				index[v.blockAttrs] = i
+				attrs = append(attrs, v.blockAttrs)
+			}
+			r = append(r, ipRange{start: k, end: k + v.value - 1, attrs: i})
+		}
+		slices.SortFunc(r, func(a, b ipRange) int {
+			if a.start < b.start {
+				return -1
+			}
+			if a.start > b.start {
+				return 1
+			}
+			return 0
+		})
+		return r
+	}
+
+	return build(data), build(asn), attrs
+}
+
+// 開始アドレス（開始番号）が x 以下で最大の範囲の添字を返す。
+// 該当する範囲がない場合は -1 を返す。
+func searchRange(r []ipRange, x uint32) int {
+	return sort.Search(len(r), func(i int) bool {
+		return r[i].start > x
+	}) - 1
 }
 
 // 初期設定済の URL から各 RIR の最新版 delegation file を取得し、
 // IPアドレスの国別ブロックデータベースを更新する。
 func (db *DB) SetIPBData() error {
-	var g errgroup.Group
+	return db.SetIPBDataContext(context.Background())
+}
 
-	g.SetLimit(3)
+// 初期設定済の URL から各 RIR の最新版 delegation file を取得し、
+// IPアドレスの国別ブロックデータベースを更新する。
+// いずれかの RIR で異常が発生した場合は、他の RIR の取得も中止する。
+// ctx がキャンセルされるか期限に達した場合は、取得を中止してエラーを返す。
+// いずれの場合も検索用データベースは更新しない。
+func (db *DB) SetIPBDataContext(ctx context.Context) error {
+	g, gctx := errgroup.WithContext(ctx)
+
+	if db.concurrency > 0 {
+		g.SetLimit(db.concurrency)
+	}
 
 	for i := range db.urlRIR {
 		x := i
 		g.Go(func() error {
-			return db.LoadIPBDataByURL(db.urlRIR[x])
+			return db.LoadIPBDataByURLContext(gctx, db.urlRIR[x])
 		})
 	}
-	if err := g.Wait(); err != nil {
+	err := g.Wait()
+	if err == nil {
+		// 全ての取得後にキャンセルされた場合
+		err = ctx.Err()
+	}
+	if err != nil {
 		db.tmpIB.l.Lock()
 		defer db.tmpIB.l.Unlock()
 		db.ClearTmpIPBData()
@@ -403,272 +916,195 @@ func (db *DB) InitCCDataByFile(ccFile string) error {
 	return nil
 }
 
-// IPアドレスの最初の8ビットについて、
-// データベースに一致する値があるか判定
-func (db *DB) checkFirst8Bit(as4 [4]byte) ([4]byte, bool) {
-	// 一致があればそのまま引数を返す。
-	if _, ok := db.ib.data[as4[0]]; ok {
-		return as4, true
+// 渡された文字列のIPアドレスからカントリーコードの情報を返す。
+// IPv4 射影アドレス（::ffff:0:0/96）は IPv4 アドレスとして検索する。
+// 上書きのデータベースに該当するものがある場合は、 RIR のデータより優先する。
+func (db *DB) SearchInfo(adrs string) SearchResult {
+	target, err := netip.ParseAddr(adrs)
+	// 渡された文字列をパースしてエラー
+	if err != nil {
+		return SearchResult{Status: StatusInvalidAddress, Message: StatusInvalidAddress.String()}
 	}
-	// 元の値から1づつ減らして
-	// 一致する値を検索
-	i := as4[0]
-	for {
-		if _, ok := db.ib.data[i]; ok {
-			as4[0] = i
-			as4[1] = 255
-			as4[2] = 255
-			as4[3] = 255
-			return as4, true
-		}
-		if i == 0 {
-			return as4, false
-		}
-		i--
+	// IPv4 射影アドレスは IPv4 アドレスに、ゾーンは取り除く。
+	target = target.Unmap().WithZone("")
+	st, i := classifyAddr(target)
+	// IANA IPv4 Special-Purpose Address Registry の登録内容
+	var special *SpecialPurpose
+	if i >= 0 {
+		sp := specialPurposes[i]
+		special = &sp
 	}
-}
-
-// IPアドレスの2番めの8ビットについて、
-// データベースに一致する値があるか判定
-func (db *DB) checkSecond8Bit(as4 [4]byte) ([4]byte, bool) {
-	// 一致があればそのまま引数を返す。
-	if _, ok := db.ib.data[as4[0]][as4[1]]; ok {
-		return as4, true
+	// 上書きはプライベートアドレスなどにも指定できる。
+	if ov, ok := db.lookupOverride(target); ok {
+		return db.overrideResult(ov, special)
 	}
-	// データベースの2番めの8ビットが0のみの場合は0
-	// 以降の8ビットは255
-	if _, ok := db.ib.data[as4[0]][0]; ok && len(db.ib.data[as4[0]]) == 1 {
-		as4[1] = 0
-		as4[2] = 255
-		as4[3] = 255
-		return as4, true
+	// ループバックアドレスなど、検索せずに結果が決まる場合
+	if st != StatusNotFound {
+		return SearchResult{Status: st, Message: st.String(), SpecialPurpose: special}
 	}
-	// それ以外は元の値から1づつ減らして一致する値を検索
-	i := as4[1]
-	for {
-		// 一致した場合、以降の8ビットは255
-		if _, ok := db.ib.data[as4[0]][i]; ok {
-			as4[1] = i
-			as4[2] = 255
-			as4[3] = 255
-			return as4, true
-		}
-		// 一致がなく、0に達した場合。
-		if i == 0 {
-			return as4, false
-		}
-		i--
+	// IPv6アドレス
+	if target.Is6() {
+		return db.searchInfo6(target)
 	}
-}
 
-// IPアドレスの3番めの8ビットについて、
-// データベースに一致する値があるか判定
-func (db *DB) checkThird8Bit(as4 [4]byte) ([4]byte, bool) {
-	// 一致があればそのまま引数を返す。
-	if _, ok := db.ib.data[as4[0]][as4[1]][as4[2]]; ok {
-		return as4, true
+	db.ib.l.RLock()
+	defer db.ib.l.RUnlock()
+
+	// 渡されたIPv4アドレスが所属ブロックの範囲に含まれない場合は、
+	// 情報なしとして返す。
+	x := addrToUint32(target)
+	r, b, ok := db.ib.view().lookup4(x)
+	if !ok {
+		return SearchResult{Status: StatusNotFound, Message: StatusNotFound.String(), SpecialPurpose: special}
 	}
-	// 3番めの8ビットが0のみの場合は0
-	// 以降の8ビットは255
-	if _, ok := db.ib.data[as4[0]][as4[1]][0]; ok && len(db.ib.data[as4[0]][as4[1]]) == 1 {
-		as4[2] = 0
-		as4[3] = 255
-		return as4, true
+
+	// 渡されたIPv4アドレスが所属ブロックの範囲に含まれる場合は、
+	// カントリーコード他該当情報を返す。
+	sr := SearchResult{
+		IsFound:          true,
+		Status:           StatusFound,
+		Message:          StatusFound.String(),
+		SpecialPurpose:   special,
+		BlockStart:       uint32ToAddr(r.start).String(),
+		BlockEnd:         uint32ToAddr(r.end).String(),
+		Prefixes:         rangeToPrefixes4(r.start, r.end),
+		Prefix:           prefixContaining4(r.start, r.end, x),
+		Code:             db.ib.dicCCIntToStr[b.country],
+		Registry:         b.registry,
+		AllocationStatus: b.status,
+		AllocatedOn:      dateToTime(b.date),
+		OpaqueID:         b.opaqueID,
 	}
-	// それ以外は元の値から1づつ減らして一致する値を検索
-	i := as4[2]
-	for {
-		// 一致した場合、以降の8ビットは255
-		if _, ok := db.ib.data[as4[0]][as4[1]][i]; ok {
-			as4[2] = i
-			as4[3] = 255
-			return as4, true
-		}
-		// 一致がなく、0に達した場合。
-		if i == 0 {
-			return as4, false
-		}
-		i--
+	db.cc.l.RLock()
+	defer db.cc.l.RUnlock()
+	if _, ok := db.cc.data[sr.Code]; ok {
+		sr.Name = db.cc.data[sr.Code].Name
+		sr.AltName = db.cc.data[sr.Code].AltName
 	}
+
+	return sr
 }
 
-// IPアドレスの最後の8ビットについて
-// データベースに一致する値があるか判定
-func (db *DB) checkLast8Bit(as4 [4]byte) ([4]byte, bool) {
-	// 一致があればそのまま引数を返す。
-	if _, ok := db.ib.data[as4[0]][as4[1]][as4[2]][as4[3]]; ok {
-		return as4, true
+// 渡された AS 番号からカントリーコードの情報を返す。
+func (db *DB) SearchASN(asn uint32) ASNResult {
+	db.ib.l.RLock()
+	defer db.ib.l.RUnlock()
+
+	// 開始番号が asn 以下で最大のものを検索する。
+	i := searchRange(db.ib.asnRanges, asn)
+	if i < 0 || db.ib.asnRanges[i].end < asn {
+		return ASNResult{Status: StatusNotFound, Message: StatusNotFound.String()}
 	}
-	// 最後の8ビットが0のみの場合は0
-	if _, ok := db.ib.data[as4[0]][as4[1]][as4[2]][0]; ok && len(db.ib.data[as4[0]][as4[1]][as4[2]]) == 1 {
-		as4[3] = 0
-		return as4, true
+
+	r := db.ib.asnRanges[i]
+	b := db.ib.attrs[r.attrs]
+	ar := ASNResult{
+		IsFound:          true,
+		Status:           StatusFound,
+		Message:          StatusFound.String(),
+		ASNStart:         r.start,
+		ASNEnd:           r.end,
+		Code:             db.ib.dicCCIntToStr[b.country],
+		Registry:         b.registry,
+		AllocationStatus: b.status,
+		AllocatedOn:      dateToTime(b.date),
+		OpaqueID:         b.opaqueID,
 	}
-	// それ以外は元の値から1づつ減らして一致する値を検索
-	i := as4[3]
-	for {
-		// 一致した場合
-		if _, ok := db.ib.data[as4[0]][as4[1]][as4[2]][i]; ok {
-			as4[3] = i
-			return as4, true
-		}
-		// 一致がなく、0に達した場合。
-		if i == 0 {
-			return as4, false
-		}
-		i--
+	db.cc.l.RLock()
+	defer db.cc.l.RUnlock()
+	if _, ok := db.cc.data[ar.Code]; ok {
+		ar.Name = db.cc.data[ar.Code].Name
+		ar.AltName = db.cc.data[ar.Code].AltName
 	}
-}
 
-func (db *DB) searchBlockStart(addr netip.Addr) [4]byte {
-	var (
-		// IPv4アドレスを8ビット単位で分割し、
-		// データベースから所属ブロック候補を検索
-		as4 [4]byte = addr.As4()
-		// 検索する8ビット単位の範囲を指定
-		checkLevel int = 2
-		// 検索結果有無
-		found bool = true
-	)
+	return ar
+}
 
+// 渡された IPv6 アドレスからカントリーコードの情報を返す。
+func (db *DB) searchInfo6(target netip.Addr) SearchResult {
 	db.ib.l.RLock()
 	defer db.ib.l.RUnlock()
 
-	for {
-		if checkLevel == 2 {
-			as4, found = db.checkFirst8Bit(as4)
-			if !found {
-				return [4]byte{}
-			}
-		}
-
-		if checkLevel >= 1 {
-			as4, found = db.checkSecond8Bit(as4)
-			if !found {
-				if as4[0] == 0 {
-					return [4]byte{}
-				}
-				as4[0]--
-				as4[1] = 255
-				as4[2] = 255
-				as4[3] = 255
-				checkLevel = 2
-				continue
-			}
+	if p, b, ok := db.ib.view().lookup6(target); ok {
+		sr := SearchResult{
+			IsFound:          true,
+			Status:           StatusFound,
+			Message:          StatusFound.String(),
+			BlockStart:       p.Addr().String(),
+			BlockEnd:         getLastAddrOfPrefix(p).String(),
+			Prefixes:         []netip.Prefix{p},
+			Prefix:           p,
+			Code:             db.ib.dicCCIntToStr[b.country],
+			Registry:         b.registry,
+			AllocationStatus: b.status,
+			AllocatedOn:      dateToTime(b.date),
+			OpaqueID:         b.opaqueID,
 		}
-
-		as4, found = db.checkThird8Bit(as4)
-		if !found {
-			if as4[1] == 0 {
-				if as4[0] == 0 {
-					return [4]byte{}
-				}
-				as4[0]--
-				as4[1] = 255
-				as4[2] = 255
-				as4[3] = 255
-				checkLevel = 2
-				continue
-			}
-			as4[1]--
-			as4[2] = 255
-			as4[3] = 255
-			checkLevel = 1
-			continue
+		db.cc.l.RLock()
+		defer db.cc.l.RUnlock()
+		if _, ok := db.cc.data[sr.Code]; ok {
+			sr.Name = db.cc.data[sr.Code].Name
+			sr.AltName = db.cc.data[sr.Code].AltName
 		}
 
-		as4, found = db.checkLast8Bit(as4)
-		if found {
-			return as4
-		}
-		if as4[2] == 0 {
-			if as4[1] == 0 {
-				if as4[0] == 0 {
-					return [4]byte{}
-				}
-				as4[0]--
-				as4[1] = 255
-				as4[2] = 255
-				as4[3] = 255
-				checkLevel = 2
-				continue
-			}
-			as4[1]--
-			as4[2] = 255
-			as4[3] = 255
-			checkLevel = 1
-			continue
-		}
-		as4[2]--
-		as4[3] = 255
-		checkLevel = 0
+		return sr
 	}
-}
 
-// 渡された文字列のIPv4アドレスからカントリーコードの情報を返す。
-func (db *DB) SearchInfo(adrs string) SearchResult {
-	var (
-		as4 [4]byte
-		oO  netip.Addr
-	)
+	return SearchResult{Status: StatusNotFound, Message: StatusNotFound.String()}
+}
 
-	target, err := netip.ParseAddr(adrs)
-	// 渡された文字列をパースしてエラー
-	if err != nil {
-		return SearchResult{Message: "Invalid IP Address"}
-	}
-	// IPv4アドレスでない
-	if !target.Is4() {
-		return SearchResult{Message: "Not IPv4 Address"}
-	}
+// IP アドレスの種類から、検索せずに決まる結果の状態と、
+// IANA IPv4 Special-Purpose Address Registry の一覧の添字（登録されていない場合は -1 ）を返す。
+// 検索が必要な場合は StatusNotFound を返す。
+// target は IPv4 射影アドレスでなく、ゾーンのないものであること。
+func classifyAddr(target netip.Addr) (Status, int) {
+	i := specialPurposeIndex(target)
 	// ループバックアドレス
 	if target.IsLoopback() {
-		return SearchResult{Message: "Loopback Address"}
+		return StatusLoopback, i
 	}
 	// マルチキャストアドレス
 	if target.IsMulticast() {
-		return SearchResult{Message: "Multicast Address"}
+		return StatusMulticast, i
 	}
 	// プライベートアドレス
 	if target.IsPrivate() {
-		return SearchResult{Message: "Private Address"}
+		return StatusPrivate, i
 	}
-
-	// IPv4アドレスを8ビット単位で分割し、データベースから所属ブロック候補を検索
-	as4 = db.searchBlockStart(target)
-	if as4 == [4]byte{} {
-		return SearchResult{Message: "Not Found"}
+	// グローバルに到達可能でない特別な用途のアドレス
+	if i >= 0 && !specialPurposes[i].GloballyReachable {
+		return StatusReserved, i
 	}
 
-	// 所属ブロック候補の最後のアドレスを計算
-	db.ib.l.RLock()
-	defer db.ib.l.RUnlock()
-	oO = getOneOutside(as4, db.ib.data[as4[0]][as4[1]][as4[2]][as4[3]].value)
-
-	// 渡されたIPv4アドレスが所属ブロック候補の範囲に含まれる場合は、
-	// カントリーコード他該当情報を返す。
-	if target.Less(oO) {
-		sr := SearchResult{
-			IsFound:    true,
-			Message:    "Found",
-			BlockStart: netip.AddrFrom4(as4).String(),
-			BlockEnd:   oO.Prev().String(),
-			Code:       db.ib.dicCCIntToStr[db.ib.data[as4[0]][as4[1]][as4[2]][as4[3]].country],
-		}
-		db.cc.l.RLock()
-		defer db.cc.l.RUnlock()
-		if _, ok := db.cc.data[sr.Code]; ok {
-			sr.Name = db.cc.data[sr.Code].Name
-			sr.AltName = db.cc.data[sr.Code].AltName
-		}
+	return StatusNotFound, i
+}
 
-		return sr
+// プレフィックスに含まれる最後の IP アドレスを計算して返す。
+func getLastAddrOfPrefix(p netip.Prefix) netip.Addr {
+	a := p.Masked().Addr()
+	if a.Is4() {
+		a4 := a.As4()
+		binary.BigEndian.PutUint32(a4[:], binary.BigEndian.Uint32(a4[:])|(1<<(32-p.Bits())-1))
+		return netip.AddrFrom4(a4)
 	}
+	a16 := a.As16()
+	for i := p.Bits(); i < 128; i++ {
+		a16[i/8] |= 1 << (7 - i%8)
+	}
+	return netip.AddrFrom16(a16)
+}
 
-	// 渡されたIPv4アドレスが所属ブロック候補の範囲に含まれない場合は、
-	// 情報なしとして返す。
-	return SearchResult{Message: "Not Found"}
+// IPv4 アドレスを uint32 に変換して返す。
+func addrToUint32(a netip.Addr) uint32 {
+	a4 := a.As4()
+	return binary.BigEndian.Uint32(a4[:])
+}
 
+// uint32 を IPv4 アドレスに変換して返す。
+func uint32ToAddr(x uint32) netip.Addr {
+	var a4 [4]byte
+	binary.BigEndian.PutUint32(a4[:], x)
+	return netip.AddrFrom4(a4)
 }
 
 // 渡された IP アドレスに対応する 4 バイトの配列とUint32 に
@@ -694,11 +1130,11 @@ func GetLastAddr(adrs string, value int) (netip.Addr, error) {
 	}
 	// IPv4アドレスでない
 	if !target.Is4() {
-		return netip.Addr{}, errors.New(ErrorMessageFirstArgumentOutOfRange)
+		return netip.Addr{}, ErrFirstArgumentOutOfRange
 	}
 
 	if value < 1 || value > 4294967295 {
-		return netip.Addr{}, errors.New(ErrorMessageSecondArgumentOutOfRange)
+		return netip.Addr{}, ErrSecondArgumentOutOfRange
 	}
 
 	return getOneOutside(target.As4(), uint32(value)).Prev(), nil
@@ -714,7 +1150,7 @@ func GetValue(a string, b string) (int, error) {
 	}
 	// IPv4アドレスでない
 	if !x.Is4() {
-		return 0, errors.New(ErrorMessageFirstArgumentOutOfRange)
+		return 0, ErrFirstArgumentOutOfRange
 	}
 
 	y, err := netip.ParseAddr(b)
@@ -724,7 +1160,7 @@ func GetValue(a string, b string) (int, error) {
 	}
 	// IPv4アドレスでない
 	if !y.Is4() {
-		return 0, errors.New(ErrorMessageSecondArgumentOutOfRange)
+		return 0, ErrSecondArgumentOutOfRange
 	}
 
 	i := x.Compare(y)
@@ -754,5 +1190,5 @@ func (db *DB) GetCountryCodeData() map[string]CountryCodeInfo {
 
 // 検索用データベースが空ならば true を返す。
 func (db *DB) IsDBEmpty() bool {
-	return len(db.ib.data) == 0
+	return len(db.ib.ranges) == 0 && len(db.ib.data6) == 0 && len(db.ib.asnRanges) == 0
 }
@@ -1,16 +1,29 @@
 package ccipv4
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
 	"os"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unsafe"
 )
 
+// テスト用に IPv4 アドレスの文字列を uint32 に変換する。
+func u32(s string) uint32 {
+	return addrToUint32(netip.MustParseAddr(s))
+}
+
 // テスト用の RIR のダミーデータを取得する。
 func getDummyRIR() ([]string, *http.ServeMux) {
 	urlRIR := []string{
@@ -62,6 +75,9 @@ func TestGetDB(t *testing.T) {
 	if db.ib.data != nil {
 		t.Errorf("GetDB: ib.data is invalid: %v", db.ib.data)
 	}
+	if db.ib.data6 != nil {
+		t.Errorf("GetDB: ib.data6 is invalid: %v", db.ib.data6)
+	}
 	if db.ib.dicCCStrToInt != nil {
 		t.Errorf("GetDB: ib.dicCCStrToInt is invalid: %v", db.ib.dicCCStrToInt)
 	}
@@ -80,6 +96,21 @@ func TestGetDB(t *testing.T) {
 	if db.tmpIB.data == nil || len(db.tmpIB.data) != 0 {
 		t.Errorf("GetDB: tmpIB.data is invalid: %v", db.tmpIB.data)
 	}
+	if db.tmpIB.data6 == nil || len(db.tmpIB.data6) != 0 {
+		t.Errorf("GetDB: tmpIB.data6 is invalid: %v", db.tmpIB.data6)
+	}
+	if db.tmpIB.asn == nil || len(db.tmpIB.asn) != 0 {
+		t.Errorf("GetDB: tmpIB.asn is invalid: %v", db.tmpIB.asn)
+	}
+	if db.ib.asnRanges != nil {
+		t.Errorf("GetDB: ib.asnRanges is invalid: %v", db.ib.asnRanges)
+	}
+	if db.ib.ranges != nil {
+		t.Errorf("GetDB: ib.ranges is invalid: %v", db.ib.ranges)
+	}
+	if db.ib.attrs != nil {
+		t.Errorf("GetDB: ib.attrs is invalid: %v", db.ib.attrs)
+	}
 	if db.tmpIB.dicCCStrToInt == nil || len(db.tmpIB.dicCCStrToInt) != 0 {
 		t.Errorf("GetDB: tmpIB.dicCCStrToInt is invalid: %v", db.tmpIB.dicCCStrToInt)
 	}
@@ -116,6 +147,113 @@ func TestGetDB(t *testing.T) {
 
 }
 
+// テスト用に、送信したリクエストの数を数える http.RoundTripper 。
+type countingTransport struct {
+	n int32
+}
+
+func (ct *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&ct.n, 1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestNewDB(t *testing.T) {
+	// 既定の設定
+	db := NewDB()
+	if db.client == nil || db.client.Timeout != 60*time.Second {
+		t.Errorf("NewDB: client is invalid: %v", db.client)
+	}
+	if db.concurrency != 3 {
+		t.Errorf("NewDB: concurrency want 3, but %d", db.concurrency)
+	}
+	if db.userAgent != "" {
+		t.Errorf("NewDB: userAgent want empty, but %s", db.userAgent)
+	}
+	if len(db.urlRIR) != 5 {
+		t.Errorf("NewDB: urlRIR length want 5, but %d: %v", len(db.urlRIR), db.urlRIR)
+	}
+	if db.tmpIB.data == nil || db.tmpCC.data == nil || db.reg == nil {
+		t.Error("NewDB: db is not initialized")
+	}
+
+	var (
+		mu       sync.Mutex
+		inFlight int
+		maxIn    int
+		ua       []string
+	)
+	urlRIR, mux := getDummyRIR()
+	ts := httptest.NewServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxIn {
+				maxIn = inFlight
+			}
+			ua = append(ua, r.UserAgent())
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mux.ServeHTTP(w, r)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}),
+	)
+	defer ts.Close()
+
+	urls := make([]string, len(urlRIR))
+	for i := range urlRIR {
+		urls[i] = ts.URL + urlRIR[i]
+	}
+	ct := &countingTransport{}
+	db = NewDB(
+		WithHTTPClient(&http.Client{Transport: ct}),
+		WithSourceURLs(urls...),
+		WithConcurrency(1),
+		WithUserAgent("ccipv4-test/1.0"),
+	)
+	// 設定後に元のスライスを変更しても影響しない。
+	urls[0] = ""
+
+	if err := db.SetIPBData(); err != nil {
+		t.Fatalf("NewDB: SetIPBData, but error: %v", err)
+	}
+	if len(db.ib.ranges) != 5 {
+		t.Errorf("NewDB: ib.ranges length want 5, but %d: %v", len(db.ib.ranges), db.ib.ranges)
+	}
+	if atomic.LoadInt32(&ct.n) != 5 {
+		t.Errorf("NewDB: requests via client want 5, but %d", atomic.LoadInt32(&ct.n))
+	}
+	if maxIn != 1 {
+		t.Errorf("NewDB: concurrent requests want 1, but %d", maxIn)
+	}
+	for _, v := range ua {
+		if v != "ccipv4-test/1.0" {
+			t.Errorf("NewDB: User-Agent want ccipv4-test/1.0, but %s", v)
+		}
+	}
+
+	// nil の http.Client と制限なしの同時取得数
+	ua = nil
+	maxIn = 0
+	db = NewDB(
+		WithHTTPClient(nil),
+		WithSourceURLs(ts.URL+urlRIR[0], ts.URL+urlRIR[1], ts.URL+urlRIR[2], ts.URL+urlRIR[3], ts.URL+urlRIR[4]),
+		WithConcurrency(0),
+	)
+	if err := db.SetIPBData(); err != nil {
+		t.Fatalf("NewDB: SetIPBData, but error: %v", err)
+	}
+	if len(db.ib.ranges) != 5 {
+		t.Errorf("NewDB: ib.ranges length want 5, but %d: %v", len(db.ib.ranges), db.ib.ranges)
+	}
+	for _, v := range ua {
+		if !strings.HasPrefix(v, "Go-http-client/") {
+			t.Errorf("NewDB: User-Agent want default, but %s", v)
+		}
+	}
+}
+
 func TestClearTmpIPBData(t *testing.T) {
 	// 初期状態からクリア
 	db := GetDB()
@@ -124,6 +262,9 @@ func TestClearTmpIPBData(t *testing.T) {
 	if db.tmpIB.data == nil || len(db.tmpIB.data) != 0 {
 		t.Errorf("ClearTmpIPBData: tmpIB.data is invalid: %v", db.tmpIB.data)
 	}
+	if db.tmpIB.data6 == nil || len(db.tmpIB.data6) != 0 {
+		t.Errorf("ClearTmpIPBData: tmpIB.data6 is invalid: %v", db.tmpIB.data6)
+	}
 	if db.tmpIB.dicCCStrToInt == nil || len(db.tmpIB.dicCCStrToInt) != 0 {
 		t.Errorf("ClearTmpIPBData: tmpIB.dicCCStrToInt is invalid: %v", db.tmpIB.dicCCStrToInt)
 	}
@@ -138,10 +279,8 @@ func TestClearTmpIPBData(t *testing.T) {
 	}
 
 	// データがある状態からクリア
-	db.tmpIB.data[0] = map[uint8]map[uint8]map[uint8]block{}
-	db.tmpIB.data[0][0] = map[uint8]map[uint8]block{}
-	db.tmpIB.data[0][0][0] = map[uint8]block{}
-	db.tmpIB.data[0][0][0][0] = block{value: 16, country: 0}
+	db.tmpIB.data[u32("0.0.0.0")] = block{value: 16}
+	db.tmpIB.data6[netip.MustParsePrefix("2001:db8::/32")] = block{value: 32}
 	db.tmpIB.dicCCIntToStr[0] = "JP"
 	db.tmpIB.dicCCStrToInt["JP"] = 0
 
@@ -149,6 +288,9 @@ func TestClearTmpIPBData(t *testing.T) {
 	if db.tmpIB.data == nil || len(db.tmpIB.data) != 0 {
 		t.Errorf("ClearTmpIPBData: tmpIB.data is invalid: %v", db.tmpIB.data)
 	}
+	if db.tmpIB.data6 == nil || len(db.tmpIB.data6) != 0 {
+		t.Errorf("ClearTmpIPBData: tmpIB.data6 is invalid: %v", db.tmpIB.data6)
+	}
 	if db.tmpIB.dicCCStrToInt == nil || len(db.tmpIB.dicCCStrToInt) != 0 {
 		t.Errorf("ClearTmpIPBData: tmpIB.dicCCStrToInt is invalid: %v", db.tmpIB.dicCCStrToInt)
 	}
@@ -172,7 +314,7 @@ func TestSetTmpIPBlocks(t *testing.T) {
 	if err != nil {
 		t.Fatalf("setTmpIPBlocks: can't read testdata/invalidIPBlockFile-1: %v", err)
 	}
-	err = db.setTmpIPBlocks(fp)
+	err = db.setTmpIPBlocks(fp, fp.Name())
 	if err == nil {
 		t.Error("setTmpIPBlocks: read invalidIPBlockFile-1, but no error")
 	}
@@ -199,7 +341,7 @@ func TestSetTmpIPBlocks(t *testing.T) {
 	if err != nil {
 		t.Fatalf("setTmpIPBlocks: can't read testdata/invalidIPBlockFile-2: %v", err)
 	}
-	err = db.setTmpIPBlocks(fp)
+	err = db.setTmpIPBlocks(fp, fp.Name())
 	if err == nil {
 		t.Error("setTmpIPBlocks: read invalidIPBlockFile-2, but no error")
 	} else if !strings.Contains(err.Error(), "of the line's fields is invalid:") {
@@ -228,7 +370,7 @@ func TestSetTmpIPBlocks(t *testing.T) {
 	if err != nil {
 		t.Fatalf("setTmpIPBlocks: can't read testdata/invalidIPBlockFile-3: %v", err)
 	}
-	err = db.setTmpIPBlocks(fp)
+	err = db.setTmpIPBlocks(fp, fp.Name())
 	if err == nil {
 		t.Error("setTmpIPBlocks: read invalidIPBlockFile-3, but no error")
 	}
@@ -249,13 +391,52 @@ func TestSetTmpIPBlocks(t *testing.T) {
 		t.Errorf("setTmpIPBlocks: tmpIB.totalValue is invalid: %v", db.tmpIB.totalValue)
 	}
 
+	// ipv6 のプレフィックスが不正
+	for _, f := range []string{"testdata/invalidIPBlockFile-4", "testdata/invalidIPBlockFile-5"} {
+		db = GetDB()
+		fp, err = os.Open(f)
+		if err != nil {
+			t.Fatalf("setTmpIPBlocks: can't read %s: %v", f, err)
+		}
+		err = db.setTmpIPBlocks(fp, fp.Name())
+		if err == nil {
+			t.Errorf("setTmpIPBlocks: read %s, but no error", f)
+		} else if !strings.Contains(err.Error(), "invalid ipv6 prefix:") {
+			t.Errorf("setTmpIPBlocks: unexpected error: %v", err)
+		}
+		fp.Close()
+		if db.tmpIB.data6 == nil || len(db.tmpIB.data6) != 0 {
+			t.Errorf("setTmpIPBlocks: tmpIB.data6 is invalid: %v", db.tmpIB.data6)
+		}
+		if db.tmpIB.dicCCStrToInt == nil || len(db.tmpIB.dicCCStrToInt) != 0 {
+			t.Errorf("setTmpIPBlocks: tmpIB.dicCCStrToInt is invalid: %v", db.tmpIB.dicCCStrToInt)
+		}
+	}
+
+	// asn の start・value が不正
+	for _, f := range []string{"testdata/invalidIPBlockFile-6", "testdata/invalidIPBlockFile-7"} {
+		db = GetDB()
+		fp, err = os.Open(f)
+		if err != nil {
+			t.Fatalf("setTmpIPBlocks: can't read %s: %v", f, err)
+		}
+		err = db.setTmpIPBlocks(fp, fp.Name())
+		if err == nil {
+			t.Errorf("setTmpIPBlocks: read %s, but no error", f)
+		}
+		fp.Close()
+		if db.tmpIB.asn == nil || len(db.tmpIB.asn) != 0 {
+			t.Errorf("setTmpIPBlocks: tmpIB.asn is invalid: %v", db.tmpIB.asn)
+		}
+	}
+
 	// 正常データ１件
 	db = GetDB()
 	fp, err = os.Open("testdata/validIPBlockFile-1")
 	if err != nil {
 		t.Fatalf("setTmpIPBlocks: can't read testdata/validIPBlockFile-1: %v", err)
 	}
-	err = db.setTmpIPBlocks(fp)
+	err = db.setTmpIPBlocks(fp, fp.Name())
 	if err != nil {
 		t.Errorf("setTmpIPBlocks: read validIPBlockFile-1: %v", err)
 	}
@@ -263,18 +444,39 @@ func TestSetTmpIPBlocks(t *testing.T) {
 	if len(db.tmpIB.data) != 1 {
 		t.Errorf("setTmpIPBlocks: tmpIB.data length want 1, but %d: %v", len(db.tmpIB.data), db.tmpIB.data)
 	}
-	if _, ok := db.tmpIB.data[114][48][0][0]; !ok {
-		t.Error("setTmpIPBlocks: tmpIB.data[114][48][0][0] doesn't exist")
+	if _, ok := db.tmpIB.data[u32("114.48.0.0")]; !ok {
+		t.Error("setTmpIPBlocks: tmpIB.data[114.48.0.0] doesn't exist")
+	} else {
+		if db.tmpIB.data[u32("114.48.0.0")].country != 0 {
+			t.Errorf("setTmpIPBlocks: tmpIB.data[114.48.0.0].country want 0, but got %d", db.tmpIB.data[u32("114.48.0.0")].country)
+		}
+		if db.tmpIB.data[u32("114.48.0.0")].value != 262144 {
+			t.Errorf("setTmpIPBlocks: tmpIB.data[114.48.0.0].ipCidr want 262144, but got %d", db.tmpIB.data[u32("114.48.0.0")].value)
+		}
+	}
+	if len(db.tmpIB.data6) != 5 {
+		t.Errorf("setTmpIPBlocks: tmpIB.data6 length want 5, but %d: %v", len(db.tmpIB.data6), db.tmpIB.data6)
+	}
+	if len(db.tmpIB.asn) != 3 {
+		t.Errorf("setTmpIPBlocks: tmpIB.asn length want 3, but %d: %v", len(db.tmpIB.asn), db.tmpIB.asn)
+	}
+	if ab, ok := db.tmpIB.asn[681]; !ok {
+		t.Error("setTmpIPBlocks: tmpIB.asn[681] doesn't exist")
+	} else if ab != (block{value: 1, blockAttrs: blockAttrs{country: 1, registry: "apnic", status: "allocated", date: 20020801}}) {
+		t.Errorf("setTmpIPBlocks: tmpIB.asn[681] is invalid: %v", ab)
+	}
+	if b, ok := db.tmpIB.data6[netip.MustParsePrefix("2001:df2:6180::/48")]; !ok {
+		t.Error("setTmpIPBlocks: tmpIB.data6[2001:df2:6180::/48] doesn't exist")
 	} else {
-		if db.tmpIB.data[114][48][0][0].country != 0 {
-			t.Errorf("setTmpIPBlocks: tmpIB.data[114][48][0][0].country want 0, but got %d", db.tmpIB.data[114][48][0][0].country)
+		if b.country != 3 {
+			t.Errorf("setTmpIPBlocks: tmpIB.data6[2001:df2:6180::/48].country want 3, but got %d", b.country)
 		}
-		if db.tmpIB.data[114][48][0][0].value != 262144 {
-			t.Errorf("setTmpIPBlocks: tmpIB.data[114][48][0][0].ipCidr want 262144, but got %d", db.tmpIB.data[114][48][0][0].value)
+		if b.value != 48 {
+			t.Errorf("setTmpIPBlocks: tmpIB.data6[2001:df2:6180::/48].value want 48, but got %d", b.value)
 		}
 	}
-	if len(db.tmpIB.dicCCStrToInt) != 1 {
-		t.Errorf("setTmpIPBlocks: tmpIB.dicCCStrToInt length want 1, but %d: %v", len(db.tmpIB.dicCCStrToInt), db.tmpIB.dicCCStrToInt)
+	if len(db.tmpIB.dicCCStrToInt) != 7 {
+		t.Errorf("setTmpIPBlocks: tmpIB.dicCCStrToInt length want 7, but %d: %v", len(db.tmpIB.dicCCStrToInt), db.tmpIB.dicCCStrToInt)
 	} else {
 		if _, ok := db.tmpIB.dicCCStrToInt["JP"]; !ok {
 			t.Error("setTmpIPBlocks: tmpIB.dicCCStrToInt[JP] doesn't exist")
@@ -282,8 +484,8 @@ func TestSetTmpIPBlocks(t *testing.T) {
 			t.Errorf("setTmpIPBlocks: tmpIB.dicCCStrToInt[JP] want 0, but %d", db.tmpIB.dicCCStrToInt["JP"])
 		}
 	}
-	if len(db.tmpIB.dicCCIntToStr) != 1 {
-		t.Errorf("setTmpIPBlocks: tmpIB.dicCCIntToStr length want 1, but %d: %v", len(db.tmpIB.dicCCIntToStr), db.tmpIB.dicCCIntToStr)
+	if len(db.tmpIB.dicCCIntToStr) != 7 {
+		t.Errorf("setTmpIPBlocks: tmpIB.dicCCIntToStr length want 7, but %d: %v", len(db.tmpIB.dicCCIntToStr), db.tmpIB.dicCCIntToStr)
 	} else {
 		if _, ok := db.tmpIB.dicCCIntToStr[0]; !ok {
 			t.Error("setTmpIPBlocks: tmpIB.dicCCIntToStr[0] doesn't exist")
@@ -326,7 +528,7 @@ func TestSetTmpIPBlocks(t *testing.T) {
 	if err != nil {
 		t.Fatalf("setTmpIPBlocks: can't read testdata/variousDataIPBlockFile: %v", err)
 	}
-	err = db.setTmpIPBlocks(fp)
+	err = db.setTmpIPBlocks(fp, fp.Name())
 	if err == nil {
 		t.Error("setTmpIPBlocks: read variousDataIPBlockFile, but no error")
 	}
@@ -353,7 +555,7 @@ func TestSetTmpIPBlocks(t *testing.T) {
 	if err != nil {
 		t.Fatalf("setTmpIPBlocks: can't read testdata/validIPBlockFile-1: %v", err)
 	}
-	err = db.setTmpIPBlocks(fp)
+	err = db.setTmpIPBlocks(fp, fp.Name())
 	if err != nil {
 		t.Fatalf("setTmpIPBlocks: read testdata/validIPBlockFile-1, error: %v", err)
 	}
@@ -362,43 +564,43 @@ func TestSetTmpIPBlocks(t *testing.T) {
 	if err != nil {
 		t.Fatalf("setTmpIPBlocks: can't read testdata/validIPBlockFile-2: %v", err)
 	}
-	err = db.setTmpIPBlocks(fp)
+	err = db.setTmpIPBlocks(fp, fp.Name())
 	if err != nil {
 		t.Errorf("setTmpIPBlocks: read validIPBlockFile-2: %v", err)
 	}
 	fp.Close()
-	if _, ok := db.tmpIB.data[114][48][0][0]; !ok {
-		t.Error("setTmpIPBlocks: tmpIB.data[114][48][0][0] doesn't exist")
+	if _, ok := db.tmpIB.data[u32("114.48.0.0")]; !ok {
+		t.Error("setTmpIPBlocks: tmpIB.data[114.48.0.0] doesn't exist")
 	} else {
-		if db.tmpIB.data[114][48][0][0].country != 0 {
-			t.Errorf("setTmpIPBlocks: tmpIB.data[114][48][0][0].country want 0, but got %d", db.tmpIB.data[114][48][0][0].country)
+		if db.tmpIB.data[u32("114.48.0.0")].country != 0 {
+			t.Errorf("setTmpIPBlocks: tmpIB.data[114.48.0.0].country want 0, but got %d", db.tmpIB.data[u32("114.48.0.0")].country)
 		}
-		if db.tmpIB.data[114][48][0][0].value != 131072 {
-			t.Errorf("setTmpIPBlocks: tmpIB.data[114][48][0][0].value want 131072, but got %d", db.tmpIB.data[114][48][0][0].value)
+		if db.tmpIB.data[u32("114.48.0.0")].value != 131072 {
+			t.Errorf("setTmpIPBlocks: tmpIB.data[114.48.0.0].value want 131072, but got %d", db.tmpIB.data[u32("114.48.0.0")].value)
 		}
 	}
-	if _, ok := db.tmpIB.data[114][31][248][128]; !ok {
-		t.Error("setTmpIPBlocks: tmpIB.data[114][31][248][0] doesn't exist")
+	if _, ok := db.tmpIB.data[u32("114.31.248.128")]; !ok {
+		t.Error("setTmpIPBlocks: tmpIB.data[114.31.248.0] doesn't exist")
 	} else {
-		if db.tmpIB.data[114][31][248][128].country != 1 {
-			t.Errorf("setTmpIPBlocks: tmpIB.data[114][31][248][128].country want 0, but got %d", db.tmpIB.data[114][31][248][128].country)
+		if db.tmpIB.data[u32("114.31.248.128")].country != 5 {
+			t.Errorf("setTmpIPBlocks: tmpIB.data[114.31.248.128].country want 5, but got %d", db.tmpIB.data[u32("114.31.248.128")].country)
 		}
-		if db.tmpIB.data[114][31][248][128].value != 2048 {
-			t.Errorf("setTmpIPBlocks: tmpIB.data[114][31][248][128].value want 2048, but got %d", db.tmpIB.data[114][31][248][128].value)
+		if db.tmpIB.data[u32("114.31.248.128")].value != 2048 {
+			t.Errorf("setTmpIPBlocks: tmpIB.data[114.31.248.128].value want 2048, but got %d", db.tmpIB.data[u32("114.31.248.128")].value)
 		}
 	}
-	if _, ok := db.tmpIB.data[124][147][128][0]; !ok {
-		t.Error("setTmpIPBlocks: tmpIB.data[124][147][128][0] doesn't exist")
+	if _, ok := db.tmpIB.data[u32("124.147.128.0")]; !ok {
+		t.Error("setTmpIPBlocks: tmpIB.data[124.147.128.0] doesn't exist")
 	} else {
-		if db.tmpIB.data[124][147][128][0].country != 2 {
-			t.Errorf("setTmpIPBlocks: tmpIB.data[124][147][128][0].country want 2, but got %d", db.tmpIB.data[124][147][128][0].country)
+		if db.tmpIB.data[u32("124.147.128.0")].country != 7 {
+			t.Errorf("setTmpIPBlocks: tmpIB.data[124.147.128.0].country want 7, but got %d", db.tmpIB.data[u32("124.147.128.0")].country)
 		}
-		if db.tmpIB.data[124][147][128][0].value != 32768 {
-			t.Errorf("setTmpIPBlocks: tmpIB.data[124][147][128][0].value want 32768, but got %d", db.tmpIB.data[124][147][128][0].value)
+		if db.tmpIB.data[u32("124.147.128.0")].value != 32768 {
+			t.Errorf("setTmpIPBlocks: tmpIB.data[124.147.128.0].value want 32768, but got %d", db.tmpIB.data[u32("124.147.128.0")].value)
 		}
 	}
-	if len(db.tmpIB.dicCCStrToInt) != 3 {
-		t.Errorf("setTmpIPBlocks: tmpIB.dicCCStrToInt length want 3, but %d: %v", len(db.tmpIB.dicCCStrToInt), db.tmpIB.dicCCStrToInt)
+	if len(db.tmpIB.dicCCStrToInt) != 8 {
+		t.Errorf("setTmpIPBlocks: tmpIB.dicCCStrToInt length want 8, but %d: %v", len(db.tmpIB.dicCCStrToInt), db.tmpIB.dicCCStrToInt)
 	} else {
 		if _, ok := db.tmpIB.dicCCStrToInt["JP"]; !ok {
 			t.Error("setTmpIPBlocks: tmpIB.dicCCStrToInt[JP] doesn't exist")
@@ -407,32 +609,32 @@ func TestSetTmpIPBlocks(t *testing.T) {
 		}
 		if _, ok := db.tmpIB.dicCCStrToInt["IN"]; !ok {
 			t.Error("setTmpIPBlocks: tmpIB.dicCCStrToInt[IN] doesn't exist")
-		} else if db.tmpIB.dicCCStrToInt["IN"] != 1 {
-			t.Errorf("setTmpIPBlocks: tmpIB.dicCCStrToInt[IN] want 1, but %d", db.tmpIB.dicCCStrToInt["IN"])
+		} else if db.tmpIB.dicCCStrToInt["IN"] != 5 {
+			t.Errorf("setTmpIPBlocks: tmpIB.dicCCStrToInt[IN] want 5, but %d", db.tmpIB.dicCCStrToInt["IN"])
 		}
 		if _, ok := db.tmpIB.dicCCStrToInt["CN"]; !ok {
 			t.Error("setTmpIPBlocks: tmpIB.dicCCStrToInt[CN] doesn't exist")
-		} else if db.tmpIB.dicCCStrToInt["CN"] != 2 {
-			t.Errorf("setTmpIPBlocks: tmpIB.dicCCStrToInt[CN] want 2, but %d", db.tmpIB.dicCCStrToInt["CN"])
+		} else if db.tmpIB.dicCCStrToInt["CN"] != 7 {
+			t.Errorf("setTmpIPBlocks: tmpIB.dicCCStrToInt[CN] want 7, but %d", db.tmpIB.dicCCStrToInt["CN"])
 		}
 	}
-	if len(db.tmpIB.dicCCIntToStr) != 3 {
-		t.Errorf("setTmpIPBlocks: tmpIB.dicCCIntToStr length want 5, but %d: %v", len(db.tmpIB.dicCCIntToStr), db.tmpIB.dicCCIntToStr)
+	if len(db.tmpIB.dicCCIntToStr) != 8 {
+		t.Errorf("setTmpIPBlocks: tmpIB.dicCCIntToStr length want 8, but %d: %v", len(db.tmpIB.dicCCIntToStr), db.tmpIB.dicCCIntToStr)
 	} else {
 		if _, ok := db.tmpIB.dicCCIntToStr[0]; !ok {
 			t.Error("setTmpIPBlocks: tmpIB.dicCCIntToStr[0] doesn't exist")
 		} else if db.tmpIB.dicCCIntToStr[0] != "JP" {
 			t.Errorf("setTmpIPBlocks: tmpIB.dicCCIntToStr[0] want JP, but %s", db.tmpIB.dicCCIntToStr[0])
 		}
-		if _, ok := db.tmpIB.dicCCIntToStr[1]; !ok {
-			t.Error("setTmpIPBlocks: tmpIB.dicCCIntToStr[1] doesn't exist")
-		} else if db.tmpIB.dicCCIntToStr[1] != "IN" {
-			t.Errorf("setTmpIPBlocks: tmpIB.dicCCIntToStr[1] want IN, but %s", db.tmpIB.dicCCIntToStr[1])
+		if _, ok := db.tmpIB.dicCCIntToStr[5]; !ok {
+			t.Error("setTmpIPBlocks: tmpIB.dicCCIntToStr[5] doesn't exist")
+		} else if db.tmpIB.dicCCIntToStr[5] != "IN" {
+			t.Errorf("setTmpIPBlocks: tmpIB.dicCCIntToStr[5] want IN, but %s", db.tmpIB.dicCCIntToStr[5])
 		}
-		if _, ok := db.tmpIB.dicCCIntToStr[2]; !ok {
-			t.Error("setTmpIPBlocks: tmpIB.dicCCIntToStr[2] doesn't exist")
-		} else if db.tmpIB.dicCCIntToStr[2] != "CN" {
-			t.Errorf("setTmpIPBlocks: tmpIB.dicCCIntToStr[2] want CN, but %s", db.tmpIB.dicCCIntToStr[2])
+		if _, ok := db.tmpIB.dicCCIntToStr[7]; !ok {
+			t.Error("setTmpIPBlocks: tmpIB.dicCCIntToStr[7] doesn't exist")
+		} else if db.tmpIB.dicCCIntToStr[7] != "CN" {
+			t.Errorf("setTmpIPBlocks: tmpIB.dicCCIntToStr[7] want CN, but %s", db.tmpIB.dicCCIntToStr[7])
 		}
 	}
 	if db.tmpIB.totalBlocks == nil || len(db.tmpIB.totalBlocks) != 4 {
@@ -485,6 +687,86 @@ func TestSetTmpIPBlocks(t *testing.T) {
 	}
 }
 
+// テスト用に、 first 〜 last の先頭文字を持つ 2 文字のカントリーコードごとに
+// 256 アドレスのブロックを一つずつ持つ delegation file を作成する。
+func writeManyCountryCodesFile(t *testing.T, name string, first byte, last byte) string {
+	var sb strings.Builder
+
+	sb.WriteString("2|apnic|20240804|79519|19830613|20240802|+1000\n")
+	for c1 := first; c1 <= last; c1++ {
+		for c2 := byte('A'); c2 <= 'Z'; c2++ {
+			i := uint32(c1-'A')*26 + uint32(c2-'A')
+			fmt.Fprintf(&sb, "apnic|%c%c|ipv4|%s|256|20080422|allocated\n", c1, c2, uint32ToAddr(u32("1.0.0.0")+i*256))
+		}
+	}
+	f := t.TempDir() + "/" + name
+	if err := os.WriteFile(f, []byte(sb.String()), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", f, err)
+	}
+
+	return f
+}
+
+func TestSetTmpIPBlocksManyCountryCodes(t *testing.T) {
+	// 256 を超える種類のカントリーコードを 2 回に分けて読み込む。
+	db := GetDB()
+	if err := db.LoadIPBDataByFile(writeManyCountryCodesFile(t, "AA-MZ", 'A', 'M')); err != nil {
+		t.Fatalf("setTmpIPBlocks: load AA-MZ, but error: %v", err)
+	}
+	if len(db.tmpIB.dicCCStrToInt) != 338 {
+		t.Errorf("setTmpIPBlocks: tmpIB.dicCCStrToInt length want 338, but %d", len(db.tmpIB.dicCCStrToInt))
+	}
+	if err := db.LoadIPBDataByFile(writeManyCountryCodesFile(t, "NA-ZZ", 'N', 'Z')); err != nil {
+		t.Fatalf("setTmpIPBlocks: load NA-ZZ, but error: %v", err)
+	}
+	if len(db.tmpIB.dicCCStrToInt) != 676 {
+		t.Errorf("setTmpIPBlocks: tmpIB.dicCCStrToInt length want 676, but %d", len(db.tmpIB.dicCCStrToInt))
+	}
+	if len(db.tmpIB.dicCCIntToStr) != 676 {
+		t.Errorf("setTmpIPBlocks: tmpIB.dicCCIntToStr length want 676, but %d", len(db.tmpIB.dicCCIntToStr))
+	}
+	db.SwitchIPBData()
+
+	// 全てのカントリーコードが別々の値にひも付けされ、検索できる。
+	for c1 := byte('A'); c1 <= 'Z'; c1++ {
+		for c2 := byte('A'); c2 <= 'Z'; c2++ {
+			cc := string([]byte{c1, c2})
+			i := uint32(c1-'A')*26 + uint32(c2-'A')
+			adrs := uint32ToAddr(u32("1.0.0.128") + i*256).String()
+			if sr := db.SearchInfo(adrs); sr.Code != cc {
+				t.Errorf("setTmpIPBlocks: %s want %s, but %s: %v", adrs, cc, sr.Code, sr)
+			}
+		}
+	}
+
+	// 上限に達している場合は異常とする。
+	db = GetDB()
+	for i := 0; i < maxCountryCodes; i++ {
+		db.tmpIB.dicCCStrToInt[strconv.Itoa(i)] = uint16(i)
+	}
+	err := db.setTmpIPBlocks(strings.NewReader("apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated\n"), "")
+	if err == nil {
+		t.Error("setTmpIPBlocks: too many country codes, but no error")
+	} else if !strings.Contains(err.Error(), "too many country codes") {
+		t.Errorf("setTmpIPBlocks: invalid error: %v", err)
+	}
+	if len(db.tmpIB.dicCCStrToInt) != 0 || len(db.tmpIB.data) != 0 {
+		t.Errorf("setTmpIPBlocks: tmpIB is not cleared: %d, %d", len(db.tmpIB.dicCCStrToInt), len(db.tmpIB.data))
+	}
+
+	// 登録済のカントリーコードは上限に達していても使用できる。
+	db = GetDB()
+	for i := 0; i < maxCountryCodes-1; i++ {
+		db.tmpIB.dicCCStrToInt[strconv.Itoa(i)] = uint16(i)
+	}
+	err = db.setTmpIPBlocks(strings.NewReader("apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated\napnic|JP|asn|173|1|20020801|allocated\n"), "")
+	if err != nil {
+		t.Errorf("setTmpIPBlocks: valid data, but error: %v", err)
+	} else if db.tmpIB.dicCCStrToInt["JP"] != maxCountryCodes-1 {
+		t.Errorf("setTmpIPBlocks: tmpIB.dicCCStrToInt[JP] want %d, but %d", maxCountryCodes-1, db.tmpIB.dicCCStrToInt["JP"])
+	}
+}
+
 func TestSetTmpCountryCodes(t *testing.T) {
 	// ファイルの形式が不正
 	db := GetDB()
@@ -685,10 +967,13 @@ func TestLoadIPBDataByFile(t *testing.T) {
 	if db.tmpIB.data == nil || len(db.tmpIB.data) != 1 {
 		t.Errorf("LoadIPBDataByFile: tmpIB.data is invalid: %v", db.tmpIB.data)
 	}
-	if db.tmpIB.dicCCStrToInt == nil || len(db.tmpIB.dicCCStrToInt) != 1 {
+	if db.tmpIB.data6 == nil || len(db.tmpIB.data6) != 5 {
+		t.Errorf("LoadIPBDataByFile: tmpIB.data6 is invalid: %v", db.tmpIB.data6)
+	}
+	if db.tmpIB.dicCCStrToInt == nil || len(db.tmpIB.dicCCStrToInt) != 7 {
 		t.Errorf("LoadIPBDataByFile: tmpIB.dicCCStrToInt is invalid: %v", db.tmpIB.dicCCStrToInt)
 	}
-	if db.tmpIB.dicCCIntToStr == nil || len(db.tmpIB.dicCCIntToStr) != 1 {
+	if db.tmpIB.dicCCIntToStr == nil || len(db.tmpIB.dicCCIntToStr) != 7 {
 		t.Errorf("LoadIPBDataByFile: tmpIB.dicCCIntToStr is invalid: %v", db.tmpIB.dicCCIntToStr)
 	}
 	if db.tmpIB.totalBlocks == nil || len(db.tmpIB.totalBlocks) != 2 {
@@ -725,20 +1010,26 @@ func TestSwitchIPBData(t *testing.T) {
 	// 一時保存用データベースに値を設定
 	db := GetDB()
 
-	db.tmpIB.data = map[uint8]map[uint8]map[uint8]map[uint8]block{}
-	db.tmpIB.data[0] = map[uint8]map[uint8]map[uint8]block{}
-	db.tmpIB.data[0][0] = map[uint8]map[uint8]block{}
-	db.tmpIB.data[0][0][0] = map[uint8]block{}
-	db.tmpIB.data[0][0][0][0] = block{value: 16, country: 0}
-	db.tmpIB.dicCCIntToStr = map[uint8]string{}
+	db.tmpIB.data = map[uint32]block{}
+	db.tmpIB.data[u32("1.0.0.0")] = block{value: 16, blockAttrs: blockAttrs{registry: "apnic"}}
+	db.tmpIB.data[u32("0.0.0.0")] = block{value: 16, blockAttrs: blockAttrs{registry: "apnic"}}
+	db.tmpIB.dicCCIntToStr = map[uint16]string{}
 	db.tmpIB.dicCCIntToStr[0] = "JP"
-	db.tmpIB.dicCCStrToInt = map[string]uint8{}
+	db.tmpIB.dicCCStrToInt = map[string]uint16{}
 	db.tmpIB.dicCCStrToInt["JP"] = 0
 	db.tmpIB.totalBlocks = map[string]int{"ALL": 1, "JP": 1}
 	db.tmpIB.totalValue = map[string]int{"ALL": 16, "JP": 16}
+	db.tmpIB.data6 = map[netip.Prefix]block{netip.MustParsePrefix("2001:db8::/32"): {value: 32}}
 
 	db.SwitchIPBData()
 
+	if db.tmpIB.data6 == nil || len(db.tmpIB.data6) != 0 {
+		t.Errorf("SwitchIPBData: tmpIB.data6 is invalid: %v", db.tmpIB.data6)
+	}
+	if len(db.ib.data6) != 1 {
+		t.Errorf("SwitchIPBData: db.ib.data6 want 1, but %d: %v", len(db.ib.data6), db.ib.data6)
+	}
+
 	if db.tmpIB.data == nil || len(db.tmpIB.data) != 0 {
 		t.Errorf("SwitchIPBData: tmpIB.data is invalid: %v", db.tmpIB.data)
 	}
@@ -755,18 +1046,25 @@ func TestSwitchIPBData(t *testing.T) {
 		t.Errorf("SwitchIPBData: tmpIB.totalValue is invalid: %v", db.tmpIB.totalValue)
 	}
 
-	if len(db.ib.data) != 1 {
-		t.Errorf("SwitchIPBData: db.ib.data want 1, but %d: %v", len(db.ib.data), db.ib.data)
-	} else if _, ok := db.ib.data[0][0][0][0]; !ok {
-		t.Error("SwitchIPBData: db.ib.data[0][0][0][0] doesn't exist")
+	if db.ib.data != nil {
+		t.Errorf("SwitchIPBData: db.ib.data is invalid: %v", db.ib.data)
+	}
+	// 開始アドレス順に並び、同じ属性は一つにまとめられる。
+	if len(db.ib.ranges) != 2 {
+		t.Errorf("SwitchIPBData: db.ib.ranges want 2, but %d: %v", len(db.ib.ranges), db.ib.ranges)
 	} else {
-		if db.ib.data[0][0][0][0].value != 16 {
-			t.Errorf("SwitchIPBData: db.ib.data[0][0][0][0].ipCidr want 16, but %d", db.ib.data[0][0][0][0].value)
+		if db.ib.ranges[0] != (ipRange{start: u32("0.0.0.0"), end: u32("0.0.0.15"), attrs: 0}) {
+			t.Errorf("SwitchIPBData: db.ib.ranges[0] is invalid: %v", db.ib.ranges[0])
 		}
-		if db.ib.data[0][0][0][0].country != 0 {
-			t.Errorf("SwitchIPBData: db.ib.data[0][0][0][0].ipCidr want 16, but %d", db.ib.data[0][0][0][0].value)
+		if db.ib.ranges[1] != (ipRange{start: u32("1.0.0.0"), end: u32("1.0.0.15"), attrs: 0}) {
+			t.Errorf("SwitchIPBData: db.ib.ranges[1] is invalid: %v", db.ib.ranges[1])
 		}
 	}
+	if len(db.ib.attrs) != 1 {
+		t.Errorf("SwitchIPBData: db.ib.attrs want 1, but %d: %v", len(db.ib.attrs), db.ib.attrs)
+	} else if db.ib.attrs[0] != (blockAttrs{registry: "apnic"}) {
+		t.Errorf("SwitchIPBData: db.ib.attrs[0] is invalid: %v", db.ib.attrs[0])
+	}
 	if len(db.ib.dicCCIntToStr) != 1 {
 		t.Errorf("SwitchIPBData: db.ib.dicCCIntToStr want 1, but %d: %v", len(db.ib.dicCCIntToStr), db.ib.dicCCIntToStr)
 	} else if _, ok := db.ib.dicCCIntToStr[0]; !ok {
@@ -861,6 +1159,29 @@ func TestInitCCDataByFile(t *testing.T) {
 	}
 }
 
+func TestWithSwitchHook(t *testing.T) {
+	var calls []string
+	db := NewDB(
+		WithSwitchHook(func(db *DB) {
+			// ロックの解除後に実行するので、検索できる。
+			calls = append(calls, "1:"+db.LookupCode(netip.MustParseAddr("114.48.0.1")))
+		}),
+		WithSwitchHook(func(db *DB) {
+			calls = append(calls, "2")
+		}),
+	)
+	if err := db.LoadIPBDataByFile("testdata/validIPBlockFile-1"); err != nil {
+		t.Fatalf("LoadIPBDataByFile: but error: %v", err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("WithSwitchHook: called before SwitchIPBData: %v", calls)
+	}
+	db.SwitchIPBData()
+	if want := []string{"1:JP", "2"}; !slices.Equal(calls, want) {
+		t.Errorf("WithSwitchHook: want %v, but %v", want, calls)
+	}
+}
+
 func TestSwitchCCData(t *testing.T) {
 	// 一時保存用データベースに値を設定
 	db := GetDB()
@@ -892,276 +1213,80 @@ func TestSwitchCCData(t *testing.T) {
 	}
 }
 
-func TestCheckFirst8Bit(t *testing.T) {
-	db := GetDB()
-	err := db.LoadIPBDataByFile("testdata/validIPBlockFile-2")
-	if err != nil {
-		t.Fatalf("checkFirst8Bit: load validIPBlockFile-2, but error: %v", err)
-	}
-	db.SwitchIPBData()
-
-	// 初期値一致
-	a4b, found := db.checkFirst8Bit([4]byte{114, 48, 0, 0})
-	if !found {
-		t.Errorf("checkFirst8Bit: not found: %v", a4b)
-	} else if a4b != [4]byte{114, 48, 0, 0} {
-		t.Errorf("checkFirst8Bit: not [4]byte{114, 48, 0, 0}: %v", a4b)
-	}
-
-	// 初期値不一致・最初の8ビットを1づつ減少させて一致
-	a4b, found = db.checkFirst8Bit([4]byte{123, 48, 0, 0})
-	if !found {
-		t.Errorf("checkFirst8Bit: not found: %v", a4b)
-	} else if a4b != [4]byte{114, 255, 255, 255} {
-		t.Errorf("checkFirst8Bit: not [4]byte{114, 255, 255, 255}: %v", a4b)
-	}
-
-	// 初期値不一致・最初の8ビットを1づつ減少させても不一致
-	a4b, found = db.checkFirst8Bit([4]byte{113, 48, 0, 0})
-	if found {
-		t.Errorf("checkFirst8Bit: found: %v", a4b)
-	} else if a4b != [4]byte{113, 48, 0, 0} {
-		t.Errorf("checkFirst8Bit: not [4]byte{113, 48, 0, 0}: %v", a4b)
-	}
-}
-
-func TestCheckSecond8Bit(t *testing.T) {
-	db := GetDB()
-	err := db.LoadIPBDataByFile("testdata/validIPBlockFile-3")
-	if err != nil {
-		t.Fatalf("checkSecond8Bit: load validIPBlockFile-3, but error: %v", err)
-	}
-	db.SwitchIPBData()
-
-	// 初期値一致
-	a4b, found := db.checkSecond8Bit([4]byte{114, 48, 0, 0})
-	if !found {
-		t.Errorf("checkSecond8Bit: not found: %v", a4b)
-	} else if a4b != [4]byte{114, 48, 0, 0} {
-		t.Errorf("checkSecond8Bit: not [4]byte{114, 48, 0, 0}: %v", a4b)
-	}
-
-	// 初期値不一致・データベースの2番めの8ビット0のみ
-	a4b, found = db.checkSecond8Bit([4]byte{49, 111, 0, 0})
-	if !found {
-		t.Errorf("checkSecond8Bit: not found: %v", a4b)
-	} else if a4b != [4]byte{49, 0, 255, 255} {
-		t.Errorf("checkSecond8Bit: not [4]byte{49, 0, 255, 255}: %v", a4b)
-	}
-
-	// 初期値不一致・2番めの8ビットを1づつ減少させて一致
-	a4b, found = db.checkSecond8Bit([4]byte{114, 128, 0, 0})
-	if !found {
-		t.Errorf("checkSecond8Bit: not found: %v", a4b)
-	} else if a4b != [4]byte{114, 48, 255, 255} {
-		t.Errorf("checkSecond8Bit: not [4]byte{114, 48, 255, 255}: %v", a4b)
-	}
-
-	// 初期値不一致・2番めの8ビットを1づつ減少させても不一致
-	a4b, found = db.checkSecond8Bit([4]byte{114, 20, 0, 0})
-	if found {
-		t.Errorf("checkSecond8Bit: found: %v", a4b)
-	} else if a4b != [4]byte{114, 20, 0, 0} {
-		t.Errorf("checkSecond8Bit: not [4]byte{114, 20, 0, 0}: %v", a4b)
-	}
-}
-
-func TestCheckThird8Bit(t *testing.T) {
-	db := GetDB()
-	err := db.LoadIPBDataByFile("testdata/validIPBlockFile-3")
-	if err != nil {
-		t.Fatalf("checkThird8Bit: load validIPBlockFile-3, but error: %v", err)
-	}
-	db.SwitchIPBData()
-
-	// 初期値一致
-	a4b, found := db.checkThird8Bit([4]byte{114, 48, 0, 0})
-	if !found {
-		t.Errorf("checkThird8Bit: not found: %v", a4b)
-	} else if a4b != [4]byte{114, 48, 0, 0} {
-		t.Errorf("checkThird8Bit: not [4]byte{114, 48, 0, 0}: %v", a4b)
-	}
-
-	// 初期値不一致・データベースの3番めの8ビット0のみ
-	a4b, found = db.checkThird8Bit([4]byte{49, 0, 111, 0})
-	if !found {
-		t.Errorf("checkThird8Bit: not found: %v", a4b)
-	} else if a4b != [4]byte{49, 0, 0, 255} {
-		t.Errorf("checkThird8Bit: not [4]byte{49, 0, 0, 255}: %v", a4b)
-	}
-
-	// 初期値不一致・3番めの8ビットを1づつ減少させて一致
-	a4b, found = db.checkThird8Bit([4]byte{114, 31, 255, 0})
-	if !found {
-		t.Errorf("checkThird8Bit: not found: %v", a4b)
-	} else if a4b != [4]byte{114, 31, 248, 255} {
-		t.Errorf("checkThird8Bit: not [4]byte{114, 31, 248, 255}: %v", a4b)
-	}
-
-	// 初期値不一致・3番めの8ビットを1づつ減少させても不一致
-	a4b, found = db.checkThird8Bit([4]byte{114, 31, 128, 0})
-	if found {
-		t.Errorf("checkThird8Bit: found: %v", a4b)
-	} else if a4b != [4]byte{114, 31, 128, 0} {
-		t.Errorf("checkThird8Bit: not [4]byte{114, 31, 128, 0}: %v", a4b)
-	}
-}
-
-func TestCheckLast8Bit(t *testing.T) {
-	db := GetDB()
-	err := db.LoadIPBDataByFile("testdata/validIPBlockFile-3")
-	if err != nil {
-		t.Fatalf("checkLast8Bit: load validIPBlockFile-3, but error: %v", err)
-	}
-	db.SwitchIPBData()
-
-	// 初期値一致
-	a4b, found := db.checkLast8Bit([4]byte{114, 48, 0, 0})
-	if !found {
-		t.Errorf("checkLast8Bit: not found: %v", a4b)
-	} else if a4b != [4]byte{114, 48, 0, 0} {
-		t.Errorf("checkLast8Bit: not [4]byte{114, 48, 0, 0}: %v", a4b)
-	}
-
-	// 初期値不一致・データベースの最後の8ビット0のみ
-	a4b, found = db.checkLast8Bit([4]byte{49, 0, 0, 240})
-	if !found {
-		t.Errorf("checkLast8Bit: not found: %v", a4b)
-	} else if a4b != [4]byte{49, 0, 0, 0} {
-		t.Errorf("checkLast8Bit: not [4]byte{49, 0, 0, 0}: %v", a4b)
-	}
-
-	// 初期値不一致・最後の8ビットを1づつ減少させて一致
-	a4b, found = db.checkLast8Bit([4]byte{114, 31, 248, 182})
-	if !found {
-		t.Errorf("checkLast8Bit: not found: %v", a4b)
-	} else if a4b != [4]byte{114, 31, 248, 128} {
-		t.Errorf("checkLast8Bit: not [4]byte{114, 31, 248, 128}: %v", a4b)
-	}
-
-	// 初期値不一致・最後の8ビットを1づつ減少させても不一致
-	a4b, found = db.checkLast8Bit([4]byte{114, 31, 248, 60})
-	if found {
-		t.Errorf("checkLast8Bit: found: %v", a4b)
-	} else if a4b != [4]byte{114, 31, 248, 60} {
-		t.Errorf("checkLast8Bit: not [4]byte{114, 31, 128, 60}: %v", a4b)
-	}
-}
-
-func TestSearchBlockStart(t *testing.T) {
+func TestSearchRange(t *testing.T) {
 	// 全てのifを通過する。
 	db := GetDB()
 	err := db.LoadIPBDataByFile("testdata/validIPBlockFile-4")
 	if err != nil {
-		t.Fatalf("searchBlockStart: load validIPBlockFile-4, but error: %v", err)
+		t.Fatalf("searchRange: load validIPBlockFile-4, but error: %v", err)
 	}
 	db.SwitchIPBData()
 
-	target, err := netip.ParseAddr("0.0.0.64")
-	if err != nil {
-		t.Fatalf("searchBlockStart: failed to ParseAddr: %v", err)
-	}
-	a4b := db.searchBlockStart(target)
-	if a4b != [4]byte{} {
-		t.Errorf("searchBlockStart: not [4]byte{}: %v", a4b)
+	i := searchRange(db.ib.ranges, u32("0.0.0.64"))
+	if i != -1 {
+		t.Errorf("searchRange: not -1: %d", i)
 	}
 
-	target, err = netip.ParseAddr("2.0.0.10")
-	if err != nil {
-		t.Fatalf("searchBlockStart: failed to ParseAddr: %v", err)
-	}
-	a4b = db.searchBlockStart(target)
-	if a4b != [4]byte{0, 0, 0, 128} {
-		t.Errorf("searchBlockStart: not [4]byte{0, 0, 0, 128}: %v", a4b)
+	i = searchRange(db.ib.ranges, u32("2.0.0.10"))
+	if i < 0 || db.ib.ranges[i].start != u32("0.0.0.128") {
+		t.Errorf("searchRange: not 0.0.0.128: %d", i)
 	}
 
-	target, err = netip.ParseAddr("114.48.0.16")
-	if err != nil {
-		t.Fatalf("searchBlockStart: failed to ParseAddr: %v", err)
-	}
-	a4b = db.searchBlockStart(target)
-	if a4b != [4]byte{2, 0, 0, 64} {
-		t.Errorf("searchBlockStart: not [4]byte{2, 0, 0, 64}: %v", a4b)
+	i = searchRange(db.ib.ranges, u32("114.48.0.16"))
+	if i < 0 || db.ib.ranges[i].start != u32("2.0.0.64") {
+		t.Errorf("searchRange: not 2.0.0.64: %d", i)
 	}
 
-	target, err = netip.ParseAddr("124.147.128.8")
-	if err != nil {
-		t.Fatalf("searchBlockStart: failed to ParseAddr: %v", err)
-	}
-	a4b = db.searchBlockStart(target)
-	if a4b != [4]byte{114, 48, 0, 32} {
-		t.Errorf("searchBlockStart: not [4]byte{2, 0, 0, 64}: %v", a4b)
+	i = searchRange(db.ib.ranges, u32("124.147.128.8"))
+	if i < 0 || db.ib.ranges[i].start != u32("114.48.0.32") {
+		t.Errorf("searchRange: not 114.48.0.32: %d", i)
 	}
 
 	err = db.LoadIPBDataByFile("testdata/validIPBlockFile-5")
 	if err != nil {
-		t.Fatalf("searchBlockStart: load validIPBlockFile-5, but error: %v", err)
+		t.Fatalf("searchRange: load validIPBlockFile-5, but error: %v", err)
 	}
 	db.SwitchIPBData()
 
-	target, err = netip.ParseAddr("0.0.100.10")
-	if err != nil {
-		t.Fatalf("searchBlockStart: failed to ParseAddr: %v", err)
-	}
-	a4b = db.searchBlockStart(target)
-	if a4b != [4]byte{} {
-		t.Errorf("searchBlockStart: not [4]byte{}: %v", a4b)
+	i = searchRange(db.ib.ranges, u32("0.0.100.10"))
+	if i != -1 {
+		t.Errorf("searchRange: not -1: %d", i)
 	}
 
-	target, err = netip.ParseAddr("2.0.0.10")
-	if err != nil {
-		t.Fatalf("searchBlockStart: failed to ParseAddr: %v", err)
-	}
-	a4b = db.searchBlockStart(target)
-	if a4b != [4]byte{0, 0, 128, 64} {
-		t.Errorf("searchBlockStart: not [4]byte{0, 0, 128, 64}: %v", a4b)
+	i = searchRange(db.ib.ranges, u32("2.0.0.10"))
+	if i < 0 || db.ib.ranges[i].start != u32("0.0.128.64") {
+		t.Errorf("searchRange: not 0.0.128.64: %d", i)
 	}
 
-	target, err = netip.ParseAddr("114.48.8.16")
-	if err != nil {
-		t.Fatalf("searchBlockStart: failed to ParseAddr: %v", err)
-	}
-	a4b = db.searchBlockStart(target)
-	if a4b != [4]byte{2, 0, 0, 64} {
-		t.Errorf("searchBlockStart: not [4]byte{2, 0, 0, 64}: %v", a4b)
+	i = searchRange(db.ib.ranges, u32("114.48.8.16"))
+	if i < 0 || db.ib.ranges[i].start != u32("2.0.0.64") {
+		t.Errorf("searchRange: not 2.0.0.64: %d", i)
 	}
 
 	err = db.LoadIPBDataByFile("testdata/validIPBlockFile-6")
 	if err != nil {
-		t.Fatalf("searchBlockStart: load validIPBlockFile-6, but error: %v", err)
+		t.Fatalf("searchRange: load validIPBlockFile-6, but error: %v", err)
 	}
 	db.SwitchIPBData()
 
-	target, err = netip.ParseAddr("0.6.100.10")
-	if err != nil {
-		t.Fatalf("searchBlockStart: failed to ParseAddr: %v", err)
-	}
-	a4b = db.searchBlockStart(target)
-	if a4b != [4]byte{} {
-		t.Errorf("searchBlockStart: not [4]byte{}: %v", a4b)
-	}
-	target, err = netip.ParseAddr("2.0.0.10")
-	if err != nil {
-		t.Fatalf("searchBlockStart: failed to ParseAddr: %v", err)
+	i = searchRange(db.ib.ranges, u32("0.6.100.10"))
+	if i != -1 {
+		t.Errorf("searchRange: not -1: %d", i)
 	}
-	a4b = db.searchBlockStart(target)
-	if a4b != [4]byte{0, 8, 128, 64} {
-		t.Errorf("searchBlockStart: not [4]byte{0, 8, 128, 64}: %v", a4b)
+	i = searchRange(db.ib.ranges, u32("2.0.0.10"))
+	if i < 0 || db.ib.ranges[i].start != u32("0.8.128.64") {
+		t.Errorf("searchRange: not 0.8.128.64: %d", i)
 	}
 
 	err = db.LoadIPBDataByFile("testdata/validIPBlockFile-7")
 	if err != nil {
-		t.Fatalf("searchBlockStart: load validIPBlockFile-7, but error: %v", err)
+		t.Fatalf("searchRange: load validIPBlockFile-7, but error: %v", err)
 	}
 	db.SwitchIPBData()
 
-	target, err = netip.ParseAddr("0.0.0.1")
-	if err != nil {
-		t.Fatalf("searchBlockStart: failed to ParseAddr: %v", err)
-	}
-	a4b = db.searchBlockStart(target)
-	if a4b != [4]byte{} {
-		t.Errorf("searchBlockStart: not [4]byte{}: %v", a4b)
+	i = searchRange(db.ib.ranges, u32("0.0.0.1"))
+	if i != -1 {
+		t.Errorf("searchRange: not -1: %d", i)
 	}
 }
 
@@ -1185,6 +1310,9 @@ func TestSearchInfo(t *testing.T) {
 	if sr.Message != "Invalid IP Address" {
 		t.Errorf("SearchInfo: want Message (Invalid IP Address), but got %s: %v", sr.Message, sr)
 	}
+	if sr.Status != StatusInvalidAddress {
+		t.Errorf("SearchInfo: want Status (StatusInvalidAddress), but got %v: %v", sr.Status, sr)
+	}
 	if sr.BlockStart != "" {
 		t.Errorf("SearchInfo: want BlockStart empty, but got %s: %v", sr.BlockStart, sr)
 	}
@@ -1201,13 +1329,16 @@ func TestSearchInfo(t *testing.T) {
 		t.Errorf("SearchInfo: want AName empty, but got %s: %v", sr.AltName, sr)
 	}
 
-	// IPv4アドレスでない
+	// IPv6アドレス・情報なし
 	sr = db.SearchInfo("2001:0db8:3c4d:0015:0000:0000:1a2f:1a2b")
 	if sr.IsFound {
 		t.Errorf("SearchInfo: found: %v", sr)
 	}
-	if sr.Message != "Not IPv4 Address" {
-		t.Errorf("SearchInfo: want Message (Not IPv4 Address), but got %s: %v", sr.Message, sr)
+	if sr.Message != "Not Found" {
+		t.Errorf("SearchInfo: want Message (Not Found), but got %s: %v", sr.Message, sr)
+	}
+	if sr.Status != StatusNotFound {
+		t.Errorf("SearchInfo: want Status (StatusNotFound), but got %v: %v", sr.Status, sr)
 	}
 	if sr.BlockStart != "" {
 		t.Errorf("SearchInfo: want BlockStart empty, but got %s: %v", sr.BlockStart, sr)
@@ -1233,6 +1364,9 @@ func TestSearchInfo(t *testing.T) {
 	if sr.Message != "Loopback Address" {
 		t.Errorf("SearchInfo: want Message (Loopback Address), but got %s: %v", sr.Message, sr)
 	}
+	if sr.Status != StatusLoopback {
+		t.Errorf("SearchInfo: want Status (StatusLoopback), but got %v: %v", sr.Status, sr)
+	}
 	if sr.BlockStart != "" {
 		t.Errorf("SearchInfo: want BlockStart empty, but got %s: %v", sr.BlockStart, sr)
 	}
@@ -1257,6 +1391,9 @@ func TestSearchInfo(t *testing.T) {
 	if sr.Message != "Multicast Address" {
 		t.Errorf("SearchInfo: want Message (Multicast Address), but got %s: %v", sr.Message, sr)
 	}
+	if sr.Status != StatusMulticast {
+		t.Errorf("SearchInfo: want Status (StatusMulticast), but got %v: %v", sr.Status, sr)
+	}
 	if sr.BlockStart != "" {
 		t.Errorf("SearchInfo: want BlockStart empty, but got %s: %v", sr.BlockStart, sr)
 	}
@@ -1281,6 +1418,9 @@ func TestSearchInfo(t *testing.T) {
 	if sr.Message != "Private Address" {
 		t.Errorf("SearchInfo: want Message (Private Address), but got %s: %v", sr.Message, sr)
 	}
+	if sr.Status != StatusPrivate {
+		t.Errorf("SearchInfo: want Status (StatusPrivate), but got %v: %v", sr.Status, sr)
+	}
 	if sr.BlockStart != "" {
 		t.Errorf("SearchInfo: want BlockStart empty, but got %s: %v", sr.BlockStart, sr)
 	}
@@ -1305,6 +1445,9 @@ func TestSearchInfo(t *testing.T) {
 	if sr.Message != "Not Found" {
 		t.Errorf("SearchInfo: want Message (Not Found), but got %s: %v", sr.Message, sr)
 	}
+	if sr.Status != StatusNotFound {
+		t.Errorf("SearchInfo: want Status (StatusNotFound), but got %v: %v", sr.Status, sr)
+	}
 	if sr.BlockStart != "" {
 		t.Errorf("SearchInfo: want BlockStart empty, but got %s: %v", sr.BlockStart, sr)
 	}
@@ -1329,6 +1472,9 @@ func TestSearchInfo(t *testing.T) {
 	if sr.Message != "Found" {
 		t.Errorf("SearchInfo: want Message (Found), but got %s: %v", sr.Message, sr)
 	}
+	if sr.Status != StatusFound {
+		t.Errorf("SearchInfo: want Status (StatusFound), but got %v: %v", sr.Status, sr)
+	}
 	if sr.BlockStart != "114.48.0.0" {
 		t.Errorf("SearchInfo: want BlockStart 114.48.0.0, but got %s: %v", sr.BlockStart, sr)
 	}
@@ -1344,6 +1490,287 @@ func TestSearchInfo(t *testing.T) {
 	if sr.AltName != "日本" {
 		t.Errorf("SearchInfo: want AName 日本, but got %s: %v", sr.AltName, sr)
 	}
+	if sr.Registry != "apnic" {
+		t.Errorf("SearchInfo: want Registry apnic, but got %s: %v", sr.Registry, sr)
+	}
+	if sr.AllocationStatus != "allocated" {
+		t.Errorf("SearchInfo: want AllocationStatus allocated, but got %s: %v", sr.AllocationStatus, sr)
+	}
+	if !sr.AllocatedOn.Equal(time.Date(2008, 4, 22, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("SearchInfo: want AllocatedOn 2008-04-22, but got %v: %v", sr.AllocatedOn, sr)
+	}
+	if sr.OpaqueID != "" {
+		t.Errorf("SearchInfo: want OpaqueID empty, but got %s: %v", sr.OpaqueID, sr)
+	}
+
+	// extended format の opaque-id と日付不明のレコード
+	err = db.setTmpIPBlocks(strings.NewReader(`arin|US|ipv4|2.57.164.0|1024|20230828|allocated|a015d0af44d434b219c6308d56e23f9e
+arin||ipv4|23.131.145.0|3840||reserved|
+`), "")
+	if err != nil {
+		t.Fatalf("SearchInfo: failed to set data: %v", err)
+	}
+	db.SwitchIPBData()
+	sr = db.SearchInfo("2.57.167.255")
+	if !sr.IsFound {
+		t.Errorf("SearchInfo: not found: %v", sr)
+	}
+	if sr.Registry != "arin" || sr.AllocationStatus != "allocated" || sr.OpaqueID != "a015d0af44d434b219c6308d56e23f9e" {
+		t.Errorf("SearchInfo: invalid attributes: %v", sr)
+	}
+	if !sr.AllocatedOn.Equal(time.Date(2023, 8, 28, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("SearchInfo: want AllocatedOn 2023-08-28, but got %v: %v", sr.AllocatedOn, sr)
+	}
+	sr = db.SearchInfo("23.131.145.0")
+	if !sr.IsFound {
+		t.Errorf("SearchInfo: not found: %v", sr)
+	}
+	if sr.Code != "" || sr.Registry != "arin" || sr.AllocationStatus != "reserved" || sr.OpaqueID != "" {
+		t.Errorf("SearchInfo: invalid attributes: %v", sr)
+	}
+	if !sr.AllocatedOn.IsZero() {
+		t.Errorf("SearchInfo: want AllocatedOn zero, but got %v: %v", sr.AllocatedOn, sr)
+	}
+}
+
+func TestStatusString(t *testing.T) {
+	for s, want := range map[Status]string{
+		StatusNotFound:       "Not Found",
+		StatusFound:          "Found",
+		StatusInvalidAddress: "Invalid IP Address",
+		StatusLoopback:       "Loopback Address",
+		StatusMulticast:      "Multicast Address",
+		StatusPrivate:        "Private Address",
+		Status(100):          "Status(100)",
+	} {
+		if s.String() != want {
+			t.Errorf("Status.String: want %s, but %s", want, s.String())
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	for _, v := range []struct {
+		s    string
+		want uint32
+	}{
+		{"20080422", 20080422},
+		{"19700101", 19700101},
+		{"", 0},
+		{"00000000", 0},
+		{"20081332", 0},
+		{"2008042", 0},
+	} {
+		d := parseDate(v.s)
+		if d != v.want {
+			t.Errorf("parseDate: %q want %d, but got %d", v.s, v.want, d)
+		}
+		if d == 0 {
+			if !dateToTime(d).IsZero() {
+				t.Errorf("dateToTime: %d want zero, but got %v", d, dateToTime(d))
+			}
+		} else if dateToTime(d).Format("20060102") != v.s {
+			t.Errorf("dateToTime: %d want %s, but got %v", d, v.s, dateToTime(d))
+		}
+	}
+}
+
+func TestIntern(t *testing.T) {
+	db := GetDB()
+	line := "apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated|A91A7381"
+	a := db.tmpIB.intern(line[len(line)-8:])
+	b := db.tmpIB.intern(strings.Clone("A91A7381"))
+	if a != "A91A7381" || b != "A91A7381" {
+		t.Errorf("intern: invalid string: %s, %s", a, b)
+	}
+	if unsafe.StringData(a) != unsafe.StringData(b) {
+		t.Error("intern: strings are not shared")
+	}
+	if unsafe.StringData(a) == unsafe.StringData(line[len(line)-8:]) {
+		t.Error("intern: string is not cloned")
+	}
+}
+
+func TestSearchInfo6(t *testing.T) {
+	db := GetDB()
+	err := db.LoadIPBDataByFile("testdata/validIPBlockFile-1")
+	if err != nil {
+		t.Fatalf("SearchInfo: load validIPBlockFile-1, but error: %v", err)
+	}
+	db.SwitchIPBData()
+
+	// IPv6 のループバックアドレス
+	sr := db.SearchInfo("::1")
+	if sr.IsFound {
+		t.Errorf("SearchInfo: found: %v", sr)
+	}
+	if sr.Message != "Loopback Address" {
+		t.Errorf("SearchInfo: want Message (Loopback Address), but got %s: %v", sr.Message, sr)
+	}
+	if sr.Status != StatusLoopback {
+		t.Errorf("SearchInfo: want Status (StatusLoopback), but got %v: %v", sr.Status, sr)
+	}
+
+	// IPv6 のプライベートアドレス
+	sr = db.SearchInfo("fd00::1")
+	if sr.IsFound {
+		t.Errorf("SearchInfo: found: %v", sr)
+	}
+	if sr.Message != "Private Address" {
+		t.Errorf("SearchInfo: want Message (Private Address), but got %s: %v", sr.Message, sr)
+	}
+	if sr.Status != StatusPrivate {
+		t.Errorf("SearchInfo: want Status (StatusPrivate), but got %v: %v", sr.Status, sr)
+	}
+
+	// IPv6アドレス・情報あり
+	sr = db.SearchInfo("2001:df2:6180:1234::1")
+	if !sr.IsFound {
+		t.Errorf("SearchInfo: not found: %v", sr)
+	}
+	if sr.Message != "Found" {
+		t.Errorf("SearchInfo: want Message (Found), but got %s: %v", sr.Message, sr)
+	}
+	if sr.Status != StatusFound {
+		t.Errorf("SearchInfo: want Status (StatusFound), but got %v: %v", sr.Status, sr)
+	}
+	if sr.BlockStart != "2001:df2:6180::" {
+		t.Errorf("SearchInfo: want BlockStart 2001:df2:6180::, but got %s: %v", sr.BlockStart, sr)
+	}
+	if sr.BlockEnd != "2001:df2:6180:ffff:ffff:ffff:ffff:ffff" {
+		t.Errorf("SearchInfo: want BlockEnd 2001:df2:6180:ffff:ffff:ffff:ffff:ffff, but got %s: %v", sr.BlockEnd, sr)
+	}
+	if sr.Code != "HK" {
+		t.Errorf("SearchInfo: want Code HK, but got %s: %v", sr.Code, sr)
+	}
+	if sr.Registry != "apnic" || sr.AllocationStatus != "assigned" {
+		t.Errorf("SearchInfo: invalid attributes: %v", sr)
+	}
+	if !sr.AllocatedOn.Equal(time.Date(2019, 12, 16, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("SearchInfo: want AllocatedOn 2019-12-16, but got %v: %v", sr.AllocatedOn, sr)
+	}
+
+	// IPv6アドレス・ブロックの直後
+	sr = db.SearchInfo("2001:df2:6181::")
+	if sr.IsFound {
+		t.Errorf("SearchInfo: found: %v", sr)
+	}
+	if sr.Message != "Not Found" {
+		t.Errorf("SearchInfo: want Message (Not Found), but got %s: %v", sr.Message, sr)
+	}
+	if sr.Status != StatusNotFound {
+		t.Errorf("SearchInfo: want Status (StatusNotFound), but got %v: %v", sr.Status, sr)
+	}
+
+	// IPv4 射影アドレスは IPv4 アドレスとして検索
+	sr = db.SearchInfo("::ffff:114.48.0.1")
+	if !sr.IsFound {
+		t.Errorf("SearchInfo: not found: %v", sr)
+	}
+	if sr.BlockStart != "114.48.0.0" {
+		t.Errorf("SearchInfo: want BlockStart 114.48.0.0, but got %s: %v", sr.BlockStart, sr)
+	}
+	if sr.Code != "JP" {
+		t.Errorf("SearchInfo: want Code JP, but got %s: %v", sr.Code, sr)
+	}
+}
+
+func TestSearchASN(t *testing.T) {
+	db := GetDB()
+	if err := db.InitCCDataByFile("testdata/validCountryCodeFile-3"); err != nil {
+		t.Fatalf("SearchASN: file validCountryCodeFile-3, but error: %v", err)
+	}
+
+	// データが空
+	ar := db.SearchASN(173)
+	if ar.IsFound || ar.Status != StatusNotFound || ar.Message != "Not Found" {
+		t.Errorf("SearchASN: want Not Found, but got %v", ar)
+	}
+
+	err := db.setTmpIPBlocks(strings.NewReader(`apnic|JP|asn|173|1|20020801|allocated
+apnic|AU|asn|1221|1|20000131|allocated
+ripencc|EU|asn|196608|1024|20070522|allocated|a1b2c3
+apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated
+`), "")
+	if err != nil {
+		t.Fatalf("SearchASN: failed to set data: %v", err)
+	}
+	db.SwitchIPBData()
+
+	if len(db.ib.asnRanges) != 3 {
+		t.Fatalf("SearchASN: ib.asnRanges length want 3, but %d: %v", len(db.ib.asnRanges), db.ib.asnRanges)
+	}
+	for i, want := range []uint32{173, 1221, 196608} {
+		if db.ib.asnRanges[i].start != want {
+			t.Errorf("SearchASN: ib.asnRanges[%d].start want %d, but %d", i, want, db.ib.asnRanges[i].start)
+		}
+	}
+
+	// 先頭より前
+	ar = db.SearchASN(1)
+	if ar.IsFound || ar.Status != StatusNotFound || ar.Message != "Not Found" {
+		t.Errorf("SearchASN: want Not Found, but got %v", ar)
+	}
+
+	// 一致
+	ar = db.SearchASN(173)
+	if !ar.IsFound || ar.Status != StatusFound || ar.Message != "Found" {
+		t.Errorf("SearchASN: want Found, but got %v", ar)
+	}
+	if ar.ASNStart != 173 || ar.ASNEnd != 173 {
+		t.Errorf("SearchASN: want 173-173, but got %d-%d", ar.ASNStart, ar.ASNEnd)
+	}
+	if ar.Code != "JP" || ar.Name != "Japan" || ar.AltName != "日本" {
+		t.Errorf("SearchASN: invalid country: %v", ar)
+	}
+	if ar.Registry != "apnic" || ar.AllocationStatus != "allocated" || ar.OpaqueID != "" {
+		t.Errorf("SearchASN: invalid attributes: %v", ar)
+	}
+	if !ar.AllocatedOn.Equal(time.Date(2002, 8, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("SearchASN: invalid attributes: %v", ar)
+	}
+
+	// ブロックの間
+	ar = db.SearchASN(174)
+	if ar.IsFound || ar.Status != StatusNotFound || ar.Message != "Not Found" {
+		t.Errorf("SearchASN: want Not Found, but got %v", ar)
+	}
+
+	// 範囲内
+	ar = db.SearchASN(197631)
+	if !ar.IsFound {
+		t.Errorf("SearchASN: want Found, but got %v", ar)
+	}
+	if ar.ASNStart != 196608 || ar.ASNEnd != 197631 {
+		t.Errorf("SearchASN: want 196608-197631, but got %d-%d", ar.ASNStart, ar.ASNEnd)
+	}
+	if ar.Code != "EU" || ar.Name != "" || ar.Registry != "ripencc" || ar.OpaqueID != "a1b2c3" {
+		t.Errorf("SearchASN: invalid attributes: %v", ar)
+	}
+
+	// 範囲の直後
+	ar = db.SearchASN(197632)
+	if ar.IsFound || ar.Status != StatusNotFound || ar.Message != "Not Found" {
+		t.Errorf("SearchASN: want Not Found, but got %v", ar)
+	}
+}
+
+func TestGetLastAddrOfPrefix(t *testing.T) {
+	for _, v := range []struct {
+		prefix string
+		want   string
+	}{
+		{"2001:df2:6180::/48", "2001:df2:6180:ffff:ffff:ffff:ffff:ffff"},
+		{"2001:db8::1/128", "2001:db8::1"},
+		{"::/0", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+		{"114.48.0.0/14", "114.51.255.255"},
+		{"0.0.0.0/0", "255.255.255.255"},
+		{"1.2.3.4/32", "1.2.3.4"},
+	} {
+		if got := getLastAddrOfPrefix(netip.MustParsePrefix(v.prefix)); got.String() != v.want {
+			t.Errorf("getLastAddrOfPrefix: %s want %s, but got %s", v.prefix, v.want, got)
+		}
+	}
 }
 
 func TestLoadIPBDataByURL(t *testing.T) {
@@ -1398,14 +1825,14 @@ func TestLoadIPBDataByURL(t *testing.T) {
 		if len(db.tmpIB.data) != 1 {
 			t.Errorf("LoadIPBDataByURL: tmpIB.data length want 1, but %d: %v", len(db.tmpIB.data), db.tmpIB.data)
 		}
-		if _, ok := db.tmpIB.data[114][48][0][0]; !ok {
-			t.Error("LoadIPBDataByURL: tmpIB.data[114][48][0][0] doesn't exist")
+		if _, ok := db.tmpIB.data[u32("114.48.0.0")]; !ok {
+			t.Error("LoadIPBDataByURL: tmpIB.data[114.48.0.0] doesn't exist")
 		} else {
-			if db.tmpIB.data[114][48][0][0].country != 0 {
-				t.Errorf("LoadIPBDataByURL: tmpIB.data[114][48][0][0].country want 0, but got %d", db.tmpIB.data[114][48][0][0].country)
+			if db.tmpIB.data[u32("114.48.0.0")].country != 0 {
+				t.Errorf("LoadIPBDataByURL: tmpIB.data[114.48.0.0].country want 0, but got %d", db.tmpIB.data[u32("114.48.0.0")].country)
 			}
-			if db.tmpIB.data[114][48][0][0].value != 262144 {
-				t.Errorf("LoadIPBDataByURL: tmpIB.data[114][48][0][0].ipCidr want 262144, but got %d", db.tmpIB.data[114][48][0][0].value)
+			if db.tmpIB.data[u32("114.48.0.0")].value != 262144 {
+				t.Errorf("LoadIPBDataByURL: tmpIB.data[114.48.0.0].ipCidr want 262144, but got %d", db.tmpIB.data[u32("114.48.0.0")].value)
 			}
 		}
 		if len(db.tmpIB.dicCCStrToInt) != 1 {
@@ -1458,12 +1885,64 @@ func TestLoadIPBDataByURL(t *testing.T) {
 	}
 }
 
+func TestLoadIPBDataByURLContext(t *testing.T) {
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"/hang",
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+		},
+	)
+	mux.HandleFunc(
+		"/good",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated")
+		},
+	)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	defer close(release)
+
+	// キャンセル済
+	db := GetDB()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.LoadIPBDataByURLContext(ctx, ts.URL+"/good"); !errors.Is(err, context.Canceled) {
+		t.Errorf("LoadIPBDataByURLContext: canceled, but invalid error: %v", err)
+	}
+	if len(db.tmpIB.data) != 0 {
+		t.Errorf("LoadIPBDataByURLContext: tmpIB.data length want 0, but %d: %v", len(db.tmpIB.data), db.tmpIB.data)
+	}
+
+	// 応答がないまま期限に達する
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := db.LoadIPBDataByURLContext(ctx, ts.URL+"/hang"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("LoadIPBDataByURLContext: deadline exceeded, but invalid error: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("LoadIPBDataByURLContext: deadline is not honored: %v", d)
+	}
+
+	// 正常
+	if err := db.LoadIPBDataByURLContext(context.Background(), ts.URL+"/good"); err != nil {
+		t.Errorf("LoadIPBDataByURLContext: url is valid, but error: %v", err)
+	} else if _, ok := db.tmpIB.data[u32("114.48.0.0")]; !ok {
+		t.Error("LoadIPBDataByURLContext: tmpIB.data[114.48.0.0] doesn't exist")
+	}
+}
+
 func TestSetIPBData(t *testing.T) {
 	db := &DB{
 		tmpIB: ipBlocks{
-			data:          map[uint8]map[uint8]map[uint8]map[uint8]block{},
-			dicCCIntToStr: map[uint8]string{},
-			dicCCStrToInt: map[string]uint8{},
+			data:          map[uint32]block{},
+			dicCCIntToStr: map[uint16]string{},
+			dicCCStrToInt: map[string]uint16{},
 			totalBlocks:   map[string]int{"ALL": 0},
 			totalValue:    map[string]int{"ALL": 0},
 		},
@@ -1513,33 +1992,22 @@ func TestSetIPBData(t *testing.T) {
 			t.Errorf("SetIPBData: tmpIB.totalValue length want 1, but %d: %v", len(db.tmpIB.totalValue), db.tmpIB.totalValue)
 		}
 
-		if len(db.ib.data) != 4 {
-			t.Errorf("SetIPBData: ib.data length want 4, but %d: %v", len(db.ib.data), db.ib.data)
-		}
-		if _, ok := db.ib.data[41][0][0][0]; !ok {
-			t.Error("SetIPBData: ib.data[41][0][0][0] doesn't exist")
-		} else if db.ib.data[41][0][0][0].value != 2097152 {
-			t.Errorf("SetIPBData: ib.data[41][0][0][0].ipCidr want 2097152, but got %d", db.ib.data[41][0][0][0].value)
-		}
-		if _, ok := db.ib.data[1][0][0][0]; !ok {
-			t.Error("SetIPBData: ib.data[1][0][0][0] doesn't exist")
-		} else if db.ib.data[1][0][0][0].value != 256 {
-			t.Errorf("SetIPBData: ib.data[1][0][0][0].ipCidr want 256, but got %d", db.ib.data[1][0][0][0].value)
-		}
-		if _, ok := db.ib.data[2][57][164][0]; !ok {
-			t.Error("SetIPBData: ib.data[2][57][164][0] doesn't exist")
-		} else if db.ib.data[2][57][164][0].value != 1024 {
-			t.Errorf("SetIPBData: ib.data[2][57][164][0].ipCidr want 1024, but got %d", db.ib.data[2][57][164][0].value)
-		}
-		if _, ok := db.ib.data[5][183][80][0]; !ok {
-			t.Error("SetIPBData: ib.data[5][183][80][0] doesn't exist")
-		} else if db.ib.data[5][183][80][0].value != 1024 {
-			t.Errorf("SetIPBData: ib.data[5][183][80][0].ipCidr want 1024, but got %d", db.ib.data[5][183][80][0].value)
-		}
-		if _, ok := db.ib.data[1][178][112][0]; !ok {
-			t.Error("SetIPBData: ib.data[1][178][112][0] doesn't exist")
-		} else if db.ib.data[1][178][112][0].value != 4096 {
-			t.Errorf("SetIPBData: ib.data[1][178][112][0].ipCidr want 4096, but got %d", db.ib.data[1][178][112][0].value)
+		if len(db.ib.ranges) != 5 {
+			t.Errorf("SetIPBData: ib.ranges length want 5, but %d: %v", len(db.ib.ranges), db.ib.ranges)
+		}
+		for start, value := range map[string]uint32{
+			"41.0.0.0":    2097152,
+			"1.0.0.0":     256,
+			"2.57.164.0":  1024,
+			"5.183.80.0":  1024,
+			"1.178.112.0": 4096,
+		} {
+			i := searchRange(db.ib.ranges, u32(start))
+			if i < 0 || db.ib.ranges[i].start != u32(start) {
+				t.Errorf("SetIPBData: ib.ranges[%s] doesn't exist", start)
+			} else if db.ib.ranges[i].end-db.ib.ranges[i].start+1 != value {
+				t.Errorf("SetIPBData: ib.ranges[%s] value want %d, but got %d", start, value, db.ib.ranges[i].end-db.ib.ranges[i].start+1)
+			}
 		}
 		if len(db.ib.dicCCStrToInt) != 5 {
 			t.Errorf("SetIPBData: ib.dicCCStrToInt length want 5, but %d: %v", len(db.ib.dicCCStrToInt), db.ib.dicCCStrToInt)
@@ -1650,6 +2118,77 @@ func TestSetIPBData(t *testing.T) {
 	}
 }
 
+func TestSetIPBDataContext(t *testing.T) {
+	release := make(chan struct{})
+	urlRIR, mux := getDummyRIR()
+	mux.HandleFunc(
+		"/hang",
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+		},
+	)
+	mux.HandleFunc(
+		"/bad",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "apnic|JP|ipv4|114.48.0.0|262144|20080422")
+		},
+	)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	defer close(release)
+
+	// 一つの RIR で異常が発生すると、応答のない他の RIR の取得も中止する。
+	db := NewDB(WithSourceURLs(ts.URL+"/hang", ts.URL+"/hang", ts.URL+"/bad"))
+	start := time.Now()
+	if err := db.SetIPBDataContext(context.Background()); err == nil {
+		t.Error("SetIPBDataContext: bad data, but no error")
+	} else if !strings.Contains(err.Error(), "of the line's fields is invalid") {
+		t.Errorf("SetIPBDataContext: invalid error: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("SetIPBDataContext: other downloads are not canceled: %v", d)
+	}
+	if !db.IsDBEmpty() {
+		t.Error("SetIPBDataContext: error, but ib is not empty")
+	}
+	if len(db.tmpIB.data) != 0 {
+		t.Errorf("SetIPBDataContext: tmpIB.data length want 0, but %d: %v", len(db.tmpIB.data), db.tmpIB.data)
+	}
+
+	// 期限に達すると取得を中止し、検索用データベースは更新しない。
+	db = NewDB(WithSourceURLs(ts.URL+urlRIR[0], ts.URL+"/hang"))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if err := db.SetIPBDataContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SetIPBDataContext: deadline exceeded, but invalid error: %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("SetIPBDataContext: deadline is not honored: %v", d)
+	}
+	if !db.IsDBEmpty() {
+		t.Error("SetIPBDataContext: deadline exceeded, but ib is not empty")
+	}
+	if len(db.tmpIB.data) != 0 {
+		t.Errorf("SetIPBDataContext: tmpIB.data length want 0, but %d: %v", len(db.tmpIB.data), db.tmpIB.data)
+	}
+
+	// 正常
+	urls := make([]string, len(urlRIR))
+	for i := range urlRIR {
+		urls[i] = ts.URL + urlRIR[i]
+	}
+	db = NewDB(WithSourceURLs(urls...))
+	if err := db.SetIPBDataContext(context.Background()); err != nil {
+		t.Errorf("SetIPBDataContext: urlRIR is valid, but error: %v", err)
+	} else if len(db.ib.ranges) != 5 {
+		t.Errorf("SetIPBDataContext: ib.ranges length want 5, but %d: %v", len(db.ib.ranges), db.ib.ranges)
+	}
+}
+
 func TestGetOneOutside(t *testing.T) {
 	// value が 1 : 引数のアドレスの次のアドレスが返る
 	addr := getOneOutside([4]byte{0, 0, 0, 0}, 1)
@@ -1695,7 +2234,7 @@ func TestGetLastAddr(t *testing.T) {
 	addr, err = GetLastAddr("2001:0db8:3c4d:0015:0000:0000:1a2f:1a2b", 1)
 	if err == nil {
 		t.Errorf("GetLastAddr: addr is invalid, but no error: %v:", addr)
-	} else if err.Error() != ErrorMessageFirstArgumentOutOfRange {
+	} else if !errors.Is(err, ErrFirstArgumentOutOfRange) {
 		t.Errorf("GetLastAddr: invalid error: %v:", err)
 	}
 
@@ -1703,7 +2242,7 @@ func TestGetLastAddr(t *testing.T) {
 	addr, err = GetLastAddr("0.0.0.0", 0)
 	if err == nil {
 		t.Errorf("GetLastAddr: value is invalid, but no error: %v:", addr)
-	} else if err.Error() != ErrorMessageSecondArgumentOutOfRange {
+	} else if !errors.Is(err, ErrSecondArgumentOutOfRange) {
 		t.Errorf("GetLastAddr: invalid error: %v:", err)
 	}
 
@@ -1711,7 +2250,7 @@ func TestGetLastAddr(t *testing.T) {
 	addr, err = GetLastAddr("0.0.0.0", 4294967296)
 	if err == nil {
 		t.Errorf("GetLastAddr: value is invalid, but no error: %v:", addr)
-	} else if err.Error() != ErrorMessageSecondArgumentOutOfRange {
+	} else if !errors.Is(err, ErrSecondArgumentOutOfRange) {
 		t.Errorf("GetLastAddr: invalid error: %v:", err)
 	}
 
@@ -1769,7 +2308,7 @@ func TestGetValue(t *testing.T) {
 	value, err = GetValue("2001:0db8:3c4d:0015:0000:0000:1a2f:1a2c", "0.0.0.0")
 	if err == nil {
 		t.Errorf("GetValue: first address is invalid, but no error: %d:", value)
-	} else if err.Error() != ErrorMessageFirstArgumentOutOfRange {
+	} else if !errors.Is(err, ErrFirstArgumentOutOfRange) {
 		t.Errorf("GetValue: invalid error: %v:", err)
 	}
 
@@ -1785,7 +2324,7 @@ func TestGetValue(t *testing.T) {
 	value, err = GetValue("0.0.0.0", "2001:0db8:3c4d:0015:0000:0000:1a2f:1a2d")
 	if err == nil {
 		t.Errorf("GetValue: second address is invalid, but no error: %d:", value)
-	} else if err.Error() != ErrorMessageSecondArgumentOutOfRange {
+	} else if !errors.Is(err, ErrSecondArgumentOutOfRange) {
 		t.Errorf("GetValue: invalid error: %v:", err)
 	}
 
@@ -1817,9 +2356,9 @@ func TestGetValue(t *testing.T) {
 func TestGetTotalBlocks(t *testing.T) {
 	db := &DB{
 		tmpIB: ipBlocks{
-			data:          map[uint8]map[uint8]map[uint8]map[uint8]block{},
-			dicCCIntToStr: map[uint8]string{},
-			dicCCStrToInt: map[string]uint8{},
+			data:          map[uint32]block{},
+			dicCCIntToStr: map[uint16]string{},
+			dicCCStrToInt: map[string]uint16{},
 			totalBlocks:   map[string]int{"ALL": 0},
 			totalValue:    map[string]int{"ALL": 0},
 		},
@@ -1887,9 +2426,9 @@ func TestGetTotalBlocks(t *testing.T) {
 func TestGetTotalValue(t *testing.T) {
 	db := &DB{
 		tmpIB: ipBlocks{
-			data:          map[uint8]map[uint8]map[uint8]map[uint8]block{},
-			dicCCIntToStr: map[uint8]string{},
-			dicCCStrToInt: map[string]uint8{},
+			data:          map[uint32]block{},
+			dicCCIntToStr: map[uint16]string{},
+			dicCCStrToInt: map[string]uint16{},
 			totalBlocks:   map[string]int{"ALL": 0},
 			totalValue:    map[string]int{"ALL": 0},
 		},
@@ -1957,9 +2496,9 @@ func TestGetTotalValue(t *testing.T) {
 func TestGetCountryCodeData(t *testing.T) {
 	db := &DB{
 		tmpIB: ipBlocks{
-			data:          map[uint8]map[uint8]map[uint8]map[uint8]block{},
-			dicCCIntToStr: map[uint8]string{},
-			dicCCStrToInt: map[string]uint8{},
+			data:          map[uint32]block{},
+			dicCCIntToStr: map[uint16]string{},
+			dicCCStrToInt: map[string]uint16{},
 			totalBlocks:   map[string]int{"ALL": 0},
 			totalValue:    map[string]int{"ALL": 0},
 		},
@@ -2010,21 +2549,32 @@ func TestIsDBEmpty(t *testing.T) {
 
 	// データベースが nil
 	if !db.IsDBEmpty() {
-		t.Errorf("IsDBEmpty: empty, but false: %v", db.ib.data)
+		t.Errorf("IsDBEmpty: empty, but false: %v", db.ib.ranges)
 	}
 
 	// データベースが空
-	db.ib.data = map[uint8]map[uint8]map[uint8]map[uint8]block{}
+	db.ib.ranges = []ipRange{}
 	if !db.IsDBEmpty() {
-		t.Errorf("IsDBEmpty: empty, but false: %v", db.ib.data)
+		t.Errorf("IsDBEmpty: empty, but false: %v", db.ib.ranges)
+	}
+
+	// IPv6 のデータのみあり
+	db.ib.data6 = map[netip.Prefix]block{netip.MustParsePrefix("2001:db8::/32"): {value: 32}}
+	if db.IsDBEmpty() {
+		t.Errorf("IsDBEmpty: not empty, but true: %v", db.ib.data6)
+	}
+	db.ib.data6 = nil
+
+	// AS 番号のデータのみあり
+	db.ib.asnRanges = []ipRange{{start: 173, end: 173}}
+	if db.IsDBEmpty() {
+		t.Errorf("IsDBEmpty: not empty, but true: %v", db.ib.asnRanges)
 	}
+	db.ib.asnRanges = nil
 
 	// データあり
-	db.ib.data[0] = map[uint8]map[uint8]map[uint8]block{}
-	db.ib.data[0][0] = map[uint8]map[uint8]block{}
-	db.ib.data[0][0][0] = map[uint8]block{}
-	db.ib.data[0][0][0][0] = block{value: 16, country: 0}
+	db.ib.ranges = append(db.ib.ranges, ipRange{start: 0, end: 15})
 	if db.IsDBEmpty() {
-		t.Errorf("IsDBEmpty: not empty, but true: %v", db.ib.data)
+		t.Errorf("IsDBEmpty: not empty, but true: %v", db.ib.ranges)
 	}
 }
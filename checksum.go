@@ -0,0 +1,85 @@
+package ccipv4
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// チェックサムファイルの URL の接尾辞
+const checksumSuffix = ".md5"
+
+// チェックサムファイルから MD5 の値を取り出すために使用。
+// 各 RIR のチェックサムファイルは
+// "MD5 (delegated-apnic-extended-latest) = <値>" や
+// "<値>  delegated-lacnic-extended-latest" などの形式がある。
+var regForMD5 = regexp.MustCompile(`\b[0-9a-fA-F]{32}\b`)
+
+// 取得したデータのチェックサムが、公開されている値と一致しない場合のエラー
+type ChecksumError struct {
+	URL      string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch: %s: expected %s, but got %s", e.URL, e.Expected, e.Actual)
+}
+
+// URL から取得したデータ b の MD5 を、
+// URL の末尾に .md5 を付けたチェックサムファイルの値と比較する。
+// 一致しない場合は *ChecksumError を返す。
+func (db *DB) verifyChecksum(ctx context.Context, c *http.Client, u string, b []byte) error {
+	expected, err := db.fetchChecksum(ctx, c, u+checksumSuffix)
+	if err != nil {
+		return err
+	}
+
+	sum := md5.Sum(b)
+	actual := hex.EncodeToString(sum[:])
+	if actual != expected {
+		return &ChecksumError{URL: u, Expected: expected, Actual: actual}
+	}
+
+	return nil
+}
+
+// チェックサムファイルを取得し、MD5 の値を小文字で返す。
+func (db *DB) fetchChecksum(ctx context.Context, c *http.Client, u string) (string, error) {
+	req, err := db.newRequest(ctx, u)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &StatusError{URL: u, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	// チェックサムファイルは小さいので、大きすぎる場合は読み込まない。
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+
+	return parseChecksum(string(b))
+}
+
+// チェックサムファイルの内容から MD5 の値を取り出し、小文字で返す。
+func parseChecksum(s string) (string, error) {
+	m := regForMD5.FindString(s)
+	if m == "" {
+		return "", errors.New("md5 checksum not found")
+	}
+
+	return strings.ToLower(m), nil
+}
@@ -0,0 +1,135 @@
+package ccipv4
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadIPBDataByURLWithChecksum(t *testing.T) {
+	body := "apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated\napnic|CN|ipv4|124.147.128.0|32768|20060306|allocated\n"
+	sum := md5.Sum([]byte(body))
+	md5Hex := hex.EncodeToString(sum[:])
+
+	mux := http.NewServeMux()
+	// 正常
+	mux.HandleFunc(
+		"/good",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		},
+	)
+	mux.HandleFunc(
+		"/good.md5",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "MD5 (delegated-apnic-extended-latest) = %s\n", md5Hex)
+		},
+	)
+	// 途中で切れたデータ
+	mux.HandleFunc(
+		"/truncated",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body[:strings.Index(body, "\n")+1])
+		},
+	)
+	mux.HandleFunc(
+		"/truncated.md5",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s  delegated-lacnic-extended-latest\n", strings.ToUpper(md5Hex))
+		},
+	)
+	// チェックサムファイルなし
+	mux.HandleFunc(
+		"/nomd5",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, body)
+		},
+	)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// 検証しない場合は途中で切れたデータも読み込む。
+	db := NewDB()
+	if err := db.LoadIPBDataByURL(ts.URL + "/truncated"); err != nil {
+		t.Errorf("LoadIPBDataByURL: no verification, but error: %v", err)
+	}
+
+	// 一致
+	db = NewDB(WithChecksumVerification(true))
+	if err := db.LoadIPBDataByURL(ts.URL + "/good"); err != nil {
+		t.Errorf("LoadIPBDataByURL: checksum matches, but error: %v", err)
+	} else if len(db.tmpIB.data) != 2 {
+		t.Errorf("LoadIPBDataByURL: tmpIB.data length want 2, but %d", len(db.tmpIB.data))
+	}
+
+	// 不一致
+	db = NewDB(WithChecksumVerification(true))
+	err := db.LoadIPBDataByURL(ts.URL + "/truncated")
+	var ce *ChecksumError
+	if !errors.As(err, &ce) {
+		t.Errorf("LoadIPBDataByURL: checksum mismatch, but invalid error: %v", err)
+	} else {
+		if ce.URL != ts.URL+"/truncated" {
+			t.Errorf("LoadIPBDataByURL: ChecksumError.URL is invalid: %s", ce.URL)
+		}
+		if ce.Expected != md5Hex {
+			t.Errorf("LoadIPBDataByURL: ChecksumError.Expected want %s, but %s", md5Hex, ce.Expected)
+		}
+		if ce.Actual == md5Hex || len(ce.Actual) != 32 {
+			t.Errorf("LoadIPBDataByURL: ChecksumError.Actual is invalid: %s", ce.Actual)
+		}
+	}
+	if len(db.tmpIB.data) != 0 {
+		t.Errorf("LoadIPBDataByURL: checksum mismatch, but tmpIB.data length %d", len(db.tmpIB.data))
+	}
+
+	// チェックサムファイルを取得できない
+	if err := db.LoadIPBDataByURL(ts.URL + "/nomd5"); err == nil {
+		t.Error("LoadIPBDataByURL: no checksum file, but no error")
+	} else if !strings.Contains(err.Error(), "unexpected status: 404") {
+		t.Errorf("LoadIPBDataByURL: invalid error: %v", err)
+	}
+
+	// 不一致のデータはキャッシュに保存しない。
+	db = NewDB(WithChecksumVerification(true), WithCacheDir(filepath.Join(t.TempDir(), "cache")))
+	if err := db.LoadIPBDataByURL(ts.URL + "/truncated"); err == nil {
+		t.Error("LoadIPBDataByURL: checksum mismatch, but no error")
+	}
+	if c := db.readCache(ts.URL + "/truncated"); c != nil {
+		t.Errorf("LoadIPBDataByURL: checksum mismatch, but cached: %v", c)
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{in: "MD5 (delegated-apnic-extended-latest) = 0123456789abcdef0123456789abcdef\n", want: "0123456789abcdef0123456789abcdef"},
+		{in: "0123456789ABCDEF0123456789ABCDEF  delegated-lacnic-extended-latest\n", want: "0123456789abcdef0123456789abcdef"},
+		{in: "0123456789abcdef0123456789abcdef", want: "0123456789abcdef0123456789abcdef"},
+		{in: "", err: true},
+		{in: "0123456789abcdef0123456789abcde", err: true},
+		{in: "0123456789abcdef0123456789abcdef0", err: true},
+	} {
+		got, err := parseChecksum(tc.in)
+		if tc.err {
+			if err == nil {
+				t.Errorf("parseChecksum: %q, but no error: %s", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseChecksum: %q, but error: %v", tc.in, err)
+		} else if got != tc.want {
+			t.Errorf("parseChecksum: %q want %s, but %s", tc.in, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// IP アドレスの国別ブロックのデータから、
+// 選択したカントリーコードのファイアウォール用のルールファイルを作成する。
+//
+//	ccipv4-firewall -include JP,US -format nftables -o /etc/nftables.d/ccipv4.nft
+//
+// -file を指定しない場合は、各 RIR から最新のデータをダウンロードする。
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/suka-test/ccipv4"
+	"github.com/suka-test/ccipv4/firewall"
+	"github.com/suka-test/ccipv4/internal/atomicfile"
+)
+
+const cmdName = "ccipv4-firewall"
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// コマンドライン引数を解析してルールファイルを作成し、終了コードを返す。
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	var (
+		files    []string
+		opts     firewall.Options
+		format   string
+		output   string
+		cacheDir string
+	)
+	fs := flag.NewFlagSet(cmdName, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Func("file", "読み込む delegated ファイル（複数指定可）。指定しない場合はダウンロードする。", func(s string) error {
+		files = append(files, s)
+		return nil
+	})
+	fs.Func("include", "含めるカントリーコード（カンマ区切り）。指定しない場合は全て。", func(s string) error {
+		opts.Include = append(opts.Include, splitList(s)...)
+		return nil
+	})
+	fs.Func("exclude", "除くカントリーコード（カンマ区切り）", func(s string) error {
+		opts.Exclude = append(opts.Exclude, splitList(s)...)
+		return nil
+	})
+	fs.StringVar(&format, "format", firewall.FormatCIDR.String(), "出力形式（ cidr 、 nftables 、 ipset ）")
+	fs.StringVar(&opts.Name, "name", firewall.DefaultName, "set 名の接頭辞")
+	fs.StringVar(&opts.Table, "table", firewall.DefaultTable, "nftables のテーブル名")
+	fs.StringVar(&opts.Family, "family", firewall.DefaultFamily, "nftables のアドレスファミリー")
+	fs.StringVar(&output, "o", "", "出力先のファイル。指定しない場合は標準出力。")
+	fs.StringVar(&cacheDir, "cache", "", "ダウンロードしたデータのキャッシュディレクトリ")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "%s: unexpected arguments: %s\n", cmdName, strings.Join(fs.Args(), " "))
+		return 2
+	}
+	f, err := firewall.ParseFormat(format)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", cmdName, err)
+		return 2
+	}
+
+	var dbOpts []ccipv4.Option
+	if cacheDir != "" {
+		dbOpts = append(dbOpts, ccipv4.WithCacheDir(cacheDir))
+	}
+	db := ccipv4.NewDB(dbOpts...)
+	if err := load(ctx, db, files); err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", cmdName, err)
+		return 1
+	}
+
+	rs, err := firewall.Build(db, opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", cmdName, err)
+		return 1
+	}
+
+	if output == "" {
+		err = rs.Write(stdout, f)
+	} else {
+		err = atomicfile.Write(output, func(w io.Writer) error {
+			return rs.Write(w, f)
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", cmdName, err)
+		return 1
+	}
+
+	return 0
+}
+
+// 指定のファイル、または各 RIR からデータを取得して検索用データベースに格納する。
+func load(ctx context.Context, db *ccipv4.DB, files []string) error {
+	if len(files) == 0 {
+		return db.SetIPBDataContext(ctx)
+	}
+
+	for _, file := range files {
+		if err := db.LoadIPBDataByFile(file); err != nil {
+			db.ClearTmpIPBData()
+			return err
+		}
+	}
+	db.SwitchIPBData()
+
+	return nil
+}
+
+// カンマ区切りの一覧を分割する。空の要素は除く。
+func splitList(s string) []string {
+	var l []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			l = append(l, e)
+		}
+	}
+
+	return l
+}
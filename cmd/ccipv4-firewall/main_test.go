@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			name:   "cidr",
+			args:   []string{"-file", "../../testdata/validIPBlockFile-1", "-include", "jp,hk"},
+			stdout: "114.48.0.0/14\n2001:df2:6180::/48\n",
+		},
+		{
+			name:   "multiple files",
+			args:   []string{"-file", "../../testdata/validIPBlockFile-1", "-file", "../../testdata/validIPBlockFile-2", "-include", "JP", "-exclude", "HK", "-format", "ipset"},
+			stdout: "create ccipv4_v4 hash:net",
+		},
+		{
+			name:   "nftables",
+			args:   []string{"-file", "../../testdata/validIPBlockFile-1", "-include", "PH", "-format", "nftables", "-table", "fw"},
+			stdout: "add element inet fw ccipv4_v6 {\n\t2001:df2:61c0::/48\n}\n",
+		},
+		{
+			name:   "empty selection",
+			args:   []string{"-file", "../../testdata/validIPBlockFile-1", "-include", "NZ"},
+			code:   1,
+			stderr: "no blocks match the selection",
+		},
+		{
+			name:   "file not found",
+			args:   []string{"-file", "../../testdata/notExist"},
+			code:   1,
+			stderr: "notExist",
+		},
+		{
+			name:   "unknown format",
+			args:   []string{"-file", "../../testdata/validIPBlockFile-1", "-format", "pf"},
+			code:   2,
+			stderr: "unknown format",
+		},
+		{
+			name:   "unexpected arguments",
+			args:   []string{"-file", "../../testdata/validIPBlockFile-1", "JP"},
+			code:   2,
+			stderr: "unexpected arguments: JP",
+		},
+		{
+			name:   "unknown flag",
+			args:   []string{"-country", "JP"},
+			code:   2,
+			stderr: "flag provided but not defined",
+		},
+	} {
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), tc.args, &stdout, &stderr)
+		if code != tc.code {
+			t.Errorf("run: %s, exit code want %d, but %d: %s", tc.name, tc.code, code, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), tc.stdout) {
+			t.Errorf("run: %s, stdout want %q, but %q", tc.name, tc.stdout, stdout.String())
+		}
+		if !strings.Contains(stderr.String(), tc.stderr) {
+			t.Errorf("run: %s, stderr want %q, but %q", tc.name, tc.stderr, stderr.String())
+		}
+	}
+}
+
+func TestRunOutput(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "ccipv4.txt")
+	// 既存のファイルは置き換える。
+	if err := os.WriteFile(out, []byte("1.0.0.0/24\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"-file", "../../testdata/validIPBlockFile-1", "-include", "JP", "-o", out}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run: exit code want 0, but %d: %s", code, stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("run: stdout want empty, but %q", stdout.String())
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "114.48.0.0/14\n" {
+		t.Errorf("run: output want %q, but %q", "114.48.0.0/14\n", b)
+	}
+	// 一時ファイルが残らない。
+	if es, _ := os.ReadDir(dir); len(es) != 1 {
+		t.Errorf("run: temporary files remain: %v", es)
+	}
+
+	// 異常の場合は既存のファイルを変更しない。
+	code = run(context.Background(), []string{"-file", "../../testdata/validIPBlockFile-1", "-include", "XX", "-o", out}, &stdout, &stderr)
+	if code != 1 {
+		t.Errorf("run: exit code want 1, but %d", code)
+	}
+	if b, _ := os.ReadFile(out); string(b) != "114.48.0.0/14\n" {
+		t.Errorf("run: output changed: %q", b)
+	}
+}
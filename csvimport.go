@@ -0,0 +1,344 @@
+package ccipv4
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 指定の CSV ファイルを読んで IP アドレスの範囲とカントリーコードのデータを取得し、
+// 一時保存用データベースに格納する。
+// 各行は IP2Location LITE DB1 と同じ "start_int,end_int,cc,name" の形式で、
+// start_int と end_int は範囲の最初と最後のアドレスを 10 進数の整数で表したもの。
+// start_int と end_int がどちらも 4294967295 以下の場合は IPv4 、それ以外は IPv6 の範囲とし、
+// どちらも IPv4 射影アドレス（ ::ffff:0:0/96 ）の場合は IPv4 の範囲とする。
+// IPv4 の範囲はプレフィックスに揃っていなくてもそのまま割当ブロックとし、
+// IPv6 の範囲は最小の個数のプレフィックスに分割して格納する。
+// カントリーコードが "-" または空の行と、数値でない先頭行（ header ）は読み込まない。
+// registry などのその他の情報は空とする。
+func (db *DB) LoadIPBDataByRangeCSV(rangeFile string) error {
+	fp, err := os.Open(rangeFile)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	reader := newImportCSVReader(fp)
+
+	db.tmpIB.l.Lock()
+	defer db.tmpIB.l.Unlock()
+
+	imp := db.newCSVImport(reader, rangeFile)
+	for n := 0; ; n++ {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if err := imp.skip(line, newParseError(-1, ErrMalformedRecord, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		// 先頭行が数値でない場合は header とする。
+		if n == 0 && len(line) > 0 && !isDecimal(line[0]) {
+			continue
+		}
+		if pe := imp.setRange(line); pe != nil {
+			if err := imp.skip(line, pe); err != nil {
+				return err
+			}
+		}
+	}
+	db.tmpIB.addSource(imp.header)
+
+	return nil
+}
+
+// range CSV の１行を一時保存用データベースに格納する。
+func (imp *csvImport) setRange(line []string) *ParseError {
+	if len(line) < 3 {
+		return newParseError(-1, ErrWrongNumberOfFields, fmt.Errorf("%d fields", len(line)))
+	}
+	start, err := parseIntAddr(line[0])
+	if err != nil {
+		return newParseError(0, ErrInvalidIPAddress, err)
+	}
+	end, err := parseIntAddr(line[1])
+	if err != nil {
+		return newParseError(1, ErrInvalidIPAddress, err)
+	}
+	cc := strings.TrimSpace(line[2])
+	if cc == "" || cc == "-" {
+		return nil
+	}
+	// アドレスの種類は行ごとに、最初と最後のアドレスの両方で決める。
+	switch {
+	case start.Less(intAddr4End) && end.Less(intAddr4End):
+		start = netip.AddrFrom4([4]byte(start.AsSlice()[12:]))
+		end = netip.AddrFrom4([4]byte(end.AsSlice()[12:]))
+	case start.Is4In6() && end.Is4In6():
+		start = start.Unmap()
+		end = end.Unmap()
+	}
+	// IPv4 射影アドレスとそれ以外にまたがる範囲は扱わない。
+	if start.Is4In6() != end.Is4In6() || end.Less(start) {
+		return newParseError(1, ErrInvalidValue, fmt.Errorf("invalid range: %s-%s", start, end))
+	}
+
+	return imp.setBlock(cc, 2, start, end)
+}
+
+// 10 進数の整数で表した IPv4 アドレスの上限の次のアドレス（ 2^32 ）
+var intAddr4End = netip.AddrFrom16([16]byte{11: 1})
+
+// 10 進数の整数で表した IP アドレスを、 IPv6 アドレスとして返す。
+func parseIntAddr(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok || v.Sign() < 0 || v.BitLen() > 128 {
+		return netip.Addr{}, fmt.Errorf("invalid integer address: %q", s)
+	}
+	var a [16]byte
+	v.FillBytes(a[:])
+
+	return netip.AddrFrom16(a), nil
+}
+
+// 10 進数の数字のみの文字列かを返す。
+func isDecimal(s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || '9' < c {
+			return false
+		}
+	}
+
+	return true
+}
+
+// GeoLite2 Country の CSV ファイルを読んで IP アドレスのプレフィックスと
+// カントリーコードのデータを取得し、一時保存用データベースに格納する。
+// blocksFile は GeoLite2-Country-Blocks-IPv4.csv または
+// GeoLite2-Country-Blocks-IPv6.csv で、 network と geoname_id などの列がある。
+// locationsFile は GeoLite2-Country-Locations-en.csv などで、
+// geoname_id と country_iso_code の列がある。
+// 各ネットワークのカントリーコードは geoname_id の country_iso_code 、
+// ない場合は registered_country_geoname_id の country_iso_code とし、
+// どちらもない場合は読み込まない。
+// registry などのその他の情報は空とする。
+func (db *DB) LoadIPBDataByGeoLite2CSV(blocksFile, locationsFile string) error {
+	locations, err := readGeoLite2Locations(locationsFile)
+	if err != nil {
+		return err
+	}
+
+	fp, err := os.Open(blocksFile)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	reader := newImportCSVReader(fp)
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %w", blocksFile, ErrMalformedRecord, err)
+	}
+	cols, err := columnIndexes(header, "network", "geoname_id", "registered_country_geoname_id")
+	if err != nil {
+		return fmt.Errorf("%s: %w", blocksFile, err)
+	}
+
+	db.tmpIB.l.Lock()
+	defer db.tmpIB.l.Unlock()
+
+	imp := db.newCSVImport(reader, blocksFile)
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if err := imp.skip(line, newParseError(-1, ErrMalformedRecord, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		if pe := imp.setNetwork(line, cols, locations); pe != nil {
+			if err := imp.skip(line, pe); err != nil {
+				return err
+			}
+		}
+	}
+	db.tmpIB.addSource(imp.header)
+
+	return nil
+}
+
+// GeoLite2 Country の blocks ファイルの１行を一時保存用データベースに格納する。
+// cols は network 、 geoname_id 、 registered_country_geoname_id の列の位置。
+func (imp *csvImport) setNetwork(line []string, cols []int, locations map[string]string) *ParseError {
+	for _, c := range cols {
+		if c >= len(line) {
+			return newParseError(-1, ErrWrongNumberOfFields, fmt.Errorf("%d fields", len(line)))
+		}
+	}
+	p, err := netip.ParsePrefix(line[cols[0]])
+	if err != nil {
+		return newParseError(cols[0], ErrInvalidIPAddress, err)
+	}
+	if p != p.Masked() {
+		return newParseError(cols[0], ErrInvalidIPAddress, errors.New("network is not the first address of the prefix"))
+	}
+	cc := locations[line[cols[1]]]
+	if cc == "" {
+		cc = locations[line[cols[2]]]
+	}
+	if cc == "" {
+		return nil
+	}
+	start, end := PrefixToRange(p)
+
+	return imp.setBlock(cc, cols[1], start, end)
+}
+
+// GeoLite2 Country の locations ファイルを読み、
+// geoname_id から country_iso_code を引くマップを返す。
+// country_iso_code が空の geoname_id （大陸など）は含めない。
+func readGeoLite2Locations(locationsFile string) (map[string]string, error) {
+	fp, err := os.Open(locationsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	reader := newImportCSVReader(fp)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %w", locationsFile, ErrMalformedRecord, err)
+	}
+	cols, err := columnIndexes(header, "geoname_id", "country_iso_code")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", locationsFile, err)
+	}
+
+	locations := map[string]string{}
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && (cols[0] >= len(line) || cols[1] >= len(line)) {
+			err = newParseError(-1, ErrWrongNumberOfFields, fmt.Errorf("%d fields", len(line)))
+		}
+		if err != nil {
+			pe, ok := err.(*ParseError)
+			if !ok {
+				pe = newParseError(-1, ErrMalformedRecord, err)
+			}
+			pe.Source = locationsFile
+			pe.Line = recordLine(reader, line, err)
+			pe.Record = line
+			return nil, pe
+		}
+		if cc := line[cols[1]]; cc != "" {
+			locations[line[cols[0]]] = cc
+		}
+	}
+
+	return locations, nil
+}
+
+// header の各列名の位置を返す。
+// 列名がない場合は ErrMalformedRecord を返す。
+func columnIndexes(header []string, names ...string) ([]int, error) {
+	cols := make([]int, len(names))
+	for i, name := range names {
+		cols[i] = -1
+		for j, h := range header {
+			// UTF-8 の BOM が付いている場合がある。
+			if strings.TrimPrefix(strings.TrimSpace(h), "\ufeff") == name {
+				cols[i] = j
+				break
+			}
+		}
+		if cols[i] < 0 {
+			return nil, fmt.Errorf("%w: missing column %q", ErrMalformedRecord, name)
+		}
+	}
+
+	return cols, nil
+}
+
+// カンマ区切りの CSV ファイルを読む csv.Reader を返す。
+func newImportCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = false
+
+	return reader
+}
+
+// CSV ファイルから一時保存用データベースへの読込の状態
+// 一時保存用データベースのロックは呼出元で行う。
+type csvImport struct {
+	db      *DB
+	reader  *csv.Reader
+	header  SourceHeader
+	invalid int
+}
+
+func (db *DB) newCSVImport(reader *csv.Reader, source string) *csvImport {
+	return &csvImport{db: db, reader: reader, header: newSourceHeader(source)}
+}
+
+// 異常のある行の読込元と行番号を設定し、 skipRecord で処理する。
+func (imp *csvImport) skip(line []string, pe *ParseError) error {
+	pe.Source = imp.header.Source
+	pe.Line = recordLine(imp.reader, line, pe.Err)
+	pe.Record = line
+
+	return imp.db.skipRecord(pe, &imp.invalid)
+}
+
+// 範囲 [start, end] を、カントリーコードが cc の割当ブロックとして格納する。
+// 割当ブロックは RIR statistics exchange format の record と同じ方法で格納する。
+// ccField はカントリーコードを決めた Field の位置で、異常の場合の ParseError に設定する。
+func (imp *csvImport) setBlock(cc string, ccField int, start, end netip.Addr) (pe *ParseError) {
+	defer func() {
+		if pe != nil {
+			pe.Field = ccField
+		}
+	}()
+
+	if start.Is4() {
+		// value は uint32 で格納するので、 2^31 個ごとに分けて格納する。
+		e := uint64(addrToUint32(end))
+		for s := uint64(addrToUint32(start)); s <= e; {
+			count := min(e-s+1, 1<<31)
+			imp.header.Counts["ipv4"]++
+			if pe := imp.db.setTmpIPBlock([]string{"", cc, "ipv4", uint32ToAddr(uint32(s)).String(), strconv.FormatUint(count, 10), "", ""}); pe != nil {
+				return pe
+			}
+			s += count
+		}
+		return nil
+	}
+	for _, p := range RangeToPrefixes(start, end) {
+		imp.header.Counts["ipv6"]++
+		if pe := imp.db.setTmpIPBlock([]string{"", cc, "ipv6", p.Addr().String(), strconv.Itoa(p.Bits()), "", ""}); pe != nil {
+			return pe
+		}
+	}
+
+	return nil
+}
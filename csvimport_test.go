@@ -0,0 +1,283 @@
+package ccipv4
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// テスト用のファイルを作成し、パスを返す。
+func writeTestFile(t *testing.T, name string, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadIPBDataByRangeCSV(t *testing.T) {
+	path := writeTestFile(t, "IP2LOCATION-LITE-DB1.CSV",
+		`"ip_from","ip_to","country_code","country_name"`,
+		`"0","16777215","-","-"`,
+		// 1.0.0.0-1.0.0.255
+		`"16777216","16777471","US","United States of America"`,
+		// 1.0.1.0-1.0.3.255 （プレフィックスに揃っていない範囲）
+		`"16777472","16778239","CN","China"`,
+		// 1.0.4.0-1.0.7.255
+		`"16778240","16779263","AU","Australia"`,
+		// ::ffff:114.48.0.0-::ffff:114.51.255.255
+		`"281472597491712","281472597753855","JP","Japan"`,
+		// 2001:df2:6180::-2001:df2:6181:ffff:ffff:ffff:ffff:ffff
+		`"42540771036690807141816709577869099008","42540771036693224993455938836218511359","HK","Hong Kong"`,
+	)
+
+	db := GetDB()
+	if err := db.LoadIPBDataByRangeCSV(path); err != nil {
+		t.Fatalf("LoadIPBDataByRangeCSV: but error: %v", err)
+	}
+	db.SwitchIPBData()
+
+	for _, tc := range []struct {
+		addr  string
+		code  string
+		start string
+		end   string
+	}{
+		{addr: "1.0.8.1"},
+		{addr: "1.0.0.1", code: "US", start: "1.0.0.0", end: "1.0.0.255"},
+		{addr: "1.0.3.255", code: "CN", start: "1.0.1.0", end: "1.0.3.255"},
+		{addr: "1.0.4.0", code: "AU", start: "1.0.4.0", end: "1.0.7.255"},
+		{addr: "114.51.255.255", code: "JP", start: "114.48.0.0", end: "114.51.255.255"},
+		{addr: "2001:df2:6181::1", code: "HK", start: "2001:df2:6180::", end: "2001:df2:6181:ffff:ffff:ffff:ffff:ffff"},
+	} {
+		r := db.SearchInfo(tc.addr)
+		if tc.code == "" {
+			if r.Status != StatusNotFound {
+				t.Errorf("SearchInfo: %s, want not found, but %v", tc.addr, r)
+			}
+			continue
+		}
+		if r.Status != StatusFound || r.Code != tc.code || r.Registry != "" {
+			t.Errorf("SearchInfo: %s, want %s, but %v", tc.addr, tc.code, r)
+		}
+		if strings.Contains(tc.addr, ".") && (r.BlockStart != tc.start || r.BlockEnd != tc.end) {
+			t.Errorf("SearchInfo: %s, want %s-%s, but %s-%s", tc.addr, tc.start, tc.end, r.BlockStart, r.BlockEnd)
+		}
+	}
+	if tb := db.GetTotalBlocks(); tb["ALL"] != 4 {
+		t.Errorf("GetTotalBlocks: want ALL 4, but %v", tb)
+	}
+	// IPv6 の範囲は /48 のプレフィックス２つに分割する。
+	if hs := db.GetSourceHeaders(); len(hs) != 1 || hs[0].Source != path || hs[0].Counts["ipv4"] != 4 || hs[0].Counts["ipv6"] != 1 {
+		t.Errorf("GetSourceHeaders: invalid headers: %v", hs)
+	}
+}
+
+func TestLoadIPBDataByRangeCSVIPv6(t *testing.T) {
+	// IP2Location LITE DB1 の IPv6 版の先頭の行
+	path := writeTestFile(t, "IP2LOCATION-LITE-DB1.IPV6.CSV",
+		`"0","281470681743359","-","-"`,
+		`"281470681743360","281470698520575","-","-"`,
+		`"281470698520576","281470698520831","US","United States of America"`,
+		`"281470698520832","281470698521599","CN","China"`,
+		// 2001:200::/32
+		`"42540528726795050063891204319802818560","42540528806023212578155541913346768895","JP","Japan"`,
+	)
+
+	db := GetDB()
+	if err := db.LoadIPBDataByRangeCSV(path); err != nil {
+		t.Fatalf("LoadIPBDataByRangeCSV: but error: %v", err)
+	}
+	db.SwitchIPBData()
+
+	for _, tc := range []struct {
+		addr string
+		code string
+	}{
+		{addr: "1.0.0.1", code: "US"},
+		{addr: "1.0.3.255", code: "CN"},
+		{addr: "1.0.4.0"},
+		{addr: "2001:200:ffff::1", code: "JP"},
+		{addr: "2001:201::1"},
+	} {
+		r := db.SearchInfo(tc.addr)
+		if tc.code == "" {
+			if r.Status != StatusNotFound {
+				t.Errorf("SearchInfo: %s, want not found, but %v", tc.addr, r)
+			}
+			continue
+		}
+		if r.Status != StatusFound || r.Code != tc.code {
+			t.Errorf("SearchInfo: %s, want %s, but %v", tc.addr, tc.code, r)
+		}
+	}
+	if hs := db.GetSourceHeaders(); len(hs) != 1 || hs[0].Counts["ipv4"] != 2 || hs[0].Counts["ipv6"] != 1 {
+		t.Errorf("GetSourceHeaders: invalid headers: %v", hs)
+	}
+}
+
+func TestLoadIPBDataByRangeCSVWhole(t *testing.T) {
+	// IPv4 の全体は uint32 で表せる個数ごとに分けて格納する。
+	path := writeTestFile(t, "whole.csv", "0,4294967295,ZZ,Unknown")
+	db := GetDB()
+	if err := db.LoadIPBDataByRangeCSV(path); err != nil {
+		t.Fatalf("LoadIPBDataByRangeCSV: but error: %v", err)
+	}
+	db.SwitchIPBData()
+	for _, addr := range []string{"1.0.0.0", "126.255.255.255", "128.0.0.0", "223.255.255.255"} {
+		if r := db.SearchInfo(addr); r.Status != StatusFound || r.Code != "ZZ" {
+			t.Errorf("SearchInfo: %s, want ZZ, but %v", addr, r)
+		}
+	}
+	if tb := db.GetTotalBlocks(); tb["ALL"] != 2 {
+		t.Errorf("GetTotalBlocks: want ALL 2, but %v", tb)
+	}
+}
+
+func TestLoadIPBDataByRangeCSVError(t *testing.T) {
+	for _, tc := range []struct {
+		line  string
+		field int
+		kind  error
+	}{
+		{line: "16777216,16777471", field: -1, kind: ErrWrongNumberOfFields},
+		{line: "16777216,x,US", field: 1, kind: ErrInvalidIPAddress},
+		{line: "-1,16777471,US", field: 0, kind: ErrInvalidIPAddress},
+		{line: "340282366920938463463374607431768211456,1,US", field: 0, kind: ErrInvalidIPAddress},
+		{line: "16777471,16777216,US", field: 1, kind: ErrInvalidValue},
+		{line: "42540771036690807141816709577869099008,16777216,US", field: 1, kind: ErrInvalidValue},
+		{line: "281474976710655,42540528726795050063891204319802884095,US", field: 1, kind: ErrInvalidValue},
+		{line: `16777216,"16777471,US`, field: -1, kind: ErrMalformedRecord},
+	} {
+		path := writeTestFile(t, "error.csv", "0,16777215,AU,Australia", tc.line)
+		db := GetDB()
+		err := db.LoadIPBDataByRangeCSV(path)
+		var pe *ParseError
+		if !errors.As(err, &pe) || !errors.Is(err, tc.kind) {
+			t.Errorf("LoadIPBDataByRangeCSV: %s, want error %v, but %v", tc.line, tc.kind, err)
+			continue
+		}
+		if pe.Source != path || pe.Line != 2 || pe.Field != tc.field {
+			t.Errorf("LoadIPBDataByRangeCSV: %s, want line 2 field %d, but %v (field %d)", tc.line, tc.field, pe, pe.Field)
+		}
+		if len(db.tmpIB.data) != 0 {
+			t.Errorf("LoadIPBDataByRangeCSV: %s, tmpIB.data length want 0, but %d", tc.line, len(db.tmpIB.data))
+		}
+	}
+
+	// lenient mode では読み飛ばす。
+	path := writeTestFile(t, "lenient.csv", "0,16777215,AU,Australia", "16777471,16777216,US", "16777472,16778239,CN,China")
+	db := NewDB(WithLenientParsing(-1))
+	if err := db.LoadIPBDataByRangeCSV(path); err != nil {
+		t.Fatalf("LoadIPBDataByRangeCSV: lenient, but error: %v", err)
+	}
+	if ds := db.tmpIB.diagnostics; len(ds) != 1 || ds[0].Line != 2 || ds[0].Raw != "16777471|16777216|US" || !errors.Is(ds[0].Err, ErrInvalidValue) {
+		t.Errorf("LoadIPBDataByRangeCSV: invalid diagnostics: %v", ds)
+	}
+	if len(db.tmpIB.data) != 2 {
+		t.Errorf("LoadIPBDataByRangeCSV: lenient, tmpIB.data length want 2, but %d", len(db.tmpIB.data))
+	}
+
+	if err := GetDB().LoadIPBDataByRangeCSV(filepath.Join(t.TempDir(), "none.csv")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadIPBDataByRangeCSV: want error %v, but %v", os.ErrNotExist, err)
+	}
+}
+
+func TestLoadIPBDataByGeoLite2CSV(t *testing.T) {
+	dir := t.TempDir()
+	locations := filepath.Join(dir, "GeoLite2-Country-Locations-en.csv")
+	if err := os.WriteFile(locations, []byte(strings.Join([]string{
+		"\ufeffgeoname_id,locale_code,continent_code,continent_name,country_iso_code,country_name,is_in_european_union",
+		"1861060,en,AS,Asia,JP,Japan,0",
+		"1819730,en,AS,Asia,HK,\"Hong Kong\",0",
+		"6252001,en,NA,\"North America\",US,\"United States\",0",
+		"6255147,en,AS,Asia,,,0",
+	}, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	blocks4 := writeTestFile(t, "GeoLite2-Country-Blocks-IPv4.csv",
+		"network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,is_anonymous_proxy,is_satellite_provider,is_anycast",
+		"1.0.0.0/24,6252001,6252001,,0,0,",
+		"114.48.0.0/14,1861060,1861060,,0,0,",
+		// geoname_id がない場合は registered_country_geoname_id を使う。
+		"114.52.0.0/16,,1861060,,0,0,",
+		// どちらもない場合や、カントリーコードがない場合は読み込まない。
+		"114.53.0.0/16,,,,1,0,",
+		"114.54.0.0/16,6255147,6255147,,0,0,",
+	)
+	blocks6 := writeTestFile(t, "GeoLite2-Country-Blocks-IPv6.csv",
+		"network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,is_anonymous_proxy,is_satellite_provider,is_anycast",
+		"2001:df2:6180::/48,1819730,1819730,,0,0,",
+	)
+
+	db := GetDB()
+	if err := db.LoadIPBDataByGeoLite2CSV(blocks4, locations); err != nil {
+		t.Fatalf("LoadIPBDataByGeoLite2CSV: IPv4, but error: %v", err)
+	}
+	if err := db.LoadIPBDataByGeoLite2CSV(blocks6, locations); err != nil {
+		t.Fatalf("LoadIPBDataByGeoLite2CSV: IPv6, but error: %v", err)
+	}
+	db.SwitchIPBData()
+
+	for _, tc := range []struct {
+		addr string
+		code string
+	}{
+		{addr: "1.0.0.1", code: "US"},
+		{addr: "114.51.255.255", code: "JP"},
+		{addr: "114.52.0.1", code: "JP"},
+		{addr: "114.53.0.1"},
+		{addr: "114.54.0.1"},
+		{addr: "2001:df2:6180::1", code: "HK"},
+	} {
+		r := db.SearchInfo(tc.addr)
+		if tc.code == "" {
+			if r.Status != StatusNotFound {
+				t.Errorf("SearchInfo: %s, want not found, but %v", tc.addr, r)
+			}
+			continue
+		}
+		if r.Status != StatusFound || r.Code != tc.code {
+			t.Errorf("SearchInfo: %s, want %s, but %v", tc.addr, tc.code, r)
+		}
+	}
+	if hs := db.GetSourceHeaders(); len(hs) != 2 || hs[0].Counts["ipv4"] != 3 || hs[1].Counts["ipv6"] != 1 {
+		t.Errorf("GetSourceHeaders: invalid headers: %v", hs)
+	}
+}
+
+func TestLoadIPBDataByGeoLite2CSVError(t *testing.T) {
+	locations := writeTestFile(t, "locations.csv", "geoname_id,country_iso_code", "1861060,JP")
+	header := "network,geoname_id,registered_country_geoname_id"
+
+	for _, tc := range []struct {
+		name      string
+		blocks    []string
+		locations string
+		kind      error
+	}{
+		{name: "missing column", blocks: []string{"network,geoname_id", "1.0.0.0/24,1861060"}, kind: ErrMalformedRecord},
+		{name: "invalid network", blocks: []string{header, "1.0.0.256/24,1861060,1861060"}, kind: ErrInvalidIPAddress},
+		{name: "not masked", blocks: []string{header, "1.0.0.1/24,1861060,1861060"}, kind: ErrInvalidIPAddress},
+		{name: "fields", blocks: []string{header, "1.0.0.0/24,1861060"}, kind: ErrWrongNumberOfFields},
+		{name: "locations", blocks: []string{header}, locations: writeTestFile(t, "bad.csv", "geoname_id,country_name", "1861060,Japan"), kind: ErrMalformedRecord},
+		{name: "no locations", blocks: []string{header}, locations: filepath.Join(t.TempDir(), "none.csv"), kind: os.ErrNotExist},
+	} {
+		blocks := writeTestFile(t, "blocks.csv", tc.blocks...)
+		loc := locations
+		if tc.locations != "" {
+			loc = tc.locations
+		}
+		db := GetDB()
+		if err := db.LoadIPBDataByGeoLite2CSV(blocks, loc); !errors.Is(err, tc.kind) {
+			t.Errorf("LoadIPBDataByGeoLite2CSV: %s, want error %v, but %v", tc.name, tc.kind, err)
+		}
+		if len(db.tmpIB.data) != 0 {
+			t.Errorf("LoadIPBDataByGeoLite2CSV: %s, tmpIB.data length want 0, but %d", tc.name, len(db.tmpIB.data))
+		}
+	}
+}
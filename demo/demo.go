@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/netip"
@@ -424,7 +425,7 @@ func (c *cli) loadIPBD() {
 // 検索
 func (c *cli) searchIPB() {
 	var s string
-	fmt.Fprintln(c.stdout, "                 \x1b[47m \x1b[30mIPv4アドレス入力＋Enter                   \x1b[0m")
+	fmt.Fprintln(c.stdout, "                 \x1b[47m \x1b[30mIPアドレス入力＋Enter                     \x1b[0m")
 	fmt.Fprint(c.stdout, "                 \x1b[47m \x1b[30m>>> \x1b[0m ")
 	if _, err := fmt.Fscanln(c.stdin, &s); err != nil {
 		s = ""
@@ -434,24 +435,32 @@ func (c *cli) searchIPB() {
 	fmt.Fprintln(c.stdout, "")
 	if res.IsFound {
 		fmt.Fprintf(c.stdout, "                 ブロック : %s 〜 %s\n", res.BlockStart, res.BlockEnd)
+		for i, p := range res.Prefixes {
+			if i == 0 {
+				fmt.Fprintf(c.stdout, "                 CIDR     : %s\n", p)
+			} else {
+				fmt.Fprintf(c.stdout, "                            %s\n", p)
+			}
+		}
 		fmt.Fprintf(c.stdout, "                 コード   : %s\n", res.Code)
 		if res.Name != "" {
 			fmt.Fprintf(c.stdout, "                 名称     : %s\n", res.Name)
 			fmt.Fprintf(c.stdout, "                            %s\n", res.AltName)
 		}
 	} else {
-		switch res.Message {
-		case "Invalid IP Address":
+		switch res.Status {
+		case ccipv4.StatusInvalidAddress:
 			fmt.Fprintln(c.stdout, "                 IPアドレスではありません。")
-		case "Not IPv4 Address":
-			fmt.Fprintln(c.stdout, "                 IPv4アドレスではありません。")
-		case "Loopback Address":
+		case ccipv4.StatusLoopback:
 			fmt.Fprintln(c.stdout, "                 ループバックアドレスです。")
-		case "Multicast Address":
+		case ccipv4.StatusMulticast:
 			fmt.Fprintln(c.stdout, "                 マルチキャストアドレスです。")
-		case "Private Address":
+		case ccipv4.StatusPrivate:
 			fmt.Fprintln(c.stdout, "                 プライベートアドレスです。")
-		case "Not Found":
+		case ccipv4.StatusReserved:
+			fmt.Fprintln(c.stdout, "                 特別な用途のアドレスです。")
+			fmt.Fprintf(c.stdout, "                 %s（%s）\n", res.SpecialPurpose.Name, res.SpecialPurpose.RFC)
+		case ccipv4.StatusNotFound:
 			fmt.Fprintln(c.stdout, "                 該当するブロックはありませんでした。")
 		}
 	}
@@ -512,10 +521,10 @@ func (c *cli) getLastAddr() {
 		fmt.Fprintln(c.stdout, "                 "+addr.String())
 	} else {
 		var e string
-		switch err.Error() {
-		case ccipv4.ErrorMessageFirstArgumentOutOfRange:
+		switch {
+		case errors.Is(err, ccipv4.ErrFirstArgumentOutOfRange):
 			e = MSG_NOT_IPV4
-		case ccipv4.ErrorMessageSecondArgumentOutOfRange:
+		case errors.Is(err, ccipv4.ErrSecondArgumentOutOfRange):
 			e = MSG_VALUE_OUT_OF_RANGE
 		default:
 			e = MSG_UNEXPECTED_ERROR
@@ -574,11 +583,11 @@ func (c *cli) getValue() {
 		fmt.Fprintf(c.stdout, "                 %d\n", v)
 	} else {
 		var e string
-		switch err.Error() {
+		switch {
 
-		case ccipv4.ErrorMessageFirstArgumentOutOfRange:
+		case errors.Is(err, ccipv4.ErrFirstArgumentOutOfRange):
 			e = "先頭が" + MSG_NOT_IPV4
-		case ccipv4.ErrorMessageSecondArgumentOutOfRange:
+		case errors.Is(err, ccipv4.ErrSecondArgumentOutOfRange):
 			e = "最後が" + MSG_NOT_IPV4
 
 		default:
@@ -43,10 +43,20 @@ func TestLoadIPBD(t *testing.T) {
 		got bytes.Buffer
 	)
 
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"/afrinic",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, "afrinic|ZA|ipv4|41.0.0.0|2097152|20071126|allocated")
+		},
+	)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
 	c := &cli{
 		stdout: &got,
 		stderr: &got,
-		db:     ccipv4.GetDB(),
+		db:     ccipv4.NewDB(ccipv4.WithSourceURLs(ts.URL + "/afrinic")),
 	}
 
 	c.loadIPBD()
@@ -107,6 +117,9 @@ func TestSearchIPB(t *testing.T) {
 	if !strings.Contains(got.String(), "コード   : ZA\n") {
 		t.Errorf("searchIPB: invalid message: %s", got.String())
 	}
+	if !strings.Contains(got.String(), "CIDR     : 41.0.0.0/11\n") {
+		t.Errorf("searchIPB: invalid message: %s", got.String())
+	}
 }
 
 func TestGetInfo(t *testing.T) {
@@ -0,0 +1,83 @@
+package ccipv4
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// lenient mode で読み飛ばした record の診断情報
+type Diagnostic struct {
+	// 読み込んだ URL またはファイルのパス
+	Source string
+	// record の行番号。不明な場合は 0 。
+	Line int
+	// record の各 Field を "|" で連結したもの
+	Raw string
+	// 読み飛ばした理由
+	Reason string
+	// 読み飛ばした理由のエラー。 errors.Is で ErrInvalidIPAddress などの種類を判定できる。
+	Err error
+}
+
+// 異常のある record を読み飛ばし、診断情報を記録する lenient mode を設定する。
+// 一つの URL またはファイルで読み飛ばした record の件数が maxInvalid を
+// 超えた場合は、読込を中止してエラーを返す。 maxInvalid が負の場合は上限なし。
+// 既定では最初の異常で読込を中止する。
+func WithLenientParsing(maxInvalid int) Option {
+	return func(db *DB) {
+		db.lenient = true
+		db.maxInvalid = maxInvalid
+	}
+}
+
+// 検索用データベースの作成時に読み飛ばした record の診断情報を取得する。
+func (db *DB) GetDiagnostics() []Diagnostic {
+	db.ib.l.RLock()
+	defer db.ib.l.RUnlock()
+
+	return db.ib.diagnostics
+}
+
+// record の行番号を取得する。
+// 読込時の異常の場合は csv.ParseError の行番号とする。
+func recordLine(reader *csv.Reader, line []string, readErr error) int {
+	var pe *csv.ParseError
+	if errors.As(readErr, &pe) {
+		return pe.StartLine
+	}
+	if len(line) == 0 {
+		return 0
+	}
+	n, _ := reader.FieldPos(0)
+
+	return n
+}
+
+// 異常のある record を処理する。
+// lenient mode でない場合や、異常のある record の件数 invalid が上限を超えた場合、
+// 読込を続けられない異常の場合は、一時保存用データベースを空にしてエラーを返す。
+// それ以外の場合は診断情報を記録し、 nil を返す。
+// 一時保存用データベースのロックは呼出元で行う。
+func (db *DB) skipRecord(pe *ParseError, invalid *int) error {
+	// カントリーコードの種類の上限は lenient mode でも読込を中止する。
+	if !db.lenient || errors.Is(pe, ErrTooManyCountryCodes) {
+		db.ClearTmpIPBData()
+		return pe
+	}
+	*invalid++
+	if db.maxInvalid >= 0 && *invalid > db.maxInvalid {
+		db.ClearTmpIPBData()
+		return fmt.Errorf("%w (more than %d): %w", ErrTooManyInvalidRecords, db.maxInvalid, pe)
+	}
+	db.tmpIB.diagnostics = append(db.tmpIB.diagnostics, Diagnostic{
+		Source: pe.Source,
+		Line:   pe.Line,
+		Raw:    strings.Join(pe.Record, "|"),
+		Reason: pe.Err.Error(),
+		Err:    pe.Err,
+	})
+
+	return nil
+}
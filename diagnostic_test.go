@@ -0,0 +1,93 @@
+package ccipv4
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLenientParsing(t *testing.T) {
+	// 上限なし。異常のある record を読み飛ばし、残りを読み込む。
+	db := NewDB(WithLenientParsing(-1))
+	if err := db.LoadIPBDataByFile("testdata/variousDataIPBlockFile"); err != nil {
+		t.Fatalf("LoadIPBDataByFile: lenient, but error: %v", err)
+	}
+	if len(db.tmpIB.data) != 5 {
+		t.Errorf("LoadIPBDataByFile: tmpIB.data length want 5, but %d", len(db.tmpIB.data))
+	}
+	if len(db.tmpIB.asn) != 3 || len(db.tmpIB.data6) != 5 {
+		t.Errorf("LoadIPBDataByFile: tmpIB.asn length %d, tmpIB.data6 length %d", len(db.tmpIB.asn), len(db.tmpIB.data6))
+	}
+	ds := db.tmpIB.diagnostics
+	if len(ds) != 2 {
+		t.Fatalf("LoadIPBDataByFile: diagnostics length want 2, but %d: %v", len(ds), ds)
+	}
+	for i, want := range []struct {
+		line int
+		raw  string
+		kind error
+	}{
+		{line: 19, raw: "apnic|JP|ipv4|114.48.0.256|262144|20080422|allocated", kind: ErrInvalidIPAddress},
+		{line: 22, raw: "apnic|JP|ipv4|136.198.0.0|65536|19890925", kind: ErrWrongNumberOfFields},
+	} {
+		d := ds[i]
+		if d.Source != "testdata/variousDataIPBlockFile" || d.Line != want.line || d.Raw != want.raw {
+			t.Errorf("LoadIPBDataByFile: diagnostics[%d] is invalid: %v", i, d)
+		}
+		if !errors.Is(d.Err, want.kind) || d.Reason != d.Err.Error() {
+			t.Errorf("LoadIPBDataByFile: diagnostics[%d] want %v, but %v (%s)", i, want.kind, d.Err, d.Reason)
+		}
+	}
+
+	// 検索用データベースに切り替えると取得できる。
+	db.SwitchIPBData()
+	if len(db.tmpIB.diagnostics) != 0 {
+		t.Errorf("SwitchIPBData: tmpIB.diagnostics length want 0, but %d", len(db.tmpIB.diagnostics))
+	}
+	if len(db.GetDiagnostics()) != 2 {
+		t.Errorf("GetDiagnostics: length want 2, but %d", len(db.GetDiagnostics()))
+	}
+	if sr := db.SearchInfo("114.48.0.1"); sr.Code != "JP" {
+		t.Errorf("SearchInfo: lenient, but invalid result: %v", sr)
+	}
+
+	// 上限以内
+	db = NewDB(WithLenientParsing(2))
+	if err := db.LoadIPBDataByFile("testdata/variousDataIPBlockFile"); err != nil {
+		t.Errorf("LoadIPBDataByFile: within threshold, but error: %v", err)
+	}
+
+	// 上限を超えた場合は読み込まない。
+	db = NewDB(WithLenientParsing(1))
+	err := db.LoadIPBDataByFile("testdata/variousDataIPBlockFile")
+	if err == nil {
+		t.Error("LoadIPBDataByFile: over threshold, but no error")
+	} else if !errors.Is(err, ErrTooManyInvalidRecords) || !errors.Is(err, ErrWrongNumberOfFields) {
+		t.Errorf("LoadIPBDataByFile: over threshold, invalid error: %v", err)
+	}
+	if len(db.tmpIB.data) != 0 || len(db.tmpIB.diagnostics) != 0 {
+		t.Errorf("LoadIPBDataByFile: over threshold, but tmpIB is not cleared: %v, %v", db.tmpIB.data, db.tmpIB.diagnostics)
+	}
+
+	// 上限 0 は lenient mode でない場合と同じく最初の異常で中止する。
+	db = NewDB(WithLenientParsing(0))
+	if err := db.LoadIPBDataByFile("testdata/variousDataIPBlockFile"); err == nil {
+		t.Error("LoadIPBDataByFile: threshold 0, but no error")
+	}
+
+	// CSV として読み込めない行も読み飛ばす。
+	db = NewDB(WithLenientParsing(-1))
+	in := "apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated\napnic|\"JP|ipv4|1.0.0.0|256|20080422|allocated\n"
+	if err := db.setTmpIPBlocks(strings.NewReader(in), "bare quote"); err != nil {
+		t.Fatalf("setTmpIPBlocks: lenient, but error: %v", err)
+	}
+	if len(db.tmpIB.data) != 1 || len(db.tmpIB.diagnostics) != 1 {
+		t.Errorf("setTmpIPBlocks: tmpIB.data length %d, diagnostics %v", len(db.tmpIB.data), db.tmpIB.diagnostics)
+	} else if db.tmpIB.diagnostics[0].Line != 2 {
+		t.Errorf("setTmpIPBlocks: diagnostics[0].Line want 2, but %d", db.tmpIB.diagnostics[0].Line)
+	}
+	db.ClearTmpIPBData()
+	if db.tmpIB.diagnostics != nil {
+		t.Errorf("ClearTmpIPBData: tmpIB.diagnostics is not cleared: %v", db.tmpIB.diagnostics)
+	}
+}
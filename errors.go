@@ -0,0 +1,94 @@
+package ccipv4
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/suka-test/ccipv4/internal/mmdb"
+)
+
+// errors.Is でエラーの種類を判定するためのエラー
+var (
+	// record を CSV として読み込めない。
+	ErrMalformedRecord = errors.New("malformed record")
+	// record の Field の個数が不正。
+	ErrWrongNumberOfFields = errors.New("the number of the line's fields is invalid")
+	// ipv4 の start が不正。
+	ErrInvalidIPAddress = errors.New("invalid ipv4 address")
+	// ipv6 の start と value から作成したプレフィックスが不正。
+	ErrInvalidIPv6Prefix = errors.New("invalid ipv6 prefix")
+	// asn の start が不正。
+	ErrInvalidASN = errors.New("invalid asn")
+	// value が不正。
+	ErrInvalidValue = errors.New("invalid value")
+	// カントリーコードの一覧ファイルのカントリーコードが不正。
+	ErrInvalidCountryCode = errors.New("invalid country code")
+	// カントリーコードの種類が上限を超えた。
+	ErrTooManyCountryCodes = errors.New("too many country codes")
+	// lenient mode で読み飛ばした record の件数が上限を超えた。
+	ErrTooManyInvalidRecords = errors.New("too many invalid records")
+	// MaxMind DB 形式のファイルが不正。
+	ErrInvalidMMDB = mmdb.ErrInvalidDatabase
+	// 関数の引数が範囲外。
+	// どの引数が範囲外かは ErrFirstArgumentOutOfRange などで判定する。
+	ErrArgumentOutOfRange = errors.New("argument out of range")
+	// 関数の最初の引数が範囲外。
+	ErrFirstArgumentOutOfRange = fmt.Errorf("first %w", ErrArgumentOutOfRange)
+	// 関数の２番めの引数が範囲外。
+	ErrSecondArgumentOutOfRange = fmt.Errorf("second %w", ErrArgumentOutOfRange)
+)
+
+// 読み込んだデータの record に異常がある場合のエラー
+type ParseError struct {
+	// 読み込んだ URL またはファイルのパス。不明な場合は空文字列。
+	Source string
+	// record の行番号。不明な場合は 0 。
+	Line int
+	// 異常のある Field の位置（ 0 始まり）。 record 全体の異常の場合は -1 。
+	Field int
+	// record の各 Field
+	Record []string
+	// 異常の内容。 ErrInvalidIPAddress などの種類と、
+	// netip 、 strconv 、 csv パッケージなどの元のエラーをラップする。
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	var s string
+	if e.Source == "" {
+		s = fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	} else {
+		s = fmt.Sprintf("%s:%d: %v", e.Source, e.Line, e.Err)
+	}
+	if len(e.Record) > 0 {
+		s += fmt.Sprintf(": %v", e.Record)
+	}
+
+	return s
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Field の位置 field とエラーの種類 kind 、元のエラー err から *ParseError を作成する。
+// 読込元・行番号・ record は呼出元で設定する。
+func newParseError(field int, kind error, err error) *ParseError {
+	if err != nil {
+		kind = fmt.Errorf("%w: %w", kind, err)
+	}
+
+	return &ParseError{Field: field, Err: kind}
+}
+
+// HTTP のステータスコードが 200 OK でない場合のエラー
+type StatusError struct {
+	URL        string
+	StatusCode int
+	// "404 Not Found" などのステータス
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status: %s: %s", e.Status, e.URL)
+}
@@ -0,0 +1,117 @@
+package ccipv4
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseError(t *testing.T) {
+	for _, tc := range []struct {
+		in    string
+		line  int
+		field int
+		kind  error
+	}{
+		{in: "apnic|JP|ipv4|114.48.0.256|262144|20080422|allocated\n", line: 1, field: 3, kind: ErrInvalidIPAddress},
+		{in: "apnic|JP|ipv4|2001:df2:6180::|262144|20080422|allocated\n", line: 1, field: 3, kind: ErrInvalidIPAddress},
+		{in: "# comment\napnic|JP|ipv4|114.48.0.0|a|20080422|allocated\n", line: 2, field: 4, kind: ErrInvalidValue},
+		{in: "apnic|JP|ipv4|0.0.0.0|0|20080422|allocated\n", line: 1, field: 4, kind: ErrInvalidValue},
+		{in: "apnic|JP|ipv4|114.48.0.0|-256|20080422|allocated\n", line: 1, field: 4, kind: ErrInvalidValue},
+		{in: "apnic|JP|ipv4|255.255.255.0|257|20080422|allocated\n", line: 1, field: 4, kind: ErrInvalidValue},
+		{in: "apnic|JP|ipv4|0.0.0.0|4294967296|20080422|allocated\n", line: 1, field: 4, kind: ErrInvalidValue},
+		{in: "apnic|JP|ipv4|114.48.0.0|262144|20080422\n", line: 1, field: -1, kind: ErrWrongNumberOfFields},
+		{in: "apnic|HK|ipv6|2001:df2:6180::|129|20191216|assigned\n", line: 1, field: 3, kind: ErrInvalidIPv6Prefix},
+		{in: "apnic|JP|asn|a|1|20020801|allocated\n", line: 1, field: 3, kind: ErrInvalidASN},
+		{in: "apnic|JP|asn|173|0|20020801|allocated\n", line: 1, field: 4, kind: ErrInvalidValue},
+		{in: "apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated\napnic|\"JP|ipv4|1.0.0.0|256|20080422|allocated\n", line: 2, field: -1, kind: ErrMalformedRecord},
+	} {
+		db := GetDB()
+		err := db.setTmpIPBlocks(strings.NewReader(tc.in), "source")
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("setTmpIPBlocks: %q, but invalid error: %v", tc.in, err)
+			continue
+		}
+		if !errors.Is(err, tc.kind) {
+			t.Errorf("setTmpIPBlocks: %q, error want %v, but %v", tc.in, tc.kind, err)
+		}
+		if pe.Source != "source" || pe.Line != tc.line || pe.Field != tc.field {
+			t.Errorf("setTmpIPBlocks: %q, invalid ParseError: %s, %d, %d", tc.in, pe.Source, pe.Line, pe.Field)
+		}
+		if !strings.HasPrefix(err.Error(), fmt.Sprintf("source:%d: %v", tc.line, tc.kind)) {
+			t.Errorf("setTmpIPBlocks: %q, invalid error message: %v", tc.in, err)
+		}
+	}
+
+	// 元のエラーをラップする。
+	db := GetDB()
+	err := db.setTmpIPBlocks(strings.NewReader("apnic|JP|ipv4|114.48.0.0|a|20080422|allocated\n"), "")
+	var ne *strconv.NumError
+	if !errors.As(err, &ne) {
+		t.Errorf("setTmpIPBlocks: invalid value, but doesn't wrap *strconv.NumError: %v", err)
+	}
+	var pe *ParseError
+	if !errors.As(err, &pe) || !slices.Equal(pe.Record, []string{"apnic", "JP", "ipv4", "114.48.0.0", "a", "20080422", "allocated"}) {
+		t.Errorf("setTmpIPBlocks: invalid ParseError.Record: %v", err)
+	}
+	err = db.setTmpIPBlocks(strings.NewReader("apnic|\"JP|ipv4|1.0.0.0|256|20080422|allocated\n"), "")
+	var ce *csv.ParseError
+	if !errors.As(err, &ce) {
+		t.Errorf("setTmpIPBlocks: malformed record, but doesn't wrap *csv.ParseError: %v", err)
+	}
+
+	// カントリーコードの一覧ファイル
+	fp, err := os.Open("testdata/variousDataCountryCodeFile")
+	if err != nil {
+		t.Fatalf("SetTmpCountryCodes: can't read testdata/variousDataCountryCodeFile: %v", err)
+	}
+	defer fp.Close()
+	err = db.SetTmpCountryCodes(fp)
+	if !errors.Is(err, ErrInvalidCountryCode) || !errors.As(err, &pe) {
+		t.Errorf("SetTmpCountryCodes: invalid error: %v", err)
+	} else if pe.Line != 10 || pe.Field != 0 || pe.Source != "" {
+		t.Errorf("SetTmpCountryCodes: invalid ParseError: %s, %d, %d", pe.Source, pe.Line, pe.Field)
+	} else if !strings.HasPrefix(err.Error(), "line 10: invalid country code: A1") {
+		t.Errorf("SetTmpCountryCodes: invalid error message: %v", err)
+	}
+}
+
+func TestArgumentOutOfRange(t *testing.T) {
+	// 以前のエラーメッセージと同じ
+	if ErrFirstArgumentOutOfRange.Error() != ErrorMessageFirstArgumentOutOfRange {
+		t.Errorf("ErrFirstArgumentOutOfRange: invalid message: %v", ErrFirstArgumentOutOfRange)
+	}
+	if ErrSecondArgumentOutOfRange.Error() != ErrorMessageSecondArgumentOutOfRange {
+		t.Errorf("ErrSecondArgumentOutOfRange: invalid message: %v", ErrSecondArgumentOutOfRange)
+	}
+	_, err := GetValue("::", "0.0.0.0")
+	if !errors.Is(err, ErrArgumentOutOfRange) || errors.Is(err, ErrSecondArgumentOutOfRange) {
+		t.Errorf("GetValue: invalid error: %v", err)
+	}
+	_, err = GetLastAddr("0.0.0.0", 0)
+	if !errors.Is(err, ErrArgumentOutOfRange) || errors.Is(err, ErrFirstArgumentOutOfRange) {
+		t.Errorf("GetLastAddr: invalid error: %v", err)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	err := NewDB().LoadIPBDataByURL(ts.URL + "/notfound")
+	var se *StatusError
+	if !errors.As(err, &se) {
+		t.Fatalf("LoadIPBDataByURL: not found, but invalid error: %v", err)
+	}
+	if se.URL != ts.URL+"/notfound" || se.StatusCode != http.StatusNotFound || se.Status != "404 Not Found" {
+		t.Errorf("LoadIPBDataByURL: invalid StatusError: %v", *se)
+	}
+}
@@ -0,0 +1,307 @@
+// カントリーコードの選択から、ファイアウォールに読み込むルールファイルを作成する。
+// nftables の set 、 ipset restore 用のファイル、 CIDR の一覧の形式で出力できる。
+package firewall
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/suka-test/ccipv4"
+)
+
+// 出力形式
+type Format int
+
+const (
+	// １行に１つの CIDR を並べた一覧
+	FormatCIDR Format = iota
+	// nft -f で読み込むファイル
+	FormatNftables
+	// ipset restore で読み込むファイル
+	FormatIPSet
+)
+
+// 出力形式の名前を返す。
+func (f Format) String() string {
+	switch f {
+	case FormatCIDR:
+		return "cidr"
+	case FormatNftables:
+		return "nftables"
+	case FormatIPSet:
+		return "ipset"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+// 出力形式の名前から Format を返す。
+func ParseFormat(s string) (Format, error) {
+	for _, f := range []Format{FormatCIDR, FormatNftables, FormatIPSet} {
+		if strings.EqualFold(s, f.String()) {
+			return f, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrUnknownFormat, s)
+}
+
+var (
+	ErrUnknownFormat = errors.New("unknown format")
+	// 選択したカントリーコードの割当ブロックがない。
+	// 空のルールで既存のルールを置き換えないよう、ルールセットを作成しない。
+	ErrEmptySelection = errors.New("no blocks match the selection")
+	ErrInvalidName    = errors.New("invalid name")
+	// プレフィックスの個数が IPSetMaxElem を超える。
+	ErrTooManyElements = errors.New("too many elements")
+)
+
+const (
+	// set 名の既定の接頭辞
+	DefaultName = "ccipv4"
+	// nftables の既定のテーブル名
+	DefaultTable = "filter"
+	// nftables の既定のアドレスファミリー
+	DefaultFamily = "inet"
+	// ipset の set の maxelem 。
+	// ipset restore の create -exist は既存の set と作成オプションが同じ場合のみ成功するので、
+	// 要素の個数によらず同じ値にする。
+	IPSetMaxElem = 1 << 20
+	// 名前の最大の長さ
+	maxNameLen = 24
+)
+
+// ルールセットの作成条件
+type Options struct {
+	// 含めるカントリーコード。空の場合はデータベースの全てのカントリーコード。
+	Include []string
+	// 除くカントリーコード。 Include より優先する。
+	Exclude []string
+	// set 名の接頭辞。 IPv4 は "_v4" 、 IPv6 は "_v6" を付加する。
+	// 空の場合は DefaultName 。
+	Name string
+	// nftables のテーブル名とアドレスファミリー。
+	// 空の場合は DefaultTable と DefaultFamily 。
+	Table  string
+	Family string
+}
+
+// 選択したカントリーコードの割当ブロックを覆うプレフィックスの一覧
+type RuleSet struct {
+	// 選択したカントリーコードのうち、割当ブロックがあるもの（辞書順）
+	Countries []string
+	IPv4      []netip.Prefix
+	IPv6      []netip.Prefix
+	name      string
+	table     string
+	family    string
+}
+
+// 検索用データベースから、 opts で選択したカントリーコードの
+// 割当ブロックを覆う最小の個数のプレフィックスの一覧を作成する。
+// 該当する割当ブロックがない場合は ErrEmptySelection を返す。
+func Build(db *ccipv4.DB, opts Options) (*RuleSet, error) {
+	rs := &RuleSet{
+		name:   withDefault(opts.Name, DefaultName),
+		table:  withDefault(opts.Table, DefaultTable),
+		family: withDefault(opts.Family, DefaultFamily),
+	}
+	for _, s := range []string{rs.name, rs.table, rs.family} {
+		if !isValidName(s) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidName, s)
+		}
+	}
+
+	// 同じ時点の検索用データベースから、全てのカントリーコードのものを取得する。
+	byCountry := db.PrefixesByCountries()
+	all := make([]string, 0, len(byCountry))
+	for cc := range byCountry {
+		all = append(all, cc)
+	}
+	slices.Sort(all)
+	rs.Countries = selectCountries(all, opts.Include, opts.Exclude)
+
+	var ps []netip.Prefix
+	for _, cc := range rs.Countries {
+		ps = append(ps, byCountry[cc]...)
+	}
+	// 隣接する別の国・地域の割当ブロックもまとめる。
+	for _, p := range ccipv4.AggregatePrefixes(ps) {
+		if p.Addr().Is4() {
+			rs.IPv4 = append(rs.IPv4, p)
+		} else {
+			rs.IPv6 = append(rs.IPv6, p)
+		}
+	}
+	if len(rs.IPv4) == 0 && len(rs.IPv6) == 0 {
+		return nil, ErrEmptySelection
+	}
+
+	return rs, nil
+}
+
+// カントリーコードの一覧から include に含まれ、 exclude に含まれないものを返す。
+// 大文字と小文字は区別しない。
+func selectCountries(all, include, exclude []string) []string {
+	in := toSet(include)
+	ex := toSet(exclude)
+
+	var ccs []string
+	for _, cc := range all {
+		if (len(in) == 0 || in[cc]) && !ex[cc] {
+			ccs = append(ccs, cc)
+		}
+	}
+
+	return ccs
+}
+
+// カントリーコードの一覧を大文字にした集合を返す。
+func toSet(ccs []string) map[string]bool {
+	m := map[string]bool{}
+	for _, cc := range ccs {
+		if cc = strings.ToUpper(strings.TrimSpace(cc)); cc != "" {
+			m[cc] = true
+		}
+	}
+
+	return m
+}
+
+// s が空の場合は def を返す。
+func withDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+
+	return s
+}
+
+// set 名などとして、引用符なしで出力できる名前かを返す。
+// ipset の set 名は 31 文字までなので、接尾辞の分を除いた長さまでとする。
+func isValidName(s string) bool {
+	if s == "" || len(s) > maxNameLen {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9', c == '_', c == '-':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// IPv4 と IPv6 の set 名を返す。
+func (rs *RuleSet) SetNames() (string, string) {
+	return rs.name + "_v4", rs.name + "_v6"
+}
+
+// 指定の形式で w に書き込む。
+func (rs *RuleSet) Write(w io.Writer, f Format) error {
+	switch f {
+	case FormatCIDR:
+		return rs.WriteCIDR(w)
+	case FormatNftables:
+		return rs.WriteNftables(w)
+	case FormatIPSet:
+		return rs.WriteIPSet(w)
+	default:
+		return fmt.Errorf("%w: %v", ErrUnknownFormat, f)
+	}
+}
+
+// １行に１つの CIDR を、 IPv4 、 IPv6 の順に書き込む。
+func (rs *RuleSet) WriteCIDR(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, ps := range [][]netip.Prefix{rs.IPv4, rs.IPv6} {
+		for _, p := range ps {
+			fmt.Fprintln(bw, p)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// nft -f で読み込むファイルを書き込む。
+// テーブルと set がなければ作成し、 set の要素を全て置き換える。
+// 同じファイルを繰り返し読み込んでも、 set を参照するルールはそのまま使える。
+func (rs *RuleSet) WriteNftables(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	v4, v6 := rs.SetNames()
+	fmt.Fprintf(bw, "# countries: %s\n", strings.Join(rs.Countries, ","))
+	fmt.Fprintf(bw, "add table %s %s\n", rs.family, rs.table)
+	for _, s := range []struct {
+		name string
+		typ  string
+		ps   []netip.Prefix
+	}{
+		{name: v4, typ: "ipv4_addr", ps: rs.IPv4},
+		{name: v6, typ: "ipv6_addr", ps: rs.IPv6},
+	} {
+		fmt.Fprintf(bw, "add set %s %s %s { type %s; flags interval; }\n", rs.family, rs.table, s.name, s.typ)
+		fmt.Fprintf(bw, "flush set %s %s %s\n", rs.family, rs.table, s.name)
+		if len(s.ps) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "add element %s %s %s {\n", rs.family, rs.table, s.name)
+		for i, p := range s.ps {
+			sep := ","
+			if i == len(s.ps)-1 {
+				sep = ""
+			}
+			fmt.Fprintf(bw, "\t%s%s\n", p, sep)
+		}
+		fmt.Fprintln(bw, "}")
+	}
+
+	return bw.Flush()
+}
+
+// ipset restore で読み込むファイルを書き込む。
+// set がなければ作成し、一時的な set に要素を追加してから入れ替えるので、
+// 読込中も set を参照するルールは以前の要素で動作する。
+// swap で一時的な set が読込後の set になるので、どちらも maxelem は IPSetMaxElem とする。
+// プレフィックスの個数が IPSetMaxElem を超える場合は ErrTooManyElements を返し、何も書き込まない。
+func (rs *RuleSet) WriteIPSet(w io.Writer) error {
+	v4, v6 := rs.SetNames()
+	sets := []struct {
+		name   string
+		family string
+		ps     []netip.Prefix
+	}{
+		{name: v4, family: "inet", ps: rs.IPv4},
+		{name: v6, family: "inet6", ps: rs.IPv6},
+	}
+	for _, s := range sets {
+		if len(s.ps) > IPSetMaxElem {
+			return fmt.Errorf("%w: %s: %d", ErrTooManyElements, s.name, len(s.ps))
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, s := range sets {
+		tmp := s.name + "-tmp"
+		fmt.Fprintf(bw, "create %s hash:net family %s maxelem %d -exist\n", s.name, s.family, IPSetMaxElem)
+		fmt.Fprintf(bw, "create %s hash:net family %s maxelem %d -exist\n", tmp, s.family, IPSetMaxElem)
+		fmt.Fprintf(bw, "flush %s\n", tmp)
+		for _, p := range s.ps {
+			fmt.Fprintf(bw, "add %s %s\n", tmp, p)
+		}
+		fmt.Fprintf(bw, "swap %s %s\n", tmp, s.name)
+		fmt.Fprintf(bw, "destroy %s\n", tmp)
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,262 @@
+package firewall
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/suka-test/ccipv4"
+)
+
+// テスト用のファイルを読み込んだデータベースを返す。
+func loadDB(t *testing.T, files ...string) *ccipv4.DB {
+	t.Helper()
+	db := ccipv4.GetDB()
+	for _, f := range files {
+		if err := db.LoadIPBDataByFile(f); err != nil {
+			t.Fatalf("LoadIPBDataByFile: %s, but error: %v", f, err)
+		}
+	}
+	db.SwitchIPBData()
+
+	return db
+}
+
+func TestBuild(t *testing.T) {
+	db := loadDB(t, "../testdata/validIPBlockFile-1")
+
+	for _, tc := range []struct {
+		name      string
+		opts      Options
+		countries []string
+		ipv4      []netip.Prefix
+		ipv6      []netip.Prefix
+		err       error
+	}{
+		{
+			name:      "include",
+			opts:      Options{Include: []string{"jp", " HK "}},
+			countries: []string{"HK", "JP"},
+			ipv4:      []netip.Prefix{netip.MustParsePrefix("114.48.0.0/14")},
+			ipv6:      []netip.Prefix{netip.MustParsePrefix("2001:df2:6180::/48")},
+		},
+		// asn のみの NZ は含めない。
+		{
+			name:      "exclude",
+			opts:      Options{Exclude: []string{"JP", "HK", "IN"}},
+			countries: []string{"AU", "BD", "PH"},
+			ipv6: []netip.Prefix{
+				netip.MustParsePrefix("2001:df2:61c0::/48"),
+				netip.MustParsePrefix("2001:df2:6240::/48"),
+				netip.MustParsePrefix("2001:df2:62c0::/48"),
+			},
+		},
+		{
+			name:      "include and exclude",
+			opts:      Options{Include: []string{"JP", "HK"}, Exclude: []string{"HK"}},
+			countries: []string{"JP"},
+			ipv4:      []netip.Prefix{netip.MustParsePrefix("114.48.0.0/14")},
+		},
+		{name: "asn only", opts: Options{Include: []string{"NZ"}}, err: ErrEmptySelection},
+		{name: "unknown country", opts: Options{Include: []string{"XX"}}, err: ErrEmptySelection},
+		{name: "invalid name", opts: Options{Name: "1st"}, err: ErrInvalidName},
+		{name: "invalid table", opts: Options{Table: "filter; flush ruleset"}, err: ErrInvalidName},
+		{name: "too long name", opts: Options{Name: strings.Repeat("a", 25)}, err: ErrInvalidName},
+	} {
+		rs, err := Build(db, tc.opts)
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("Build: %s, want error %v, but %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Build: %s, but error: %v", tc.name, err)
+			continue
+		}
+		if !slices.Equal(rs.Countries, tc.countries) {
+			t.Errorf("Build: %s, countries want %v, but %v", tc.name, tc.countries, rs.Countries)
+		}
+		if !slices.Equal(rs.IPv4, tc.ipv4) {
+			t.Errorf("Build: %s, ipv4 want %v, but %v", tc.name, tc.ipv4, rs.IPv4)
+		}
+		if !slices.Equal(rs.IPv6, tc.ipv6) {
+			t.Errorf("Build: %s, ipv6 want %v, but %v", tc.name, tc.ipv6, rs.IPv6)
+		}
+	}
+
+	// データベースが空
+	if _, err := Build(ccipv4.GetDB(), Options{}); !errors.Is(err, ErrEmptySelection) {
+		t.Errorf("Build: empty db, want error %v, but %v", ErrEmptySelection, err)
+	}
+}
+
+func TestBuildAggregate(t *testing.T) {
+	// 隣接する別の国・地域の割当ブロックはまとめる。
+	f := filepath.Join(t.TempDir(), "delegated")
+	err := os.WriteFile(f, []byte(strings.Join([]string{
+		"apnic|JP|ipv4|1.0.0.0|256|20110811|assigned",
+		"apnic|CN|ipv4|1.0.1.0|256|20110414|allocated",
+		"apnic|JP|ipv4|1.0.2.0|512|20110811|assigned",
+		"apnic|AU|ipv4|1.0.4.0|1024|20110412|allocated",
+	}, "\n")), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := loadDB(t, f)
+
+	rs, err := Build(db, Options{Include: []string{"JP", "CN"}})
+	if err != nil {
+		t.Fatalf("Build: but error: %v", err)
+	}
+	if want := []netip.Prefix{netip.MustParsePrefix("1.0.0.0/22")}; !slices.Equal(rs.IPv4, want) {
+		t.Errorf("Build: want %v, but %v", want, rs.IPv4)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	db := loadDB(t, "../testdata/validIPBlockFile-1")
+	rs, err := Build(db, Options{Include: []string{"JP", "HK", "PH"}, Name: "geo", Table: "fw"})
+	if err != nil {
+		t.Fatalf("Build: but error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		format Format
+		want   string
+	}{
+		{
+			format: FormatCIDR,
+			want: strings.Join([]string{
+				"114.48.0.0/14",
+				"2001:df2:6180::/48",
+				"2001:df2:61c0::/48",
+				"",
+			}, "\n"),
+		},
+		{
+			format: FormatNftables,
+			want: strings.Join([]string{
+				"# countries: HK,JP,PH",
+				"add table inet fw",
+				"add set inet fw geo_v4 { type ipv4_addr; flags interval; }",
+				"flush set inet fw geo_v4",
+				"add element inet fw geo_v4 {",
+				"\t114.48.0.0/14",
+				"}",
+				"add set inet fw geo_v6 { type ipv6_addr; flags interval; }",
+				"flush set inet fw geo_v6",
+				"add element inet fw geo_v6 {",
+				"\t2001:df2:6180::/48,",
+				"\t2001:df2:61c0::/48",
+				"}",
+				"",
+			}, "\n"),
+		},
+		{
+			format: FormatIPSet,
+			want: strings.Join([]string{
+				"create geo_v4 hash:net family inet maxelem 1048576 -exist",
+				"create geo_v4-tmp hash:net family inet maxelem 1048576 -exist",
+				"flush geo_v4-tmp",
+				"add geo_v4-tmp 114.48.0.0/14",
+				"swap geo_v4-tmp geo_v4",
+				"destroy geo_v4-tmp",
+				"create geo_v6 hash:net family inet6 maxelem 1048576 -exist",
+				"create geo_v6-tmp hash:net family inet6 maxelem 1048576 -exist",
+				"flush geo_v6-tmp",
+				"add geo_v6-tmp 2001:df2:6180::/48",
+				"add geo_v6-tmp 2001:df2:61c0::/48",
+				"swap geo_v6-tmp geo_v6",
+				"destroy geo_v6-tmp",
+				"",
+			}, "\n"),
+		},
+	} {
+		var sb strings.Builder
+		if err := rs.Write(&sb, tc.format); err != nil {
+			t.Errorf("Write: %v, but error: %v", tc.format, err)
+			continue
+		}
+		if sb.String() != tc.want {
+			t.Errorf("Write: %v, want\n%s\nbut\n%s", tc.format, tc.want, sb.String())
+		}
+	}
+
+	if err := rs.Write(&strings.Builder{}, Format(-1)); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Write: unknown format, want error %v, but %v", ErrUnknownFormat, err)
+	}
+}
+
+func TestWriteNftablesEmptySet(t *testing.T) {
+	// 要素のない set は作成して空にするだけ
+	db := loadDB(t, "../testdata/validIPBlockFile-1")
+	rs, err := Build(db, Options{Include: []string{"JP"}})
+	if err != nil {
+		t.Fatalf("Build: but error: %v", err)
+	}
+	var sb strings.Builder
+	if err := rs.WriteNftables(&sb); err != nil {
+		t.Fatalf("WriteNftables: but error: %v", err)
+	}
+	if s := sb.String(); strings.Contains(s, "add element inet filter ccipv4_v6") || !strings.HasSuffix(s, "flush set inet filter ccipv4_v6\n") {
+		t.Errorf("WriteNftables: invalid empty set:\n%s", s)
+	}
+}
+
+func TestWriteIPSetMaxElem(t *testing.T) {
+	// 要素の個数が 65536 を超えても maxelem は変えない。
+	rs := &RuleSet{name: "geo"}
+	for i := 0; i < 70000; i++ {
+		rs.IPv4 = append(rs.IPv4, netip.PrefixFrom(netip.AddrFrom4([4]byte{10, byte(i >> 16), byte(i >> 8), byte(i)}), 32))
+	}
+	var sb strings.Builder
+	if err := rs.WriteIPSet(&sb); err != nil {
+		t.Fatalf("WriteIPSet: but error: %v", err)
+	}
+	for _, want := range []string{
+		"create geo_v4 hash:net family inet maxelem 1048576 -exist\n",
+		"create geo_v4-tmp hash:net family inet maxelem 1048576 -exist\n",
+		"add geo_v4-tmp 10.1.17.111/32\n",
+	} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("WriteIPSet: want %q, but not found", want)
+		}
+	}
+
+	// IPSetMaxElem を超える場合は何も書き込まない。
+	rs.IPv4 = make([]netip.Prefix, IPSetMaxElem+1)
+	sb.Reset()
+	if err := rs.WriteIPSet(&sb); !errors.Is(err, ErrTooManyElements) {
+		t.Errorf("WriteIPSet: want error %v, but %v", ErrTooManyElements, err)
+	}
+	if sb.Len() != 0 {
+		t.Errorf("WriteIPSet: too many elements, but wrote %d bytes", sb.Len())
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want Format
+	}{
+		{s: "cidr", want: FormatCIDR},
+		{s: "nftables", want: FormatNftables},
+		{s: "IPSet", want: FormatIPSet},
+	} {
+		f, err := ParseFormat(tc.s)
+		if err != nil || f != tc.want {
+			t.Errorf("ParseFormat: %s, want %v, but %v, %v", tc.s, tc.want, f, err)
+		}
+	}
+	if _, err := ParseFormat("iptables"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("ParseFormat: iptables, want error %v, but %v", ErrUnknownFormat, err)
+	}
+	if s := Format(9).String(); s != "Format(9)" {
+		t.Errorf("Format.String: want Format(9), but %s", s)
+	}
+}
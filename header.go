@@ -0,0 +1,163 @@
+package ccipv4
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// delegation file の header と record の件数の検証方法
+type HeaderCheck int
+
+const (
+	// 検証しない。
+	HeaderCheckNone HeaderCheck = iota
+	// 一致しない場合は SourceHeader.Warnings に記録し、データは読み込む。
+	HeaderCheckWarn
+	// 一致しない場合はエラーを返し、データを読み込まない。
+	HeaderCheckStrict
+)
+
+// header の version line の先頭の Field （ version ）を判定するために使用。
+var regForVersion = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// 読み込んだ delegation file ごとの header の情報と record の件数
+type SourceHeader struct {
+	// 読み込んだ URL またはファイルのパス
+	Source string
+	// version line の各 Field
+	Version   string
+	Registry  string
+	Serial    string
+	Records   int // version line がないか数値でない場合は -1
+	StartDate time.Time
+	EndDate   time.Time
+	UTCOffset string
+	// summary line で宣言された type （ asn, ipv4, ipv6 ）ごとの件数
+	Summary map[string]int
+	// 実際に読み込んだ type ごとの record の件数
+	Counts map[string]int
+	// HeaderCheckWarn の場合に一致しなかった内容と、
+	// キャッシュに保存できなかった場合のエラー
+	Warnings []error
+}
+
+// header の宣言と実際の record の件数が一致しない場合のエラー
+type HeaderMismatchError struct {
+	Source string
+	// 一致しない項目。version line の件数の場合は "records" 、
+	// summary line の件数の場合は type 。
+	Type     string
+	Declared int
+	Parsed   int
+}
+
+func (e *HeaderMismatchError) Error() string {
+	return fmt.Sprintf("header mismatch: %s: %s declared %d, but parsed %d", e.Source, e.Type, e.Declared, e.Parsed)
+}
+
+// delegation file の header と record の件数の検証方法を設定する。
+// 既定では検証しない。
+func WithHeaderCheck(hc HeaderCheck) Option {
+	return func(db *DB) {
+		db.headerCheck = hc
+	}
+}
+
+func newSourceHeader(source string) SourceHeader {
+	return SourceHeader{
+		Source:  source,
+		Records: -1,
+		Summary: map[string]int{},
+		Counts:  map[string]int{},
+	}
+}
+
+// version line の各 Field を格納する。
+// version|registry|serial|records|startdate|enddate|UTCoffset
+func (h *SourceHeader) setVersion(line []string) {
+	h.Version = line[0]
+	h.Registry = line[1]
+	h.Serial = line[2]
+	if n, err := strconv.Atoi(line[3]); err == nil {
+		h.Records = n
+	}
+	h.StartDate = dateToTime(parseDate(line[4]))
+	h.EndDate = dateToTime(parseDate(line[5]))
+	h.UTCOffset = line[6]
+}
+
+// summary line の件数を格納する。
+// registry|*|type|*|count|summary
+// 件数が数値でない場合は宣言なしとする。
+func (h *SourceHeader) addSummary(line []string) {
+	if n, err := strconv.Atoi(line[4]); err == nil {
+		h.Summary[line[2]] = n
+	}
+}
+
+// header で宣言された件数と実際に読み込んだ件数を比較し、
+// 一致しないものを返す。
+func (h *SourceHeader) check() []error {
+	var errs []error
+
+	if h.Records >= 0 {
+		total := 0
+		for _, n := range h.Counts {
+			total += n
+		}
+		if total != h.Records {
+			errs = append(errs, &HeaderMismatchError{Source: h.Source, Type: "records", Declared: h.Records, Parsed: total})
+		}
+	}
+	for _, t := range []string{"asn", "ipv4", "ipv6"} {
+		n, ok := h.Summary[t]
+		if ok && n != h.Counts[t] {
+			errs = append(errs, &HeaderMismatchError{Source: h.Source, Type: t, Declared: n, Parsed: h.Counts[t]})
+		}
+	}
+
+	return errs
+}
+
+// 検証方法に従い、header と record の件数を検証する。
+// HeaderCheckStrict で一致しない場合はエラーを返す。
+func (db *DB) checkHeader(h *SourceHeader) error {
+	if db.headerCheck == HeaderCheckNone {
+		return nil
+	}
+	errs := h.check()
+	if len(errs) == 0 {
+		return nil
+	}
+	if db.headerCheck == HeaderCheckStrict {
+		return errors.Join(errs...)
+	}
+	h.Warnings = errs
+
+	return nil
+}
+
+// 検索用データベースの元となった delegation file ごとの header の情報を取得する。
+func (db *DB) GetSourceHeaders() []SourceHeader {
+	db.ib.l.RLock()
+	defer db.ib.l.RUnlock()
+
+	return db.ib.headers
+}
+
+// 一時保存用データベースの、読込元が source の header の情報に警告を記録する。
+// 同じ読込元が複数ある場合は、最後に読み込んだものに記録する。
+func (db *DB) addSourceWarning(source string, err error) {
+	db.tmpIB.l.Lock()
+	defer db.tmpIB.l.Unlock()
+
+	for i := len(db.tmpIB.headers) - 1; i >= 0; i-- {
+		if db.tmpIB.headers[i].Source == source {
+			db.tmpIB.headers[i].Warnings = append(db.tmpIB.headers[i].Warnings, err)
+			return
+		}
+	}
+}
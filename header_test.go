@@ -0,0 +1,122 @@
+package ccipv4
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSourceHeader(t *testing.T) {
+	// header の内容を記録する。
+	db := GetDB()
+	if err := db.LoadIPBDataByFile("testdata/validHeaderFile"); err != nil {
+		t.Fatalf("LoadIPBDataByFile: load validHeaderFile, but error: %v", err)
+	}
+	if len(db.tmpIB.headers) != 1 {
+		t.Fatalf("LoadIPBDataByFile: tmpIB.headers length want 1, but %d", len(db.tmpIB.headers))
+	}
+	db.SwitchIPBData()
+	if len(db.tmpIB.headers) != 0 {
+		t.Errorf("SwitchIPBData: tmpIB.headers length want 0, but %d", len(db.tmpIB.headers))
+	}
+	hs := db.GetSourceHeaders()
+	if len(hs) != 1 {
+		t.Fatalf("GetSourceHeaders: length want 1, but %d", len(hs))
+	}
+	h := hs[0]
+	if h.Source != "testdata/validHeaderFile" {
+		t.Errorf("GetSourceHeaders: Source is invalid: %s", h.Source)
+	}
+	if h.Version != "2" || h.Registry != "apnic" || h.Serial != "20240804" || h.Records != 6 || h.UTCOffset != "+1000" {
+		t.Errorf("GetSourceHeaders: version line is invalid: %v", h)
+	}
+	if !h.StartDate.Equal(time.Date(1983, 6, 13, 0, 0, 0, 0, time.UTC)) || !h.EndDate.Equal(time.Date(2024, 8, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("GetSourceHeaders: dates are invalid: %v, %v", h.StartDate, h.EndDate)
+	}
+	for _, typ := range []string{"asn", "ipv4", "ipv6"} {
+		if h.Summary[typ] != 2 {
+			t.Errorf("GetSourceHeaders: Summary[%s] want 2, but %d", typ, h.Summary[typ])
+		}
+		if h.Counts[typ] != 2 {
+			t.Errorf("GetSourceHeaders: Counts[%s] want 2, but %d", typ, h.Counts[typ])
+		}
+	}
+	if len(h.Warnings) != 0 {
+		t.Errorf("GetSourceHeaders: Warnings want empty, but %v", h.Warnings)
+	}
+
+	// header がない場合
+	db = GetDB()
+	if err := db.setTmpIPBlocks(strings.NewReader("apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated\n"), "noheader"); err != nil {
+		t.Fatalf("setTmpIPBlocks: no header, but error: %v", err)
+	}
+	h = db.tmpIB.headers[0]
+	if h.Records != -1 || len(h.Summary) != 0 || h.Counts["ipv4"] != 1 {
+		t.Errorf("setTmpIPBlocks: header is invalid: %v", h)
+	}
+	if len(h.check()) != 0 {
+		t.Errorf("setTmpIPBlocks: no header, but mismatch: %v", h.check())
+	}
+}
+
+func TestHeaderCheck(t *testing.T) {
+	// 検証しない（既定）
+	db := NewDB()
+	if err := db.LoadIPBDataByFile("testdata/invalidHeaderFile"); err != nil {
+		t.Errorf("LoadIPBDataByFile: HeaderCheckNone, but error: %v", err)
+	}
+	if len(db.tmpIB.headers[0].Warnings) != 0 {
+		t.Errorf("LoadIPBDataByFile: HeaderCheckNone, but warnings: %v", db.tmpIB.headers[0].Warnings)
+	}
+
+	// 一致する場合はいずれの方法でもエラーなし
+	for _, hc := range []HeaderCheck{HeaderCheckWarn, HeaderCheckStrict} {
+		db = NewDB(WithHeaderCheck(hc))
+		if err := db.LoadIPBDataByFile("testdata/validHeaderFile"); err != nil {
+			t.Errorf("LoadIPBDataByFile: header matches (%d), but error: %v", hc, err)
+		} else if len(db.tmpIB.headers[0].Warnings) != 0 {
+			t.Errorf("LoadIPBDataByFile: header matches (%d), but warnings: %v", hc, db.tmpIB.headers[0].Warnings)
+		}
+	}
+
+	// 警告
+	db = NewDB(WithHeaderCheck(HeaderCheckWarn))
+	if err := db.LoadIPBDataByFile("testdata/invalidHeaderFile"); err != nil {
+		t.Errorf("LoadIPBDataByFile: HeaderCheckWarn, but error: %v", err)
+	}
+	if len(db.tmpIB.data) != 2 {
+		t.Errorf("LoadIPBDataByFile: HeaderCheckWarn, but tmpIB.data length %d", len(db.tmpIB.data))
+	}
+	ws := db.tmpIB.headers[0].Warnings
+	if len(ws) != 2 {
+		t.Fatalf("LoadIPBDataByFile: HeaderCheckWarn, warnings length want 2, but %d: %v", len(ws), ws)
+	}
+	for i, want := range []HeaderMismatchError{
+		{Source: "testdata/invalidHeaderFile", Type: "records", Declared: 7, Parsed: 6},
+		{Source: "testdata/invalidHeaderFile", Type: "ipv4", Declared: 3, Parsed: 2},
+	} {
+		var me *HeaderMismatchError
+		if !errors.As(ws[i], &me) {
+			t.Errorf("LoadIPBDataByFile: warnings[%d] is invalid: %v", i, ws[i])
+		} else if *me != want {
+			t.Errorf("LoadIPBDataByFile: warnings[%d] want %v, but %v", i, want, *me)
+		}
+	}
+
+	// エラー
+	db = NewDB(WithHeaderCheck(HeaderCheckStrict))
+	if err := db.LoadIPBDataByFile("testdata/validHeaderFile"); err != nil {
+		t.Fatalf("LoadIPBDataByFile: header matches, but error: %v", err)
+	}
+	err := db.LoadIPBDataByFile("testdata/invalidHeaderFile")
+	var me *HeaderMismatchError
+	if !errors.As(err, &me) {
+		t.Errorf("LoadIPBDataByFile: HeaderCheckStrict, but invalid error: %v", err)
+	} else if !strings.Contains(err.Error(), "header mismatch: testdata/invalidHeaderFile: ipv4 declared 3, but parsed 2") {
+		t.Errorf("LoadIPBDataByFile: HeaderCheckStrict, invalid error message: %v", err)
+	}
+	if len(db.tmpIB.data) != 0 || len(db.tmpIB.headers) != 0 {
+		t.Errorf("LoadIPBDataByFile: HeaderCheckStrict, but tmpIB is not cleared: %v, %v", db.tmpIB.data, db.tmpIB.headers)
+	}
+}
@@ -0,0 +1,53 @@
+// ファイルを書込途中の状態で読み込まれないように書き込む。
+package atomicfile
+
+import (
+	"bufio"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// 同じディレクトリの一時ファイルに書き込んでから名前を変更し、
+// 書込途中のファイルが読み込まれないようにする。
+// write が異常を返した場合は、既存のファイルを変更しない。
+// 既存のファイルがある場合はそのパーミッションを引き継ぎ、ない場合は 0644 とする。
+func Write(name string, write func(w io.Writer) error) error {
+	// os.CreateTemp は 0600 で作成するので、名前の変更前に変更する。
+	var mode fs.FileMode = 0o644
+	if fi, err := os.Stat(name); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// 異常が発生した場合は一時ファイルを削除する。
+	// 名前の変更後は一時ファイルが存在しないので何もしない。
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,99 @@
+package atomicfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "out.txt")
+
+	for _, s := range []string{"first\n", "second\n"} {
+		err := Write(name, func(w io.Writer) error {
+			_, err := fmt.Fprint(w, s)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Write: %q, but error: %v", s, err)
+		}
+		if b, _ := os.ReadFile(name); string(b) != s {
+			t.Errorf("Write: want %q, but %q", s, b)
+		}
+	}
+
+	// 異常の場合は既存のファイルを変更しない。
+	errWrite := errors.New("write error")
+	err := Write(name, func(w io.Writer) error {
+		fmt.Fprint(w, "third\n")
+		return errWrite
+	})
+	if !errors.Is(err, errWrite) {
+		t.Errorf("Write: want error %v, but %v", errWrite, err)
+	}
+	if b, _ := os.ReadFile(name); string(b) != "second\n" {
+		t.Errorf("Write: file changed: %q", b)
+	}
+
+	// 一時ファイルが残らない。
+	if es, _ := os.ReadDir(dir); len(es) != 1 {
+		t.Errorf("Write: temporary files remain: %v", es)
+	}
+
+	// ディレクトリが存在しない
+	if err := Write(filepath.Join(dir, "none", "out.txt"), func(w io.Writer) error { return nil }); err == nil {
+		t.Error("Write: directory doesn't exist, but no error")
+	}
+}
+
+func TestWriteMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows では読取専用以外のパーミッションを設定できない。")
+	}
+	dir := t.TempDir()
+	write := func(name string) {
+		t.Helper()
+		if err := Write(name, func(w io.Writer) error {
+			_, err := fmt.Fprint(w, "data\n")
+			return err
+		}); err != nil {
+			t.Fatalf("Write: %s, but error: %v", name, err)
+		}
+	}
+	mode := func(name string) os.FileMode {
+		t.Helper()
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Mode().Perm()
+	}
+
+	// 新しいファイルは 0644 とする。
+	name := filepath.Join(dir, "new.txt")
+	write(name)
+	if m := mode(name); m != 0o644 {
+		t.Errorf("Write: new file mode want 0644, but %#o", m)
+	}
+
+	// 既存のファイルのパーミッションを引き継ぐ。
+	for _, m := range []os.FileMode{0o644, 0o640} {
+		name := filepath.Join(dir, fmt.Sprintf("existing-%o.txt", m))
+		if err := os.WriteFile(name, nil, m); err != nil {
+			t.Fatal(err)
+		}
+		// umask の影響を受けないよう、改めて設定する。
+		if err := os.Chmod(name, m); err != nil {
+			t.Fatal(err)
+		}
+		write(name)
+		if got := mode(name); got != m {
+			t.Errorf("Write: existing file mode want %#o, but %#o", m, got)
+		}
+	}
+}
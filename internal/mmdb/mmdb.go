@@ -0,0 +1,51 @@
+// MaxMind DB （ MMDB ）形式のファイルの読み書きを行う。
+// 形式については下記を参照。
+// https://maxmind.github.io/MaxMind-DB/
+package mmdb
+
+import "errors"
+
+// データ部の型
+const (
+	typeExtended = 0
+	typePointer  = 1
+	typeString   = 2
+	typeDouble   = 3
+	typeBytes    = 4
+	typeUint16   = 5
+	typeUint32   = 6
+	typeMap      = 7
+	typeInt32    = 8
+	typeUint64   = 9
+	typeUint128  = 10
+	typeArray    = 11
+	typeBool     = 14
+	typeFloat    = 15
+)
+
+// メタデータの開始を示すバイト列
+var metadataStartMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// 探索木とデータ部の間の 0 のバイト列の長さ
+const dataSectionSeparatorSize = 16
+
+var (
+	ErrInvalidDatabase = errors.New("invalid mmdb")
+	ErrUnsupportedType = errors.New("unsupported data type")
+)
+
+// メタデータ
+type Metadata struct {
+	NodeCount    uint32
+	RecordSize   uint16
+	IPVersion    uint16
+	DatabaseType string
+	Languages    []string
+	// 言語ごとの説明
+	Description map[string]string
+	// 作成日時（ UNIX 時間）
+	BuildEpoch uint64
+	// binary_format_major_version と binary_format_minor_version
+	MajorVersion uint16
+	MinorVersion uint16
+}
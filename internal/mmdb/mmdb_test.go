@@ -0,0 +1,374 @@
+package mmdb
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	w := NewWriter("Test-Country")
+	w.Languages = []string{"en", "ja"}
+	w.Description = map[string]string{"en": "test database"}
+	w.BuildEpoch = 1722556800
+
+	jp := map[string]any{
+		"country": map[string]any{
+			"iso_code": "JP",
+			"names":    map[string]string{"en": "Japan", "ja": "日本"},
+		},
+		"registry": "apnic",
+	}
+	various := map[string]any{
+		"bool":   true,
+		"false":  false,
+		"bytes":  []byte{0, 1, 2},
+		"double": 1.5,
+		"uint16": uint16(0),
+		"uint32": uint32(70000),
+		"uint64": uint64(1) << 40,
+		"array":  []any{"a", uint16(1)},
+		"list":   []string{"x", "y"},
+		// サイズの拡張バイトが１、２、３バイトの文字列
+		"long1": strings.Repeat("a", 100),
+		"long2": strings.Repeat("b", 1000),
+		"long3": strings.Repeat("c", 70000),
+	}
+	for _, tc := range []struct {
+		prefix string
+		v      any
+	}{
+		{prefix: "114.48.0.0/14", v: jp},
+		{prefix: "1.0.0.0/24", v: jp},
+		{prefix: "10.0.0.0/8", v: "private"},
+		{prefix: "10.1.0.0/16", v: various},
+		{prefix: "2001:df2:6180::/48", v: map[string]any{"registry": "apnic"}},
+	} {
+		if err := w.Insert(netip.MustParsePrefix(tc.prefix), tc.v); err != nil {
+			t.Fatalf("Insert: %s, but error: %v", tc.prefix, err)
+		}
+	}
+	// 同じ内容のデータは一つにまとめる。
+	if n := bytes.Count(w.data, []byte("apnic")); n != 2 {
+		t.Errorf("Insert: data want 2 registries, but %d", n)
+	}
+
+	var buf bytes.Buffer
+	n, err := w.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo: but error: %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo: size want %d, but %d", buf.Len(), n)
+	}
+
+	r, err := NewReader(buf.Bytes())
+	if err != nil {
+		t.Fatalf("NewReader: but error: %v", err)
+	}
+	md := r.Metadata
+	if md.IPVersion != 6 || md.RecordSize != 24 || md.DatabaseType != "Test-Country" || md.BuildEpoch != 1722556800 ||
+		md.MajorVersion != 2 || md.MinorVersion != 0 || !reflect.DeepEqual(md.Languages, []string{"en", "ja"}) ||
+		md.Description["en"] != "test database" || md.NodeCount == 0 {
+		t.Errorf("NewReader: invalid metadata: %+v", md)
+	}
+
+	jpDecoded := map[string]any{
+		"country": map[string]any{
+			"iso_code": "JP",
+			"names":    map[string]any{"en": "Japan", "ja": "日本"},
+		},
+		"registry": "apnic",
+	}
+	variousDecoded := map[string]any{
+		"bool":   true,
+		"false":  false,
+		"bytes":  []byte{0, 1, 2},
+		"double": 1.5,
+		"uint16": uint16(0),
+		"uint32": uint32(70000),
+		"uint64": uint64(1) << 40,
+		"array":  []any{"a", uint16(1)},
+		"list":   []any{"x", "y"},
+		"long1":  strings.Repeat("a", 100),
+		"long2":  strings.Repeat("b", 1000),
+		"long3":  strings.Repeat("c", 70000),
+	}
+	for _, tc := range []struct {
+		addr   string
+		v      any
+		prefix string
+		ok     bool
+	}{
+		{addr: "114.51.255.255", v: jpDecoded, prefix: "114.48.0.0/14", ok: true},
+		{addr: "::ffff:1.0.0.1", v: jpDecoded, prefix: "1.0.0.0/24", ok: true},
+		{addr: "1.0.1.0", prefix: "1.0.1.0/24"},
+		{addr: "10.1.2.3", v: variousDecoded, prefix: "10.1.0.0/16", ok: true},
+		// 後から追加したプレフィックスで分割された範囲
+		{addr: "10.2.0.1", v: "private", prefix: "10.2.0.0/15", ok: true},
+		{addr: "10.128.0.1", v: "private", prefix: "10.128.0.0/9", ok: true},
+		{addr: "2001:df2:6180::1", v: map[string]any{"registry": "apnic"}, prefix: "2001:df2:6180::/48", ok: true},
+		{addr: "2001:df2:6181::1", prefix: "2001:df2:6181::/48"},
+		{addr: "8000::1", prefix: "8000::/1"},
+	} {
+		v, p, ok, err := r.Lookup(netip.MustParseAddr(tc.addr))
+		if err != nil {
+			t.Errorf("Lookup: %s, but error: %v", tc.addr, err)
+			continue
+		}
+		if ok != tc.ok || !reflect.DeepEqual(v, tc.v) {
+			t.Errorf("Lookup: %s, want %v %v, but %v %v", tc.addr, tc.ok, tc.v, ok, v)
+		}
+		if p != netip.MustParsePrefix(tc.prefix) {
+			t.Errorf("Lookup: %s, prefix want %s, but %s", tc.addr, tc.prefix, p)
+		}
+	}
+	if _, _, ok, err := r.Lookup(netip.Addr{}); ok || err != nil {
+		t.Errorf("Lookup: invalid address, but %v, %v", ok, err)
+	}
+
+	// IPv4 のネットワークをアドレス順に返す。
+	var got []string
+	err = r.Networks4(func(p netip.Prefix, record uint32) error {
+		v, err := r.Data(record)
+		if err != nil {
+			return err
+		}
+		if s, ok := v.(string); ok {
+			got = append(got, p.String()+" "+s)
+		} else {
+			got = append(got, p.String())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Networks4: but error: %v", err)
+	}
+	want := []string{
+		"1.0.0.0/24",
+		"10.0.0.0/16 private",
+		"10.1.0.0/16",
+		"10.2.0.0/15 private",
+		"10.4.0.0/14 private",
+		"10.8.0.0/13 private",
+		"10.16.0.0/12 private",
+		"10.32.0.0/11 private",
+		"10.64.0.0/10 private",
+		"10.128.0.0/9 private",
+		"114.48.0.0/14",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Networks4: want %v, but %v", want, got)
+	}
+
+	// fn の異常で中止する。
+	errStop := errors.New("stop")
+	n = 0
+	err = r.Networks4(func(p netip.Prefix, record uint32) error {
+		n++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || n != 1 {
+		t.Errorf("Networks4: want error %v after 1 network, but %v after %d", errStop, err, n)
+	}
+}
+
+func TestNetworks4Whole(t *testing.T) {
+	// ::/96 より広いネットワークにデータがある場合は、 IPv4 の全体を返す。
+	w := NewWriter("Test")
+	if err := w.Insert(netip.MustParsePrefix("::/1"), "low"); err != nil {
+		t.Fatalf("Insert: but error: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: but error: %v", err)
+	}
+	r, err := NewReader(buf.Bytes())
+	if err != nil {
+		t.Fatalf("NewReader: but error: %v", err)
+	}
+	var got []netip.Prefix
+	if err := r.Networks4(func(p netip.Prefix, record uint32) error {
+		got = append(got, p)
+		return nil
+	}); err != nil {
+		t.Fatalf("Networks4: but error: %v", err)
+	}
+	if want := []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Networks4: want %v, but %v", want, got)
+	}
+}
+
+func TestNetworks4SharedNodes(t *testing.T) {
+	// 各ノードの両方の子が次のノードを指す探索木。
+	// 通るネットワークの個数は 2^32 になるので、途中で異常とする。
+	const nodeCount = 32
+	r := &Reader{buf: make([]byte, nodeCount*6), nodeSize: 6}
+	r.Metadata.RecordSize = 24
+	r.Metadata.NodeCount = nodeCount
+	for i := 0; i < nodeCount; i++ {
+		next := uint32(i + 1)
+		if i == nodeCount-1 {
+			next = nodeCount + 16
+		}
+		putNode(r.buf[i*6:], 24, [2]uint32{next, next})
+	}
+	n := 0
+	err := r.Networks4(func(p netip.Prefix, record uint32) error {
+		n++
+		return nil
+	})
+	if !errors.Is(err, ErrInvalidDatabase) || n > 2*nodeCount {
+		t.Errorf("Networks4: want error %v, but %v after %d networks", ErrInvalidDatabase, err, n)
+	}
+}
+
+func TestInsertError(t *testing.T) {
+	w := NewWriter("Test")
+	for _, tc := range []struct {
+		p   netip.Prefix
+		v   any
+		err error
+	}{
+		{p: netip.Prefix{}, v: "x", err: ErrInvalidDatabase},
+		{p: netip.MustParsePrefix("::/0"), v: "x", err: ErrInvalidDatabase},
+		{p: netip.MustParsePrefix("1.0.0.0/24"), v: 1, err: ErrUnsupportedType},
+		{p: netip.MustParsePrefix("1.0.0.0/24"), v: map[string]any{"k": int64(1)}, err: ErrUnsupportedType},
+		{p: netip.MustParsePrefix("1.0.0.0/24"), v: []any{nil}, err: ErrUnsupportedType},
+	} {
+		if err := w.Insert(tc.p, tc.v); !errors.Is(err, tc.err) {
+			t.Errorf("Insert: %v %v, want error %v, but %v", tc.p, tc.v, tc.err, err)
+		}
+	}
+}
+
+func TestRecordSize(t *testing.T) {
+	// 各レコードサイズで書き込んだ値を読み込める。
+	for _, size := range []int{24, 28, 32} {
+		v := [2]uint32{1<<(size-1) + 5, 1<<(size-2) + 7}
+		buf := make([]byte, size/4)
+		putNode(buf, size, v)
+		r := &Reader{buf: buf, nodeSize: size / 4}
+		r.Metadata.RecordSize = uint16(size)
+		for bit := 0; bit < 2; bit++ {
+			if got := r.readRecord(0, bit); got != v[bit] {
+				t.Errorf("readRecord: size %d, bit %d, want %d, but %d", size, bit, v[bit], got)
+			}
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	d := decoder{buf: []byte{
+		// 0: "abc"
+		0x43, 'a', 'b', 'c',
+		// 4: 配列 [ポインタ(0), ポインタ(0)]
+		0x02, 0x04, 0x20, 0x00, 0x20, 0x00,
+		// 10: int32 -1
+		0x04, 0x01, 0xff, 0xff, 0xff, 0xff,
+		// 16: uint128
+		0x02, 0x03, 0x01, 0x00,
+		// 20: float 1.5
+		0x04, 0x08, 0x3f, 0xc0, 0x00, 0x00,
+		// 26: ポインタ(27) 、 27: ポインタ(0)
+		0x20, 0x1b, 0x20, 0x00,
+	}}
+	for _, tc := range []struct {
+		off  int
+		want any
+		next int
+	}{
+		{off: 0, want: "abc", next: 4},
+		{off: 4, want: []any{"abc", "abc"}, next: 10},
+		{off: 10, want: int32(-1), next: 16},
+		{off: 16, want: big.NewInt(256), next: 20},
+		{off: 20, want: float32(1.5), next: 26},
+	} {
+		v, next, err := d.decode(tc.off)
+		if err != nil || !reflect.DeepEqual(v, tc.want) || next != tc.next {
+			t.Errorf("decode: %d, want %v %d, but %v %d %v", tc.off, tc.want, tc.next, v, next, err)
+		}
+	}
+	if _, _, err := d.decode(26); !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("decode: pointer to pointer, want error %v, but %v", ErrInvalidDatabase, err)
+	}
+	if _, _, err := d.decode(len(d.buf)); !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("decode: end of data, want error %v, but %v", ErrInvalidDatabase, err)
+	}
+}
+
+func TestNewReaderError(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := NewWriter("Test").WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: but error: %v", err)
+	}
+	valid := buf.Bytes()
+
+	for _, tc := range []struct {
+		name string
+		b    []byte
+	}{
+		{name: "empty", b: nil},
+		{name: "no metadata", b: valid[:6+dataSectionSeparatorSize]},
+		{name: "broken metadata", b: append(append([]byte{}, metadataStartMarker...), 0xe1)},
+		{name: "not a map", b: append(append([]byte{}, metadataStartMarker...), 0x41, 'a')},
+		{name: "record size", b: append(append([]byte{}, metadataStartMarker...), 0xe1, 0x4b, 'r', 'e', 'c', 'o', 'r', 'd', '_', 's', 'i', 'z', 'e', 0xa1, 0x10)},
+	} {
+		if _, err := NewReader(tc.b); !errors.Is(err, ErrInvalidDatabase) {
+			t.Errorf("NewReader: %s, want error %v, but %v", tc.name, ErrInvalidDatabase, err)
+		}
+	}
+}
+
+func TestDecodeLimit(t *testing.T) {
+	// 値が自身を指すポインタのマップ
+	self := []byte{0xe1, 0x41, 'a', 0x20, 0x00}
+	// 各階層が次の階層を２回指すマップ。値の個数は階層の数の２のべき乗になる。
+	var fanout []byte
+	const levels = 24
+	for i := 0; i < levels; i++ {
+		next := len(fanout) + 9
+		fanout = append(fanout, 0xe2, 0x41, 'a', 0x20|byte(next>>8), byte(next), 0x41, 'b', 0x20|byte(next>>8), byte(next))
+	}
+	fanout = append(fanout, 0x41, 'x')
+	// サイズが 16777216 を超える配列とマップで、要素がないもの
+	hugeArray := []byte{0x1f, 0x04, 0xff, 0xff, 0xff}
+	hugeMap := []byte{0xff, 0xff, 0xff, 0xff}
+
+	for _, tc := range []struct {
+		name string
+		buf  []byte
+	}{
+		{name: "self reference", buf: self},
+		{name: "fan-out", buf: fanout},
+		{name: "huge array", buf: hugeArray},
+		{name: "huge map", buf: hugeMap},
+	} {
+		d := decoder{buf: tc.buf}
+		if _, _, err := d.decode(0); !errors.Is(err, ErrInvalidDatabase) {
+			t.Errorf("decode: %s, want error %v, but %v", tc.name, ErrInvalidDatabase, err)
+		}
+	}
+
+	// 上限以内の入れ子は読み込める。
+	// 要素が１つの配列を入れ子にし、最も内側は空の配列とする。
+	nested := func(depth int) []byte {
+		var b []byte
+		for i := 1; i < depth; i++ {
+			b = append(b, 0x01, 0x04)
+		}
+		return append(b, 0x00, 0x04)
+	}
+	d := decoder{buf: nested(maxDepth)}
+	if _, _, err := d.decode(0); err != nil {
+		t.Errorf("decode: nested %d, but error: %v", maxDepth, err)
+	}
+	d = decoder{buf: nested(maxDepth + 1)}
+	if _, _, err := d.decode(0); !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("decode: nested %d, want error %v, but %v", maxDepth+1, ErrInvalidDatabase, err)
+	}
+}
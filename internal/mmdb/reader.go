@@ -0,0 +1,434 @@
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+)
+
+// メタデータを探す範囲（ファイルの末尾からのバイト数）
+const maxMetadataSize = 128 * 1024
+
+// MaxMind DB 形式のデータを読み込む。
+type Reader struct {
+	Metadata Metadata
+
+	buf []byte
+	// 節のバイト数
+	nodeSize int
+	// データ部の開始位置
+	dataStart int
+	// IPv6 の探索木で、 IPv4 のアドレスの検索を開始する節
+	ipv4Start uint32
+	// ipv4Start までの深さ
+	ipv4Depth int
+}
+
+// MaxMind DB 形式のデータを読み込んだ Reader を返す。
+func NewReader(b []byte) (*Reader, error) {
+	start := len(b) - maxMetadataSize
+	if start < 0 {
+		start = 0
+	}
+	i := bytes.LastIndex(b[start:], metadataStartMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: metadata not found", ErrInvalidDatabase)
+	}
+	metaStart := start + i + len(metadataStartMarker)
+
+	d := decoder{buf: b[metaStart:]}
+	v, _, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %w", ErrInvalidDatabase, err)
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidDatabase)
+	}
+	r := &Reader{buf: b}
+	md := &r.Metadata
+	md.NodeCount = uint32(toUint(m["node_count"]))
+	md.RecordSize = uint16(toUint(m["record_size"]))
+	md.IPVersion = uint16(toUint(m["ip_version"]))
+	md.DatabaseType, _ = m["database_type"].(string)
+	md.BuildEpoch = toUint(m["build_epoch"])
+	md.MajorVersion = uint16(toUint(m["binary_format_major_version"]))
+	md.MinorVersion = uint16(toUint(m["binary_format_minor_version"]))
+	if ls, ok := m["languages"].([]any); ok {
+		for _, l := range ls {
+			if s, ok := l.(string); ok {
+				md.Languages = append(md.Languages, s)
+			}
+		}
+	}
+	if ds, ok := m["description"].(map[string]any); ok {
+		md.Description = map[string]string{}
+		for k, v := range ds {
+			if s, ok := v.(string); ok {
+				md.Description[k] = s
+			}
+		}
+	}
+
+	switch md.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: unsupported record size: %d", ErrInvalidDatabase, md.RecordSize)
+	}
+	if md.IPVersion != 4 && md.IPVersion != 6 {
+		return nil, fmt.Errorf("%w: unsupported ip version: %d", ErrInvalidDatabase, md.IPVersion)
+	}
+	r.nodeSize = int(md.RecordSize) / 4
+	treeSize := int(md.NodeCount) * r.nodeSize
+	r.dataStart = treeSize + dataSectionSeparatorSize
+	if r.dataStart > start+i {
+		return nil, fmt.Errorf("%w: search tree is too large", ErrInvalidDatabase)
+	}
+
+	// IPv6 の探索木では、 IPv4 のアドレスを ::a.b.c.d として検索する。
+	if md.IPVersion == 6 {
+		for ; r.ipv4Depth < 96 && r.ipv4Start < md.NodeCount; r.ipv4Depth++ {
+			r.ipv4Start = r.readRecord(r.ipv4Start, 0)
+		}
+	}
+
+	return r, nil
+}
+
+// 節の左（ bit が 0 ）または右（ bit が 1 ）のレコードの値を返す。
+func (r *Reader) readRecord(n uint32, bit int) uint32 {
+	b := r.buf[int(n)*r.nodeSize:]
+	switch r.Metadata.RecordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		if bit == 0 {
+			return uint32(b[3]&0xf0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		return binary.BigEndian.Uint32(b[bit*4:])
+	}
+}
+
+// アドレスを含むネットワークのデータとプレフィックスを返す。
+// 該当するデータがない場合は false を返す。
+func (r *Reader) Lookup(addr netip.Addr) (any, netip.Prefix, bool, error) {
+	addr = addr.Unmap().WithZone("")
+	if !addr.IsValid() || (addr.Is6() && r.Metadata.IPVersion == 4) {
+		return nil, netip.Prefix{}, false, nil
+	}
+
+	a := addr.As16()
+	n, depth := uint32(0), 0
+	if addr.Is4() {
+		v4 := addr.As4()
+		a = [16]byte{}
+		copy(a[12:], v4[:])
+		if r.Metadata.IPVersion == 6 {
+			n, depth = r.ipv4Start, r.ipv4Depth
+		} else {
+			depth = 96
+		}
+	}
+	for ; depth < 128 && n < r.Metadata.NodeCount; depth++ {
+		n = r.readRecord(n, bitAt(a, depth))
+	}
+
+	bits := depth
+	if addr.Is4() {
+		// ::/96 より広いネットワークに含まれる場合は 0 とする。
+		bits = max(depth-96, 0)
+	}
+	p, _ := addr.Prefix(bits)
+	if n <= r.Metadata.NodeCount {
+		return nil, p, false, nil
+	}
+	v, err := r.Data(n)
+	if err != nil {
+		return nil, p, false, err
+	}
+
+	return v, p, true, nil
+}
+
+// レコードの値が指すデータを返す。
+func (r *Reader) Data(record uint32) (any, error) {
+	off := int(record) - int(r.Metadata.NodeCount) - dataSectionSeparatorSize
+	if off < 0 || r.dataStart+off >= len(r.buf) {
+		return nil, fmt.Errorf("%w: invalid data pointer: %d", ErrInvalidDatabase, record)
+	}
+	d := decoder{buf: r.buf[r.dataStart:]}
+	v, _, err := d.decode(off)
+
+	return v, err
+}
+
+// マップと配列の入れ子の深さの上限
+const maxDepth = 32
+
+// 一つの値をデコードする際に読み込む値の個数の上限
+// ポインタで同じ値を何度も参照するデータで、処理が終わらなくなることを防ぐ。
+const maxValues = 1 << 20
+
+// データ部のデコードを行う。
+type decoder struct {
+	buf []byte
+	// 現在のマップと配列の入れ子の深さ
+	depth int
+	// 読み込んだ値の個数
+	values int
+}
+
+// off の位置の値と、次の値の位置を返す。
+func (d *decoder) decode(off int) (any, int, error) {
+	if d.values++; d.values > maxValues {
+		return nil, 0, fmt.Errorf("%w: too many values", ErrInvalidDatabase)
+	}
+	typ, size, off, err := d.control(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == typePointer {
+		// ポインタの先の値を返し、ポインタの次から読み進める。
+		v, next, err := d.decodeValue(typ, size, off)
+		if err != nil {
+			return nil, 0, err
+		}
+		p := v.(int)
+		ptyp, psize, poff, err := d.control(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ptyp == typePointer {
+			return nil, 0, fmt.Errorf("%w: pointer to pointer", ErrInvalidDatabase)
+		}
+		v, _, err = d.decodeValue(ptyp, psize, poff)
+		return v, next, err
+	}
+
+	return d.decodeValue(typ, size, off)
+}
+
+// off の位置の制御バイトを読み、型とサイズ、値の開始位置を返す。
+// ポインタの場合は、サイズとして制御バイトの下位５ビットを返す。
+func (d *decoder) control(off int) (int, int, int, error) {
+	if off >= len(d.buf) {
+		return 0, 0, 0, fmt.Errorf("%w: unexpected end of data", ErrInvalidDatabase)
+	}
+	ctrl := d.buf[off]
+	off++
+	typ := int(ctrl >> 5)
+	if typ == typePointer {
+		return typ, int(ctrl & 0x1f), off, nil
+	}
+	if typ == typeExtended {
+		if off >= len(d.buf) {
+			return 0, 0, 0, fmt.Errorf("%w: unexpected end of data", ErrInvalidDatabase)
+		}
+		typ = int(d.buf[off]) + 7
+		off++
+	}
+
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if off+n > len(d.buf) {
+			return 0, 0, 0, fmt.Errorf("%w: unexpected end of data", ErrInvalidDatabase)
+		}
+		var s int
+		for _, b := range d.buf[off : off+n] {
+			s = s<<8 | int(b)
+		}
+		off += n
+		switch n {
+		case 1:
+			size = 29 + s
+		case 2:
+			size = 285 + s
+		default:
+			size = 65821 + s
+		}
+	}
+
+	return typ, size, off, nil
+}
+
+// 型とサイズに応じて off の位置の値を返す。
+func (d *decoder) decodeValue(typ, size, off int) (any, int, error) {
+	if typ == typeMap || typ == typeArray {
+		// 自身を指すポインタを含むマップなどで、再帰が終わらなくなることを防ぐ。
+		if d.depth++; d.depth > maxDepth {
+			return nil, 0, fmt.Errorf("%w: too deeply nested", ErrInvalidDatabase)
+		}
+		defer func() { d.depth-- }()
+	}
+	// 各要素は１バイト以上なので、残りのバイト数を超える容量は確保しない。
+	hint := min(size, len(d.buf)-off)
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, hint/2)
+		for i := 0; i < size; i++ {
+			k, next, err := d.decode(off)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("%w: map key is not a string", ErrInvalidDatabase)
+			}
+			v, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			off = next
+		}
+		return m, off, nil
+	case typeArray:
+		a := make([]any, 0, hint)
+		for i := 0; i < size; i++ {
+			v, next, err := d.decode(off)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			off = next
+		}
+		return a, off, nil
+	case typeBool:
+		return size != 0, off, nil
+	}
+
+	n := size
+	if typ == typePointer {
+		n = (size>>3)&3 + 1
+	}
+	if off+n > len(d.buf) {
+		return nil, 0, fmt.Errorf("%w: unexpected end of data", ErrInvalidDatabase)
+	}
+	b := d.buf[off : off+n]
+	next := off + n
+
+	switch typ {
+	case typePointer:
+		v := size & 0x7
+		var p int
+		switch n {
+		case 1:
+			p = v<<8 | int(b[0])
+		case 2:
+			p = (v<<16 | int(b[0])<<8 | int(b[1])) + 2048
+		case 3:
+			p = (v<<24 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])) + 526336
+		default:
+			p = int(binary.BigEndian.Uint32(b))
+		}
+		return p, next, nil
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return bytes.Clone(b), next, nil
+	case typeDouble:
+		if n != 8 {
+			return nil, 0, fmt.Errorf("%w: invalid double size: %d", ErrInvalidDatabase, n)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if n != 4 {
+			return nil, 0, fmt.Errorf("%w: invalid float size: %d", ErrInvalidDatabase, n)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), next, nil
+	case typeUint16, typeUint32, typeUint64, typeInt32:
+		if n > 8 || (typ == typeUint16 && n > 2) || ((typ == typeUint32 || typ == typeInt32) && n > 4) {
+			return nil, 0, fmt.Errorf("%w: invalid integer size: %d", ErrInvalidDatabase, n)
+		}
+		var x uint64
+		for _, c := range b {
+			x = x<<8 | uint64(c)
+		}
+		switch typ {
+		case typeUint16:
+			return uint16(x), next, nil
+		case typeUint32:
+			return uint32(x), next, nil
+		case typeInt32:
+			return int32(uint32(x)), next, nil
+		default:
+			return x, next, nil
+		}
+	case typeUint128:
+		return new(big.Int).SetBytes(b), next, nil
+	default:
+		return nil, 0, fmt.Errorf("%w: %d", ErrUnsupportedType, typ)
+	}
+}
+
+// メタデータの整数の値を uint64 で返す。
+func toUint(v any) uint64 {
+	switch v := v.(type) {
+	case uint16:
+		return uint64(v)
+	case uint32:
+		return uint64(v)
+	case uint64:
+		return v
+	default:
+		return 0
+	}
+}
+
+// IPv4 のネットワークのうち、データのあるものをアドレス順に、
+// プレフィックスとレコードの値を fn に渡す。
+// レコードの値が指すデータは Data で取得する。
+// fn が異常を返した場合は中止してそれを返す。
+func (r *Reader) Networks4(fn func(p netip.Prefix, record uint32) error) error {
+	type item struct {
+		record uint32
+		bits   int
+		addr   uint32
+	}
+	nodeCount := r.Metadata.NodeCount
+	var visited uint32
+	// IPv6 の探索木で ::/96 より広いネットワークにデータがある場合も、
+	// IPv4 の全体を一つのネットワークとして扱う。
+	stack := []item{{record: r.ipv4Start}}
+	for len(stack) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		switch {
+		case it.record == nodeCount:
+			continue
+		case it.record > nodeCount:
+			a := [4]byte{byte(it.addr >> 24), byte(it.addr >> 16), byte(it.addr >> 8), byte(it.addr)}
+			if err := fn(netip.PrefixFrom(netip.AddrFrom4(a), it.bits), it.record); err != nil {
+				return err
+			}
+			continue
+		}
+		if it.bits >= 32 {
+			return fmt.Errorf("%w: ipv4 search tree is too deep", ErrInvalidDatabase)
+		}
+		// 探索木では各ノードを１回だけ通るので、ノード数を超えて通る場合は
+		// 同じノードを複数の親が指している。
+		visited++
+		if visited > nodeCount {
+			return fmt.Errorf("%w: ipv4 search tree has shared nodes", ErrInvalidDatabase)
+		}
+		// アドレス順に処理するため、右の子を先に積む。
+		for bit := 1; bit >= 0; bit-- {
+			stack = append(stack, item{
+				record: r.readRecord(it.record, bit),
+				bits:   it.bits + 1,
+				addr:   it.addr | uint32(bit)<<(31-it.bits),
+			})
+		}
+	}
+
+	return nil
+}
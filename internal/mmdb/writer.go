@@ -0,0 +1,333 @@
+package mmdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"slices"
+)
+
+// 探索木の節
+type node struct {
+	children [2]record
+	// 書込時の節の番号
+	id int
+}
+
+// 節の子。 node と data のどちらも持たない場合は該当なし。
+type record struct {
+	node *node
+	// データ部のオフセット + 1 。 0 の場合はデータなし。
+	data int
+}
+
+// MaxMind DB 形式のファイルを作成する。
+// IPv6 の探索木を作成し、 IPv4 のプレフィックスは ::/96 の下に格納する。
+type Writer struct {
+	DatabaseType string
+	Languages    []string
+	Description  map[string]string
+	// 作成日時（ UNIX 時間）
+	BuildEpoch uint64
+
+	root *node
+	data []byte
+	// 同じ内容のデータは一つにまとめるため、
+	// エンコードしたデータごとのオフセットを記録する。
+	offsets map[string]int
+}
+
+// 空の Writer を返す。
+func NewWriter(databaseType string) *Writer {
+	return &Writer{
+		DatabaseType: databaseType,
+		root:         &node{},
+		offsets:      map[string]int{},
+	}
+}
+
+// プレフィックスに値を対応付ける。
+// 値は string 、 []byte 、 bool 、 uint16 、 uint32 、 uint64 、 float64 、
+// およびそれらを要素とする []any 、 []string 、 map[string]any 、 map[string]string 。
+// 既に追加したプレフィックスと重なる場合は、後から追加したものが優先する。
+func (w *Writer) Insert(p netip.Prefix, v any) error {
+	if !p.IsValid() {
+		return fmt.Errorf("%w: invalid prefix: %v", ErrInvalidDatabase, p)
+	}
+	p = p.Masked()
+	a := p.Addr().As16()
+	bits := p.Bits()
+	if p.Addr().Is4() {
+		// IPv4 は ::a.b.c.d として格納する。
+		v4 := p.Addr().As4()
+		a = [16]byte{}
+		copy(a[12:], v4[:])
+		bits += 96
+	}
+	if bits == 0 {
+		return fmt.Errorf("%w: prefix length is 0: %v", ErrInvalidDatabase, p)
+	}
+
+	b, err := encode(nil, v)
+	if err != nil {
+		return err
+	}
+	off, ok := w.offsets[string(b)]
+	if !ok {
+		off = len(w.data)
+		w.data = append(w.data, b...)
+		w.offsets[string(b)] = off
+	}
+
+	n := w.root
+	for i := 0; i < bits-1; i++ {
+		c := &n.children[bitAt(a, i)]
+		if c.node == nil {
+			nn := &node{}
+			// より広いプレフィックスのデータは、分割した両方の子に引き継ぐ。
+			nn.children[0].data = c.data
+			nn.children[1].data = c.data
+			*c = record{node: nn}
+		}
+		n = c.node
+	}
+	n.children[bitAt(a, bits-1)] = record{data: off + 1}
+
+	return nil
+}
+
+// アドレスの先頭から i 番目のビットを返す。
+func bitAt(a [16]byte, i int) int {
+	return int(a[i/8]>>(7-i%8)) & 1
+}
+
+// MaxMind DB 形式で out に書き込む。
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	// 根から深さ優先の順で節に番号を付ける。
+	var nodes []*node
+	stack := []*node{w.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		n.id = len(nodes)
+		nodes = append(nodes, n)
+		for i := 1; i >= 0; i-- {
+			if c := n.children[i].node; c != nil {
+				stack = append(stack, c)
+			}
+		}
+	}
+	nodeCount := len(nodes)
+
+	// 最大の値を格納できる最小のレコードサイズ
+	maxValue := uint64(nodeCount + dataSectionSeparatorSize + len(w.data))
+	var recordSize int
+	switch {
+	case maxValue < 1<<24:
+		recordSize = 24
+	case maxValue < 1<<28:
+		recordSize = 28
+	case maxValue <= math.MaxUint32:
+		recordSize = 32
+	default:
+		return 0, fmt.Errorf("%w: too large: %d", ErrInvalidDatabase, maxValue)
+	}
+
+	cw := &countWriter{w: bufio.NewWriter(out)}
+	buf := make([]byte, recordSize/4)
+	for _, n := range nodes {
+		var v [2]uint32
+		for i, c := range n.children {
+			switch {
+			case c.node != nil:
+				v[i] = uint32(c.node.id)
+			case c.data != 0:
+				v[i] = uint32(nodeCount + dataSectionSeparatorSize + c.data - 1)
+			default:
+				v[i] = uint32(nodeCount)
+			}
+		}
+		putNode(buf, recordSize, v)
+		cw.write(buf)
+	}
+	cw.write(make([]byte, dataSectionSeparatorSize))
+	cw.write(w.data)
+	cw.write(metadataStartMarker)
+
+	langs := make([]any, len(w.Languages))
+	for i, l := range w.Languages {
+		langs[i] = l
+	}
+	desc := map[string]any{}
+	for k, v := range w.Description {
+		desc[k] = v
+	}
+	meta, err := encode(nil, map[string]any{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+		"ip_version":                  uint16(6),
+		"database_type":               w.DatabaseType,
+		"languages":                   langs,
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 w.BuildEpoch,
+		"description":                 desc,
+	})
+	if err != nil {
+		return cw.n, err
+	}
+	cw.write(meta)
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+
+	return cw.n, cw.w.Flush()
+}
+
+// 節の２つのレコードをレコードサイズに応じて buf に格納する。
+func putNode(buf []byte, recordSize int, v [2]uint32) {
+	switch recordSize {
+	case 24:
+		buf[0], buf[1], buf[2] = byte(v[0]>>16), byte(v[0]>>8), byte(v[0])
+		buf[3], buf[4], buf[5] = byte(v[1]>>16), byte(v[1]>>8), byte(v[1])
+	case 28:
+		buf[0], buf[1], buf[2] = byte(v[0]>>16), byte(v[0]>>8), byte(v[0])
+		// 中央のバイトの上位４ビットが左、下位４ビットが右のレコードの最上位
+		buf[3] = byte(v[0]>>24)<<4 | byte(v[1]>>24)&0x0f
+		buf[4], buf[5], buf[6] = byte(v[1]>>16), byte(v[1]>>8), byte(v[1])
+	case 32:
+		binary.BigEndian.PutUint32(buf[0:], v[0])
+		binary.BigEndian.PutUint32(buf[4:], v[1])
+	}
+}
+
+// 書き込んだバイト数と最初の異常を記録する。
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) write(b []byte) {
+	if cw.err != nil {
+		return
+	}
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	cw.err = err
+}
+
+// 値をデータ部の形式でエンコードして buf に追加する。
+func encode(buf []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case string:
+		buf = appendControl(buf, typeString, len(v))
+		return append(buf, v...), nil
+	case []byte:
+		buf = appendControl(buf, typeBytes, len(v))
+		return append(buf, v...), nil
+	case bool:
+		n := 0
+		if v {
+			n = 1
+		}
+		return appendControl(buf, typeBool, n), nil
+	case uint16:
+		return appendUint(buf, typeUint16, uint64(v)), nil
+	case uint32:
+		return appendUint(buf, typeUint32, uint64(v)), nil
+	case uint64:
+		return appendUint(buf, typeUint64, v), nil
+	case float64:
+		buf = appendControl(buf, typeDouble, 8)
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(v)), nil
+	case []string:
+		buf = appendControl(buf, typeArray, len(v))
+		for _, e := range v {
+			buf = appendControl(buf, typeString, len(e))
+			buf = append(buf, e...)
+		}
+		return buf, nil
+	case []any:
+		buf = appendControl(buf, typeArray, len(v))
+		var err error
+		for _, e := range v {
+			if buf, err = encode(buf, e); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]string:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[k] = e
+		}
+		return encode(buf, m)
+	case map[string]any:
+		// 同じ内容が同じバイト列になるよう、キーの順に並べる。
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		buf = appendControl(buf, typeMap, len(v))
+		var err error
+		for _, k := range keys {
+			buf = appendControl(buf, typeString, len(k))
+			buf = append(buf, k...)
+			if buf, err = encode(buf, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedType, v)
+	}
+}
+
+// 符号なし整数を、先頭の 0 のバイトを除いてエンコードする。
+func appendUint(buf []byte, typ int, v uint64) []byte {
+	n := 0
+	for x := v; x > 0; x >>= 8 {
+		n++
+	}
+	buf = appendControl(buf, typ, n)
+	for i := n - 1; i >= 0; i-- {
+		buf = append(buf, byte(v>>(8*i)))
+	}
+
+	return buf
+}
+
+// 型とサイズを表す制御バイトを追加する。
+func appendControl(buf []byte, typ, size int) []byte {
+	var ctrl byte
+	if typ <= typeMap {
+		ctrl = byte(typ) << 5
+	}
+	var ext []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 29+256:
+		ctrl |= 29
+		ext = []byte{byte(size - 29)}
+	case size < 285+65536:
+		ctrl |= 30
+		s := size - 285
+		ext = []byte{byte(s >> 8), byte(s)}
+	default:
+		ctrl |= 31
+		s := size - 65821
+		ext = []byte{byte(s >> 16), byte(s >> 8), byte(s)}
+	}
+	buf = append(buf, ctrl)
+	if typ > typeMap {
+		buf = append(buf, byte(typ-7))
+	}
+
+	return append(buf, ext...)
+}
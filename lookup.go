@@ -0,0 +1,245 @@
+package ccipv4
+
+import (
+	"context"
+	"net/netip"
+	"time"
+)
+
+// Lookup の検索結果
+// SearchResult と異なり、アドレスを文字列に変換しない。
+type LookupResult struct {
+	Status Status
+	// 検索したアドレスが所属する割当ブロックの最初と最後のアドレス
+	Start netip.Addr
+	End   netip.Addr
+	// 割当ブロックを覆う最小の個数のプレフィックスのうち、
+	// 検索したアドレスを含むもの。 ipv6 の場合は割当ブロックのプレフィックス。
+	Prefix           netip.Prefix
+	Code             string
+	Registry         string
+	AllocationStatus string
+	AllocatedOn      time.Time
+	OpaqueID         string
+	// 上書きのデータベースで決まった場合は、その上書き。それ以外は nil 。
+	// 上書きで決まった場合、 Registry などの RIR のデータによる項目は空。
+	Override *Override
+}
+
+// 検索用データベースの検索に使うデータの参照
+// SwitchIPBData は各データを新しいものに置き換えるだけで変更しないので、
+// 参照を取得した時点の内容で、ロックせずに一貫した検索ができる。
+type ipbView struct {
+	ranges        []ipRange
+	attrs         []blockAttrs
+	data6         map[netip.Prefix]block
+	dicCCIntToStr map[uint16]string
+	dicCCStrToInt map[string]uint16
+	// 上書きのデータベースの内容。 view では設定しない。
+	overrides []Override
+}
+
+// 検索に使うデータの参照を返す。
+// 検索用データベースのロックは呼出元で行う。
+func (ib *ipBlocks) view() ipbView {
+	return ipbView{
+		ranges:        ib.ranges,
+		attrs:         ib.attrs,
+		data6:         ib.data6,
+		dicCCIntToStr: ib.dicCCIntToStr,
+		dicCCStrToInt: ib.dicCCStrToInt,
+	}
+}
+
+// 検索用データベースと上書きのデータベースをロックし、
+// 現時点の検索に使うデータの参照を返す。
+func (db *DB) snapshot() ipbView {
+	db.ib.l.RLock()
+	v := db.ib.view()
+	db.ib.l.RUnlock()
+
+	db.ov.l.RLock()
+	v.overrides = db.ov.data
+	db.ov.l.RUnlock()
+
+	return v
+}
+
+// uint32 に変換した IPv4 アドレスが含まれる ipv4 のブロックを返す。
+// 開始アドレスが x 以下で最大のブロックを所属ブロック候補とし、
+// その範囲に含まれない場合は false を返す。
+func (v ipbView) lookup4(x uint32) (ipRange, blockAttrs, bool) {
+	i := searchRange(v.ranges, x)
+	if i < 0 || v.ranges[i].end < x {
+		return ipRange{}, blockAttrs{}, false
+	}
+	r := v.ranges[i]
+
+	return r, v.attrs[r.attrs], true
+}
+
+// IPv6 アドレスが含まれる ipv6 のブロックのプレフィックスと内容を返す。
+// ipv6 のブロックはプレフィックスで格納しているので、
+// プレフィックス長の長い方から順に一致するものを検索する。
+func (v ipbView) lookup6(target netip.Addr) (netip.Prefix, block, bool) {
+	for bits := 128; bits >= 0; bits-- {
+		p, err := target.Prefix(bits)
+		if err != nil {
+			break
+		}
+		if b, ok := v.data6[p]; ok {
+			return p, b, true
+		}
+	}
+
+	return netip.Prefix{}, block{}, false
+}
+
+// Lookup の処理を行う。
+func (v ipbView) lookup(addr netip.Addr) LookupResult {
+	if !addr.IsValid() {
+		return LookupResult{Status: StatusInvalidAddress}
+	}
+	// IPv4 射影アドレスは IPv4 アドレスに、ゾーンは取り除く。
+	addr = addr.Unmap().WithZone("")
+	// SearchInfo と同じく、上書きを RIR のデータより優先する。
+	if ov, ok := findOverride(v.overrides, addr); ok {
+		return overrideLookupResult(ov)
+	}
+	if st, _ := classifyAddr(addr); st != StatusNotFound {
+		return LookupResult{Status: st}
+	}
+
+	if addr.Is6() {
+		p, b, ok := v.lookup6(addr)
+		if !ok {
+			return LookupResult{Status: StatusNotFound}
+		}
+		return LookupResult{
+			Status:           StatusFound,
+			Start:            p.Addr(),
+			End:              getLastAddrOfPrefix(p),
+			Prefix:           p,
+			Code:             v.dicCCIntToStr[b.country],
+			Registry:         b.registry,
+			AllocationStatus: b.status,
+			AllocatedOn:      dateToTime(b.date),
+			OpaqueID:         b.opaqueID,
+		}
+	}
+
+	x := addrToUint32(addr)
+	r, b, ok := v.lookup4(x)
+	if !ok {
+		return LookupResult{Status: StatusNotFound}
+	}
+	return LookupResult{
+		Status:           StatusFound,
+		Start:            uint32ToAddr(r.start),
+		End:              uint32ToAddr(r.end),
+		Prefix:           prefixContaining4(r.start, r.end, x),
+		Code:             v.dicCCIntToStr[b.country],
+		Registry:         b.registry,
+		AllocationStatus: b.status,
+		AllocatedOn:      dateToTime(b.date),
+		OpaqueID:         b.opaqueID,
+	}
+}
+
+// 上書きによる Lookup の検索結果を返す。
+// 上書きがない場合にメモリを割り当てないよう、別の関数とする。
+func overrideLookupResult(ov Override) LookupResult {
+	start, end := PrefixToRange(ov.Prefix)
+
+	return LookupResult{
+		Status:   StatusFound,
+		Start:    start,
+		End:      end,
+		Prefix:   ov.Prefix,
+		Code:     ov.Code,
+		Override: &ov,
+	}
+}
+
+// LookupCode の処理を行う。
+func (v ipbView) lookupCode(addr netip.Addr) string {
+	if !addr.IsValid() {
+		return ""
+	}
+	addr = addr.Unmap().WithZone("")
+	if ov, ok := findOverride(v.overrides, addr); ok {
+		return ov.Code
+	}
+	if st, _ := classifyAddr(addr); st != StatusNotFound {
+		return ""
+	}
+
+	if addr.Is6() {
+		if _, b, ok := v.lookup6(addr); ok {
+			return v.dicCCIntToStr[b.country]
+		}
+		return ""
+	}
+	if _, b, ok := v.lookup4(addrToUint32(addr)); ok {
+		return v.dicCCIntToStr[b.country]
+	}
+
+	return ""
+}
+
+// 渡された IP アドレスからカントリーコードの情報を返す。
+// SearchInfo と同じ方法で検索するが、文字列のパースと変換を行わない。
+// 国・地域の名称と Special-Purpose Address Registry の登録内容は含まない。
+func (db *DB) Lookup(addr netip.Addr) LookupResult {
+	return db.snapshot().lookup(addr)
+}
+
+// 渡された IP アドレスのカントリーコードを返す。
+// みつからない場合や、ループバックアドレスなど検索の対象でない場合は空文字列を返す。
+// メモリを割り当てないので、リクエストごとの検索などに使用する。
+func (db *DB) LookupCode(addr netip.Addr) string {
+	return db.snapshot().lookupCode(addr)
+}
+
+// 渡された IP アドレスを順に検索し、同じ順で結果を返す。
+// 全てのアドレスを同じ時点の検索用データベースで検索するので、
+// 途中で SwitchIPBData が実行されても結果は一貫している。
+func (db *DB) LookupBatch(addrs []netip.Addr) []LookupResult {
+	v := db.snapshot()
+	rs := make([]LookupResult, len(addrs))
+	for i, addr := range addrs {
+		rs[i] = v.lookup(addr)
+	}
+
+	return rs
+}
+
+// in から受け取った IP アドレスを順に検索し、同じ順で結果を返すチャネルを返す。
+// 全てのアドレスを呼出時点の検索用データベースで検索するので、
+// 途中で SwitchIPBData が実行されても結果は一貫している。
+// in が閉じられるか ctx がキャンセルされると、返したチャネルを閉じる。
+func (db *DB) LookupStream(ctx context.Context, in <-chan netip.Addr) <-chan LookupResult {
+	v := db.snapshot()
+	out := make(chan LookupResult)
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case addr, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case out <- v.lookup(addr):
+				}
+			}
+		}
+	}()
+
+	return out
+}
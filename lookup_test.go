@@ -0,0 +1,183 @@
+package ccipv4
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	db := GetDB()
+	err := db.setTmpIPBlocks(strings.NewReader(strings.Join([]string{
+		"apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated|A91A7381",
+		"apnic|CN|ipv4|124.147.128.0|32768|20060306|allocated",
+		"apnic|HK|ipv6|2001:df2:6180::|48|20191216|assigned",
+	}, "\n")), "")
+	if err != nil {
+		t.Fatalf("setTmpIPBlocks: but error: %v", err)
+	}
+	db.SwitchIPBData()
+
+	for _, tc := range []struct {
+		addr  netip.Addr
+		want  LookupResult
+		isStr bool
+	}{
+		{addr: netip.Addr{}, want: LookupResult{Status: StatusInvalidAddress}},
+		{addr: netip.MustParseAddr("127.0.0.1"), want: LookupResult{Status: StatusLoopback}},
+		{addr: netip.MustParseAddr("ff02::1"), want: LookupResult{Status: StatusMulticast}},
+		{addr: netip.MustParseAddr("192.168.0.1"), want: LookupResult{Status: StatusPrivate}},
+		{addr: netip.MustParseAddr("100.64.0.1"), want: LookupResult{Status: StatusReserved}},
+		{addr: netip.MustParseAddr("114.47.255.255"), want: LookupResult{Status: StatusNotFound}},
+		{addr: netip.MustParseAddr("2001:df2:6181::1"), want: LookupResult{Status: StatusNotFound}},
+		{
+			addr: netip.MustParseAddr("114.51.255.255"),
+			want: LookupResult{
+				Status:           StatusFound,
+				Start:            netip.MustParseAddr("114.48.0.0"),
+				End:              netip.MustParseAddr("114.51.255.255"),
+				Prefix:           netip.MustParsePrefix("114.48.0.0/14"),
+				Code:             "JP",
+				Registry:         "apnic",
+				AllocationStatus: "allocated",
+				AllocatedOn:      dateToTime(20080422),
+				OpaqueID:         "A91A7381",
+			},
+		},
+		{
+			addr: netip.MustParseAddr("::ffff:124.147.128.1"),
+			want: LookupResult{
+				Status:           StatusFound,
+				Start:            netip.MustParseAddr("124.147.128.0"),
+				End:              netip.MustParseAddr("124.147.255.255"),
+				Prefix:           netip.MustParsePrefix("124.147.128.0/17"),
+				Code:             "CN",
+				Registry:         "apnic",
+				AllocationStatus: "allocated",
+				AllocatedOn:      dateToTime(20060306),
+			},
+		},
+		{
+			addr: netip.MustParseAddr("2001:df2:6180::1%eth0"),
+			want: LookupResult{
+				Status:           StatusFound,
+				Start:            netip.MustParseAddr("2001:df2:6180::"),
+				End:              netip.MustParseAddr("2001:df2:6180:ffff:ffff:ffff:ffff:ffff"),
+				Prefix:           netip.MustParsePrefix("2001:df2:6180::/48"),
+				Code:             "HK",
+				Registry:         "apnic",
+				AllocationStatus: "assigned",
+				AllocatedOn:      dateToTime(20191216),
+			},
+		},
+	} {
+		got := db.Lookup(tc.addr)
+		if got != tc.want {
+			t.Errorf("Lookup: %v, want %v, but %v", tc.addr, tc.want, got)
+		}
+		if code := db.LookupCode(tc.addr); code != tc.want.Code {
+			t.Errorf("LookupCode: %v, want %s, but %s", tc.addr, tc.want.Code, code)
+		}
+		// SearchInfo と同じ結果
+		if tc.addr.IsValid() {
+			sr := db.SearchInfo(tc.addr.String())
+			if sr.Status != got.Status || sr.Code != got.Code {
+				t.Errorf("Lookup: %v, differs from SearchInfo: %v, %v", tc.addr, got, sr)
+			}
+			if got.Status == StatusFound && (sr.BlockStart != got.Start.String() || sr.BlockEnd != got.End.String()) {
+				t.Errorf("Lookup: %v, block differs from SearchInfo: %v, %v", tc.addr, got, sr)
+			}
+		}
+	}
+}
+
+func TestLookupCodeAllocs(t *testing.T) {
+	db := GetDB()
+	if err := db.LoadIPBDataByFile("testdata/validIPBlockFile-1"); err != nil {
+		t.Fatalf("LoadIPBDataByFile: but error: %v", err)
+	}
+	db.SwitchIPBData()
+
+	for _, s := range []string{"114.48.0.1", "1.1.1.1", "10.0.0.1", "2001:df2:6180::1"} {
+		addr := netip.MustParseAddr(s)
+		if n := testing.AllocsPerRun(100, func() { db.LookupCode(addr) }); n != 0 {
+			t.Errorf("LookupCode: %s, allocs want 0, but %v", s, n)
+		}
+		if n := testing.AllocsPerRun(100, func() { db.Lookup(addr) }); n != 0 {
+			t.Errorf("Lookup: %s, allocs want 0, but %v", s, n)
+		}
+	}
+}
+
+func TestLookupBatch(t *testing.T) {
+	db := GetDB()
+	if err := db.LoadIPBDataByFile("testdata/validIPBlockFile-1"); err != nil {
+		t.Fatalf("LoadIPBDataByFile: but error: %v", err)
+	}
+	db.SwitchIPBData()
+
+	addrs := []netip.Addr{
+		netip.MustParseAddr("114.48.0.1"),
+		{},
+		netip.MustParseAddr("1.1.1.1"),
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("2001:df2:6180::1"),
+	}
+	rs := db.LookupBatch(addrs)
+	if len(rs) != len(addrs) {
+		t.Fatalf("LookupBatch: length want %d, but %d", len(addrs), len(rs))
+	}
+	for i, addr := range addrs {
+		if want := db.Lookup(addr); rs[i] != want {
+			t.Errorf("LookupBatch: %v, want %v, but %v", addr, want, rs[i])
+		}
+	}
+	if rs := db.LookupBatch(nil); len(rs) != 0 {
+		t.Errorf("LookupBatch: nil, but %v", rs)
+	}
+}
+
+func TestLookupStream(t *testing.T) {
+	db := GetDB()
+	if err := db.setTmpIPBlocks(strings.NewReader("apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated\n"), ""); err != nil {
+		t.Fatalf("setTmpIPBlocks: but error: %v", err)
+	}
+	db.SwitchIPBData()
+
+	in := make(chan netip.Addr)
+	out := db.LookupStream(context.Background(), in)
+
+	addr := netip.MustParseAddr("114.48.0.1")
+	in <- addr
+	if r := <-out; r.Code != "JP" {
+		t.Errorf("LookupStream: want JP, but %v", r)
+	}
+
+	// 途中で検索用データベースが切り替わっても、呼出時点のデータで検索する。
+	if err := db.setTmpIPBlocks(strings.NewReader("apnic|CN|ipv4|114.48.0.0|262144|20080422|allocated\n"), ""); err != nil {
+		t.Fatalf("setTmpIPBlocks: but error: %v", err)
+	}
+	db.SwitchIPBData()
+	if code := db.LookupCode(addr); code != "CN" {
+		t.Errorf("LookupCode: after switch, want CN, but %s", code)
+	}
+	in <- addr
+	if r := <-out; r.Code != "JP" {
+		t.Errorf("LookupStream: after switch, want JP, but %v", r)
+	}
+
+	// in を閉じると out も閉じる。
+	close(in)
+	if r, ok := <-out; ok {
+		t.Errorf("LookupStream: in is closed, but %v", r)
+	}
+
+	// キャンセル
+	ctx, cancel := context.WithCancel(context.Background())
+	out = db.LookupStream(ctx, make(chan netip.Addr))
+	cancel()
+	if r, ok := <-out; ok {
+		t.Errorf("LookupStream: canceled, but %v", r)
+	}
+}
@@ -0,0 +1,197 @@
+package ccipv4
+
+import (
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/suka-test/ccipv4/internal/mmdb"
+)
+
+// WriteMMDB で作成するファイルの database_type
+const MMDBDatabaseType = "ccipv4-Country"
+
+// 検索用データベースの割当ブロックを、 MaxMind DB （ MMDB ）形式で w に書き込む。
+// 各割当ブロックのデータは GeoIP2 Country と同様の形式で、
+// country の iso_code と names （ en に Name 、 ja に AltName ）、
+// および registry （割り当てた RIR ）を格納する。
+// names はカントリーコード一覧のデータベースに登録がある場合のみ格納する。
+// カントリーコードが空の割当ブロックは含めない。
+func (db *DB) WriteMMDB(w io.Writer) error {
+	v := db.snapshot()
+	db.cc.l.RLock()
+	cc := db.cc.data
+	db.cc.l.RUnlock()
+
+	mw := mmdb.NewWriter(MMDBDatabaseType)
+	mw.Languages = []string{"en", "ja"}
+	mw.Description = map[string]string{
+		"en": "Country database built from RIR statistics exchange format files by ccipv4",
+		"ja": "ccipv4 で RIR statistics exchange format のファイルから作成したカントリーコードのデータベース",
+	}
+	mw.BuildEpoch = uint64(time.Now().Unix())
+
+	record := func(a blockAttrs) (map[string]any, bool) {
+		code := v.dicCCIntToStr[a.country]
+		if code == "" {
+			return nil, false
+		}
+		country := map[string]any{"iso_code": code}
+		if info, ok := cc[code]; ok {
+			names := map[string]any{}
+			if info.Name != "" {
+				names["en"] = info.Name
+			}
+			if info.AltName != "" {
+				names["ja"] = info.AltName
+			}
+			if len(names) > 0 {
+				country["names"] = names
+			}
+		}
+		return map[string]any{"country": country, "registry": a.registry}, true
+	}
+
+	for _, r := range v.ranges {
+		rec, ok := record(v.attrs[r.attrs])
+		if !ok {
+			continue
+		}
+		for _, p := range rangeToPrefixes4(r.start, r.end) {
+			if err := mw.Insert(p, rec); err != nil {
+				return err
+			}
+		}
+	}
+	// 重なる場合に狭いプレフィックスが優先するよう、プレフィックス長の短い順に追加する。
+	ps := make([]netip.Prefix, 0, len(v.data6))
+	for p := range v.data6 {
+		ps = append(ps, p)
+	}
+	slices.SortFunc(ps, func(a, b netip.Prefix) int {
+		if a.Bits() != b.Bits() {
+			return a.Bits() - b.Bits()
+		}
+		return a.Addr().Compare(b.Addr())
+	})
+	for _, p := range ps {
+		rec, ok := record(v.data6[p].blockAttrs)
+		if !ok {
+			continue
+		}
+		if err := mw.Insert(p, rec); err != nil {
+			return err
+		}
+	}
+
+	_, err := mw.WriteTo(w)
+
+	return err
+}
+
+// 指定の MaxMind DB （ MMDB ）形式のファイルの IPv4 のネットワークを読み込み、
+// 一時保存用データベースに格納する。
+// 各ネットワークのカントリーコードは country の iso_code 、
+// ない場合は registered_country の iso_code とし、どちらもない場合は読み込まない。
+// WriteMMDB で作成したファイルの場合は registry も読み込む。
+// 同じカントリーコードと registry の連続するネットワークは一つの割当ブロックにまとめる。
+// 異常が発生した場合は一時保存用データベースを空にしてエラーを返す。
+func (db *DB) LoadIPBDataByMMDB(mmdbFile string) error {
+	b, err := os.ReadFile(mmdbFile)
+	if err != nil {
+		return err
+	}
+	r, err := mmdb.NewReader(b)
+	if err != nil {
+		return err
+	}
+
+	db.tmpIB.l.Lock()
+	defer db.tmpIB.l.Unlock()
+
+	header := newSourceHeader(mmdbFile)
+	// 割当ブロックを格納する。
+	store := func(blk mmdbBlock) error {
+		line := []string{blk.registry, blk.code, "ipv4", uint32ToAddr(blk.start).String(), strconv.FormatUint(uint64(blk.end-blk.start)+1, 10), "", ""}
+		header.Counts["ipv4"]++
+		if pe := db.setTmpIPBlock(line); pe != nil {
+			pe.Source = mmdbFile
+			pe.Record = line
+			return pe
+		}
+		return nil
+	}
+
+	// 同じデータは何度も現れるので、デコードした結果を記録する。
+	decoded := map[uint32]mmdbBlock{}
+	var (
+		cur   mmdbBlock
+		found bool
+	)
+	err = r.Networks4(func(p netip.Prefix, record uint32) error {
+		d, ok := decoded[record]
+		if !ok {
+			v, err := r.Data(record)
+			if err != nil {
+				return err
+			}
+			d = mmdbRecord(v)
+			decoded[record] = d
+		}
+		if d.code == "" {
+			return nil
+		}
+		start := addrToUint32(p.Addr())
+		end := start + uint32(uint64(1)<<(32-p.Bits())-1)
+		// 直前の割当ブロックに連続する場合はまとめる。
+		if found && cur.code == d.code && cur.registry == d.registry && uint64(cur.end)+1 == uint64(start) {
+			cur.end = end
+			return nil
+		}
+		if found {
+			if err := store(cur); err != nil {
+				return err
+			}
+		}
+		cur = mmdbBlock{code: d.code, registry: d.registry, start: start, end: end}
+		found = true
+		return nil
+	})
+	if err == nil && found {
+		err = store(cur)
+	}
+	if err != nil {
+		db.ClearTmpIPBData()
+		return err
+	}
+	db.tmpIB.addSource(header)
+
+	return nil
+}
+
+// MaxMind DB から読み込む割当ブロック
+type mmdbBlock struct {
+	code     string
+	registry string
+	start    uint32
+	end      uint32
+}
+
+// MaxMind DB のデータからカントリーコードと registry を取り出す。
+func mmdbRecord(v any) mmdbBlock {
+	m, _ := v.(map[string]any)
+	var blk mmdbBlock
+	for _, key := range []string{"country", "registered_country"} {
+		c, _ := m[key].(map[string]any)
+		if code, _ := c["iso_code"].(string); code != "" {
+			blk.code = code
+			break
+		}
+	}
+	blk.registry, _ = m["registry"].(string)
+
+	return blk
+}
@@ -0,0 +1,245 @@
+package ccipv4
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/suka-test/ccipv4/internal/mmdb"
+)
+
+func TestWriteMMDB(t *testing.T) {
+	db := GetDB()
+	err := db.setTmpIPBlocks(strings.NewReader(strings.Join([]string{
+		"apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated",
+		"apnic|JP|ipv4|1.0.0.0|768|20110811|assigned",
+		"arin|US|ipv4|6.0.0.0|16777216|19940201|allocated",
+		"apnic||ipv4|1.0.4.0|256||reserved",
+		"apnic|HK|ipv6|2001:df2:6180::|48|20191216|assigned",
+		"apnic|JP|asn|173|1|20020801|allocated",
+	}, "\n")), "")
+	if err != nil {
+		t.Fatalf("setTmpIPBlocks: but error: %v", err)
+	}
+	db.SwitchIPBData()
+	if err := db.SetTmpCountryCodes(strings.NewReader("JP|Japan|日本\nHK|Hong Kong|\n")); err != nil {
+		t.Fatalf("SetTmpCountryCodes: but error: %v", err)
+	}
+	db.SwitchCCData()
+
+	var buf bytes.Buffer
+	if err := db.WriteMMDB(&buf); err != nil {
+		t.Fatalf("WriteMMDB: but error: %v", err)
+	}
+
+	// 書き込んだファイルを読み込んで確認する。
+	r, err := mmdb.NewReader(buf.Bytes())
+	if err != nil {
+		t.Fatalf("NewReader: but error: %v", err)
+	}
+	if r.Metadata.DatabaseType != MMDBDatabaseType || r.Metadata.IPVersion != 6 || r.Metadata.BuildEpoch == 0 {
+		t.Errorf("WriteMMDB: invalid metadata: %+v", r.Metadata)
+	}
+
+	jp := map[string]any{
+		"country": map[string]any{
+			"iso_code": "JP",
+			"names":    map[string]any{"en": "Japan", "ja": "日本"},
+		},
+		"registry": "apnic",
+	}
+	for _, tc := range []struct {
+		addr   string
+		want   any
+		prefix string
+	}{
+		{addr: "114.48.0.0", want: jp, prefix: "114.48.0.0/14"},
+		{addr: "1.0.0.1", want: jp, prefix: "1.0.0.0/23"},
+		{addr: "1.0.2.255", want: jp, prefix: "1.0.2.0/24"},
+		// カントリーコード一覧のデータベースに登録がない
+		{addr: "6.1.2.3", want: map[string]any{"country": map[string]any{"iso_code": "US"}, "registry": "arin"}, prefix: "6.0.0.0/8"},
+		{
+			addr:   "2001:df2:6180::1",
+			want:   map[string]any{"country": map[string]any{"iso_code": "HK", "names": map[string]any{"en": "Hong Kong"}}, "registry": "apnic"},
+			prefix: "2001:df2:6180::/48",
+		},
+		// カントリーコードが空の割当ブロックは含めない。
+		{addr: "1.0.4.1"},
+		{addr: "114.52.0.0"},
+	} {
+		v, p, ok, err := r.Lookup(netip.MustParseAddr(tc.addr))
+		if err != nil {
+			t.Errorf("WriteMMDB: %s, but error: %v", tc.addr, err)
+			continue
+		}
+		if ok != (tc.want != nil) || !reflect.DeepEqual(v, tc.want) {
+			t.Errorf("WriteMMDB: %s, want %v, but %v", tc.addr, tc.want, v)
+		}
+		if tc.prefix != "" && p != netip.MustParsePrefix(tc.prefix) {
+			t.Errorf("WriteMMDB: %s, prefix want %s, but %s", tc.addr, tc.prefix, p)
+		}
+		// 書き込んだファイルと検索用データベースのカントリーコードが一致する。
+		if ok {
+			code := v.(map[string]any)["country"].(map[string]any)["iso_code"]
+			if want := db.LookupCode(netip.MustParseAddr(tc.addr)); code != want {
+				t.Errorf("WriteMMDB: %s, code differs from LookupCode: %v, %s", tc.addr, code, want)
+			}
+		}
+	}
+}
+
+func TestWriteMMDBFile(t *testing.T) {
+	// testdata の delegation file の全てのブロックを検索できる。
+	db := GetDB()
+	if err := db.LoadIPBDataByFile("testdata/validIPBlockFile-1"); err != nil {
+		t.Fatalf("LoadIPBDataByFile: but error: %v", err)
+	}
+	db.SwitchIPBData()
+
+	var buf bytes.Buffer
+	if err := db.WriteMMDB(&buf); err != nil {
+		t.Fatalf("WriteMMDB: but error: %v", err)
+	}
+	r, err := mmdb.NewReader(buf.Bytes())
+	if err != nil {
+		t.Fatalf("NewReader: but error: %v", err)
+	}
+	for _, cc := range db.CountryCodes() {
+		for _, b := range db.BlocksByCountry(cc) {
+			for _, a := range []netip.Addr{b.Start, b.End} {
+				v, _, ok, err := r.Lookup(a)
+				if err != nil || !ok {
+					t.Errorf("WriteMMDB: %s, not found: %v", a, err)
+					continue
+				}
+				if code := v.(map[string]any)["country"].(map[string]any)["iso_code"]; code != cc {
+					t.Errorf("WriteMMDB: %s, want %s, but %v", a, cc, code)
+				}
+			}
+		}
+	}
+}
+
+func TestLoadIPBDataByMMDB(t *testing.T) {
+	// WriteMMDB で作成したファイルを読み込む。
+	src := GetDB()
+	err := src.setTmpIPBlocks(strings.NewReader(strings.Join([]string{
+		"apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated",
+		"apnic|JP|ipv4|1.0.0.0|768|20110811|assigned",
+		"ripencc|JP|ipv4|1.0.3.0|256|20110811|assigned",
+		"arin|US|ipv4|6.0.0.0|16777216|19940201|allocated",
+		"apnic|HK|ipv6|2001:df2:6180::|48|20191216|assigned",
+	}, "\n")), "")
+	if err != nil {
+		t.Fatalf("setTmpIPBlocks: but error: %v", err)
+	}
+	src.SwitchIPBData()
+	var buf bytes.Buffer
+	if err := src.WriteMMDB(&buf); err != nil {
+		t.Fatalf("WriteMMDB: but error: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	db := GetDB()
+	if err := db.LoadIPBDataByMMDB(path); err != nil {
+		t.Fatalf("LoadIPBDataByMMDB: but error: %v", err)
+	}
+	db.SwitchIPBData()
+
+	for _, tc := range []struct {
+		addr     string
+		code     string
+		registry string
+		start    string
+		end      string
+	}{
+		// 連続するネットワークは一つの割当ブロックにまとめる。
+		{addr: "1.0.1.1", code: "JP", registry: "apnic", start: "1.0.0.0", end: "1.0.2.255"},
+		// registry が異なる場合はまとめない。
+		{addr: "1.0.3.1", code: "JP", registry: "ripencc", start: "1.0.3.0", end: "1.0.3.255"},
+		{addr: "6.1.2.3", code: "US", registry: "arin", start: "6.0.0.0", end: "6.255.255.255"},
+		{addr: "114.51.255.255", code: "JP", registry: "apnic", start: "114.48.0.0", end: "114.51.255.255"},
+		{addr: "114.52.0.0"},
+		// IPv6 は読み込まない。
+		{addr: "2001:df2:6180::1"},
+	} {
+		r := db.SearchInfo(tc.addr)
+		if tc.code == "" {
+			if r.Status != StatusNotFound {
+				t.Errorf("SearchInfo: %s, want not found, but %v", tc.addr, r)
+			}
+			continue
+		}
+		if r.Status != StatusFound || r.Code != tc.code || r.Registry != tc.registry || r.BlockStart != tc.start || r.BlockEnd != tc.end {
+			t.Errorf("SearchInfo: %s, want %s %s %s-%s, but %v", tc.addr, tc.code, tc.registry, tc.start, tc.end, r)
+		}
+	}
+	if tb := db.GetTotalBlocks(); tb["ALL"] != 4 || tb["JP"] != 3 {
+		t.Errorf("GetTotalBlocks: want ALL 4, JP 3, but %v", tb)
+	}
+	if hs := db.GetSourceHeaders(); len(hs) != 1 || hs[0].Source != path || hs[0].Counts["ipv4"] != 4 {
+		t.Errorf("GetSourceHeaders: invalid headers: %v", hs)
+	}
+}
+
+func TestLoadIPBDataByMMDBOtherVendor(t *testing.T) {
+	// country がない場合は registered_country のカントリーコードとする。
+	w := mmdb.NewWriter("GeoLite2-Country")
+	for _, tc := range []struct {
+		prefix string
+		v      any
+	}{
+		{prefix: "1.0.0.0/24", v: map[string]any{"country": map[string]any{"iso_code": "AU"}, "registered_country": map[string]any{"iso_code": "JP"}}},
+		{prefix: "1.0.1.0/24", v: map[string]any{"registered_country": map[string]any{"iso_code": "AU"}}},
+		{prefix: "1.0.2.0/24", v: map[string]any{"continent": map[string]any{"code": "OC"}}},
+		{prefix: "1.0.4.0/22", v: "unknown"},
+	} {
+		if err := w.Insert(netip.MustParsePrefix(tc.prefix), tc.v); err != nil {
+			t.Fatalf("Insert: %s, but error: %v", tc.prefix, err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: but error: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	db := GetDB()
+	if err := db.LoadIPBDataByMMDB(path); err != nil {
+		t.Fatalf("LoadIPBDataByMMDB: but error: %v", err)
+	}
+	db.SwitchIPBData()
+	if r := db.SearchInfo("1.0.1.255"); r.Code != "AU" || r.BlockStart != "1.0.0.0" || r.BlockEnd != "1.0.1.255" || r.Registry != "" {
+		t.Errorf("SearchInfo: want AU 1.0.0.0-1.0.1.255, but %v", r)
+	}
+	for _, a := range []string{"1.0.2.1", "1.0.4.1"} {
+		if r := db.SearchInfo(a); r.Status != StatusNotFound {
+			t.Errorf("SearchInfo: %s, want not found, but %v", a, r)
+		}
+	}
+}
+
+func TestLoadIPBDataByMMDBError(t *testing.T) {
+	db := GetDB()
+	if err := db.LoadIPBDataByMMDB("testdata/notExist.mmdb"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadIPBDataByMMDB: file not found, want error %v, but %v", os.ErrNotExist, err)
+	}
+	// RIR statistics exchange format のファイル
+	if err := db.LoadIPBDataByMMDB("testdata/validIPBlockFile-1"); !errors.Is(err, ErrInvalidMMDB) {
+		t.Errorf("LoadIPBDataByMMDB: not mmdb, want error %v, but %v", ErrInvalidMMDB, err)
+	}
+	if !db.IsDBEmpty() || len(db.tmpIB.data) != 0 {
+		t.Error("LoadIPBDataByMMDB: db is not empty")
+	}
+}
@@ -0,0 +1,181 @@
+package ccipv4
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// RIR のデータより優先するカントリーコードの上書き
+type Override struct {
+	Prefix netip.Prefix
+	Code   string
+	// 上書きの理由などのコメント。ない場合は空文字列。
+	Comment string
+	// 読み込んだファイルのパス。不明な場合は空文字列。
+	Source string
+	// 行番号。不明な場合は 0 。
+	Line int
+}
+
+// 上書きのデータベース
+// data はプレフィックス長の長い順に並べる。
+// 読込時は data を新しいものに置き換えるだけで変更しないので、
+// snapshot で取得した参照はロックせずに使える。
+type overrides struct {
+	l    sync.RWMutex
+	data []Override
+}
+
+// 指定のファイルを読んで上書きのデータを取得し、
+// 上書きのデータベースを置き換える。
+// 上書きのデータベースは検索用データベースとは別に保持するので、
+// SwitchIPBData や SetIPBData で検索用データベースを更新しても残る。
+func (db *DB) LoadOverridesByFile(overrideFile string) error {
+	fp, err := os.Open(overrideFile)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	return db.SetOverrides(fp, overrideFile)
+}
+
+// r から上書きのデータを読み込み、上書きのデータベースを置き換える。
+// 各行は "CIDR|カントリーコード" または "CIDR|カントリーコード|コメント" の形式。
+// # で始まる行と空行は読み込まない。
+// source は ParseError と Override に記録する読込元で、不明な場合は空文字列。
+// 異常がある場合はエラーを返し、上書きのデータベースは変更しない。
+func (db *DB) SetOverrides(r io.Reader, source string) error {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.Comma = '|'
+	reader.FieldsPerRecord = -1
+
+	var data []Override
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var pe *ParseError
+		if err != nil {
+			pe = newParseError(-1, ErrMalformedRecord, err)
+		} else {
+			var ov Override
+			ov, pe = db.parseOverride(line)
+			if pe == nil {
+				ov.Source = source
+				ov.Line = recordLine(reader, line, nil)
+				data = append(data, ov)
+				continue
+			}
+		}
+		pe.Source = source
+		pe.Line = recordLine(reader, line, err)
+		pe.Record = line
+		return pe
+	}
+	// 重なる場合は狭いプレフィックスが優先するよう、プレフィックス長の長い順に並べる。
+	// 同じプレフィックスは後の行が優先する。
+	slices.Reverse(data)
+	slices.SortStableFunc(data, func(a, b Override) int {
+		return b.Prefix.Bits() - a.Prefix.Bits()
+	})
+
+	db.ov.l.Lock()
+	db.ov.data = data
+	db.ov.l.Unlock()
+
+	return nil
+}
+
+// 上書きのデータの１行を Override に変換する。
+func (db *DB) parseOverride(line []string) (Override, *ParseError) {
+	if len(line) != 2 && len(line) != 3 {
+		return Override{}, newParseError(-1, ErrWrongNumberOfFields, fmt.Errorf("%d fields", len(line)))
+	}
+	p, err := netip.ParsePrefix(strings.TrimSpace(line[0]))
+	if err != nil {
+		return Override{}, newParseError(0, ErrInvalidIPAddress, err)
+	}
+	// IPv4 射影アドレスのプレフィックスは IPv4 のプレフィックスとする。
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	code := strings.TrimSpace(line[1])
+	if !db.reg.MatchString(code) {
+		return Override{}, newParseError(1, ErrInvalidCountryCode, fmt.Errorf("%s", code))
+	}
+	ov := Override{Prefix: p.Masked(), Code: code}
+	if len(line) == 3 {
+		ov.Comment = strings.TrimSpace(line[2])
+	}
+
+	return ov, nil
+}
+
+// 上書きのデータベースの内容を、優先する順に返す。
+func (db *DB) GetOverrides() []Override {
+	db.ov.l.RLock()
+	defer db.ov.l.RUnlock()
+
+	return slices.Clone(db.ov.data)
+}
+
+// 上書きのデータベースを空にする。
+func (db *DB) ClearOverrides() {
+	db.ov.l.Lock()
+	db.ov.data = nil
+	db.ov.l.Unlock()
+}
+
+// target を含む上書きのうち、最も狭いプレフィックスのものを返す。
+// target は IPv4 射影アドレスでなく、ゾーンのないものであること。
+func (db *DB) lookupOverride(target netip.Addr) (Override, bool) {
+	db.ov.l.RLock()
+	defer db.ov.l.RUnlock()
+
+	return findOverride(db.ov.data, target)
+}
+
+// 優先する順に並べた上書きの一覧から、 target を含む最初のものを返す。
+func findOverride(ovs []Override, target netip.Addr) (Override, bool) {
+	for _, ov := range ovs {
+		if ov.Prefix.Contains(target) {
+			return ov, true
+		}
+	}
+
+	return Override{}, false
+}
+
+// 上書きによる検索結果を返す。
+func (db *DB) overrideResult(ov Override, special *SpecialPurpose) SearchResult {
+	start, end := PrefixToRange(ov.Prefix)
+	sr := SearchResult{
+		IsFound:        true,
+		Status:         StatusFound,
+		Message:        StatusFound.String(),
+		SpecialPurpose: special,
+		BlockStart:     start.String(),
+		BlockEnd:       end.String(),
+		Prefixes:       []netip.Prefix{ov.Prefix},
+		Prefix:         ov.Prefix,
+		Code:           ov.Code,
+		Override:       &ov,
+	}
+	db.cc.l.RLock()
+	defer db.cc.l.RUnlock()
+	if info, ok := db.cc.data[sr.Code]; ok {
+		sr.Name = info.Name
+		sr.AltName = info.AltName
+	}
+
+	return sr
+}
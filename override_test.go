@@ -0,0 +1,222 @@
+package ccipv4
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetOverrides(t *testing.T) {
+	db := GetDB()
+	err := db.setTmpIPBlocks(strings.NewReader(strings.Join([]string{
+		"apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated",
+		"apnic|HK|ipv6|2001:df2:6180::|48|20191216|assigned",
+	}, "\n")), "")
+	if err != nil {
+		t.Fatalf("setTmpIPBlocks: but error: %v", err)
+	}
+	db.SwitchIPBData()
+	if err := db.SetTmpCountryCodes(strings.NewReader("JP|Japan|日本\nUS|United States of America|アメリカ合衆国\n")); err != nil {
+		t.Fatalf("SetTmpCountryCodes: but error: %v", err)
+	}
+	db.SwitchCCData()
+
+	err = db.SetOverrides(strings.NewReader(strings.Join([]string{
+		"# 自社と提携先のネットワーク",
+		"114.49.0.0/16|US|partner office",
+		"114.49.2.0/24|SG",
+		"",
+		"114.49.2.0/24|DE|later line wins",
+		"2001:df2:6180:1::/64|JP|",
+		"::ffff:10.1.0.0/112|JP|private network",
+	}, "\n")), "overrides.txt")
+	if err != nil {
+		t.Fatalf("SetOverrides: but error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		addr     string
+		code     string
+		prefix   string
+		comment  string
+		line     int
+		registry string
+	}{
+		{addr: "114.48.0.1", code: "JP", prefix: "114.48.0.0/14", registry: "apnic"},
+		{addr: "114.49.0.1", code: "US", prefix: "114.49.0.0/16", comment: "partner office", line: 2},
+		// 重なる場合は狭いプレフィックス、同じプレフィックスは後の行が優先する。
+		{addr: "114.49.2.1", code: "DE", prefix: "114.49.2.0/24", comment: "later line wins", line: 5},
+		{addr: "2001:df2:6180:1::1", code: "JP", prefix: "2001:df2:6180:1::/64", line: 6},
+		{addr: "2001:df2:6180:2::1", prefix: "2001:df2:6180::/48", registry: "apnic"},
+		// プライベートアドレスにも指定できる。
+		{addr: "::ffff:10.1.2.3", code: "JP", prefix: "10.1.0.0/16", comment: "private network", line: 7},
+	} {
+		r := db.SearchInfo(tc.addr)
+		if r.Status != StatusFound || r.Prefix != netip.MustParsePrefix(tc.prefix) || r.Registry != tc.registry {
+			t.Errorf("SearchInfo: %s, want %s %s, but %v", tc.addr, tc.prefix, tc.registry, r)
+			continue
+		}
+		if tc.line == 0 {
+			if r.Override != nil {
+				t.Errorf("SearchInfo: %s, want no override, but %v", tc.addr, r.Override)
+			}
+			continue
+		}
+		ov := r.Override
+		if ov == nil || r.Code != tc.code || ov.Code != tc.code || ov.Comment != tc.comment || ov.Source != "overrides.txt" || ov.Line != tc.line {
+			t.Errorf("SearchInfo: %s, want override %s %q line %d, but %v %v", tc.addr, tc.code, tc.comment, tc.line, r.Code, ov)
+		}
+	}
+	r := db.SearchInfo("114.49.0.1")
+	if r.Name != "United States of America" || r.AltName != "アメリカ合衆国" || r.BlockStart != "114.49.0.0" || r.BlockEnd != "114.49.255.255" {
+		t.Errorf("SearchInfo: 114.49.0.1, invalid result: %v", r)
+	}
+	if r := db.SearchInfo("10.1.2.3"); r.SpecialPurpose == nil {
+		t.Errorf("SearchInfo: 10.1.2.3, want special purpose, but %v", r)
+	}
+
+	// 検索用データベースを更新しても上書きは残る。
+	if err := db.setTmpIPBlocks(strings.NewReader("arin|CA|ipv4|114.48.0.0|262144|20240101|allocated\n"), ""); err != nil {
+		t.Fatalf("setTmpIPBlocks: but error: %v", err)
+	}
+	db.SwitchIPBData()
+	if r := db.SearchInfo("114.49.0.1"); r.Code != "US" || r.Override == nil {
+		t.Errorf("SearchInfo: after SwitchIPBData, want override US, but %v", r)
+	}
+	if r := db.SearchInfo("114.48.0.1"); r.Code != "CA" || r.Override != nil {
+		t.Errorf("SearchInfo: after SwitchIPBData, want CA, but %v", r)
+	}
+
+	// 優先する順に返す。
+	ovs := db.GetOverrides()
+	var got []string
+	for _, ov := range ovs {
+		got = append(got, ov.Prefix.String()+" "+ov.Code)
+	}
+	if want := "2001:df2:6180:1::/64 JP,114.49.2.0/24 DE,114.49.2.0/24 SG,10.1.0.0/16 JP,114.49.0.0/16 US"; strings.Join(got, ",") != want {
+		t.Errorf("GetOverrides: want %s, but %s", want, strings.Join(got, ","))
+	}
+
+	db.ClearOverrides()
+	if r := db.SearchInfo("114.49.0.1"); r.Code != "CA" || r.Override != nil {
+		t.Errorf("SearchInfo: after ClearOverrides, want CA, but %v", r)
+	}
+	if len(db.GetOverrides()) != 0 {
+		t.Errorf("GetOverrides: after ClearOverrides, length want 0, but %d", len(db.GetOverrides()))
+	}
+}
+
+func TestSetOverridesError(t *testing.T) {
+	for _, tc := range []struct {
+		in    string
+		field int
+		kind  error
+	}{
+		{in: "114.49.0.0/16", field: -1, kind: ErrWrongNumberOfFields},
+		{in: "114.49.0.0/16|US|a|b", field: -1, kind: ErrWrongNumberOfFields},
+		{in: "114.49.0.0|US", field: 0, kind: ErrInvalidIPAddress},
+		{in: "114.49.0.0/16|us", field: 1, kind: ErrInvalidCountryCode},
+		{in: `114.49.0.0/16|US|"a`, field: -1, kind: ErrMalformedRecord},
+	} {
+		db := GetDB()
+		if err := db.SetOverrides(strings.NewReader("1.0.0.0/24|JP\n"), ""); err != nil {
+			t.Fatalf("SetOverrides: but error: %v", err)
+		}
+		err := db.SetOverrides(strings.NewReader("114.48.0.0/16|JP\n"+tc.in+"\n"), "overrides.txt")
+		var pe *ParseError
+		if !errors.As(err, &pe) || !errors.Is(err, tc.kind) {
+			t.Errorf("SetOverrides: %s, want error %v, but %v", tc.in, tc.kind, err)
+			continue
+		}
+		if pe.Source != "overrides.txt" || pe.Line != 2 || pe.Field != tc.field {
+			t.Errorf("SetOverrides: %s, want line 2 field %d, but %v (field %d)", tc.in, tc.field, pe, pe.Field)
+		}
+		// 異常がある場合は変更しない。
+		if ovs := db.GetOverrides(); len(ovs) != 1 || ovs[0].Prefix != netip.MustParsePrefix("1.0.0.0/24") {
+			t.Errorf("SetOverrides: %s, want unchanged, but %v", tc.in, ovs)
+		}
+	}
+}
+
+func TestLoadOverridesByFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overrides.txt")
+	if err := os.WriteFile(path, []byte("114.49.0.0/16|US|partner office\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db := GetDB()
+	if err := db.LoadOverridesByFile(path); err != nil {
+		t.Fatalf("LoadOverridesByFile: but error: %v", err)
+	}
+	if r := db.SearchInfo("114.49.0.1"); r.Code != "US" || r.Override == nil || r.Override.Source != path {
+		t.Errorf("SearchInfo: want override US, but %v", r)
+	}
+	if err := db.LoadOverridesByFile(filepath.Join(t.TempDir(), "none.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadOverridesByFile: want error %v, but %v", os.ErrNotExist, err)
+	}
+}
+
+func TestLookupWithOverrides(t *testing.T) {
+	db := GetDB()
+	if err := db.setTmpIPBlocks(strings.NewReader("apnic|JP|ipv4|114.48.0.0|262144|20080422|allocated\n"), ""); err != nil {
+		t.Fatalf("setTmpIPBlocks: but error: %v", err)
+	}
+	db.SwitchIPBData()
+	if err := db.SetOverrides(strings.NewReader("114.48.0.0/16|US|partner office\n10.1.0.0/16|JP\n"), ""); err != nil {
+		t.Fatalf("SetOverrides: but error: %v", err)
+	}
+
+	// SearchInfo と同じく上書きを優先する。
+	for _, tc := range []struct {
+		addr     string
+		code     string
+		override bool
+	}{
+		{addr: "114.48.0.1", code: "US", override: true},
+		{addr: "::ffff:114.48.0.1", code: "US", override: true},
+		{addr: "114.49.0.1", code: "JP"},
+		{addr: "10.1.2.3", code: "JP", override: true},
+		{addr: "10.2.0.1"},
+	} {
+		addr := netip.MustParseAddr(tc.addr)
+		sr := db.SearchInfo(tc.addr)
+		r := db.Lookup(addr)
+		if r.Code != tc.code || r.Code != sr.Code || (r.Override != nil) != tc.override || (sr.Override != nil) != tc.override {
+			t.Errorf("Lookup: %s, want %s (override %v), but %v, SearchInfo %v", tc.addr, tc.code, tc.override, r, sr)
+		}
+		if tc.override && (r.Prefix != sr.Prefix || r.Start.String() != sr.BlockStart || r.End.String() != sr.BlockEnd || r.Registry != "") {
+			t.Errorf("Lookup: %s, want same block as SearchInfo %v, but %v", tc.addr, sr, r)
+		}
+		if code := db.LookupCode(addr); code != tc.code {
+			t.Errorf("LookupCode: %s, want %q, but %q", tc.addr, tc.code, code)
+		}
+	}
+
+	addrs := []netip.Addr{netip.MustParseAddr("114.48.0.1"), netip.MustParseAddr("114.49.0.1")}
+	rs := db.LookupBatch(addrs)
+	if rs[0].Code != "US" || rs[0].Override == nil || rs[1].Code != "JP" || rs[1].Override != nil {
+		t.Errorf("LookupBatch: invalid results: %v", rs)
+	}
+
+	in := make(chan netip.Addr, len(addrs))
+	for _, addr := range addrs {
+		in <- addr
+	}
+	close(in)
+	var codes []string
+	for r := range db.LookupStream(context.Background(), in) {
+		codes = append(codes, r.Code)
+	}
+	if strings.Join(codes, ",") != "US,JP" {
+		t.Errorf("LookupStream: want US,JP, but %v", codes)
+	}
+
+	// 上書きがある場合も LookupCode はメモリを割り当てない。
+	addr := netip.MustParseAddr("114.48.0.1")
+	if n := testing.AllocsPerRun(100, func() { db.LookupCode(addr) }); n != 0 {
+		t.Errorf("LookupCode: allocs want 0, but %v", n)
+	}
+}
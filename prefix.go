@@ -0,0 +1,211 @@
+package ccipv4
+
+import (
+	"math/bits"
+	"net/netip"
+	"slices"
+)
+
+// uint32 に変換した IPv4 アドレスの範囲 [start, end] を、
+// 先頭から順に最小の個数のプレフィックスに分割し、
+// 各プレフィックスの最初のアドレスとプレフィックス長を fn に渡す。
+// fn が false を返した場合は中止する。
+func eachPrefix4(start, end uint32, fn func(first uint32, n int) bool) {
+	s, e := uint64(start), uint64(end)
+	for s <= e {
+		// s を最初のアドレスとするプレフィックスのうち、範囲に収まる最大のもの
+		n := 32
+		if s != 0 {
+			n = bits.TrailingZeros32(uint32(s))
+		}
+		for s+(1<<n)-1 > e {
+			n--
+		}
+		if !fn(uint32(s), 32-n) {
+			return
+		}
+		s += 1 << n
+	}
+}
+
+// uint32 に変換した IPv4 アドレスの範囲 [start, end] を覆う、
+// 最小の個数のプレフィックスの一覧を返す。
+func rangeToPrefixes4(start, end uint32) []netip.Prefix {
+	var ps []netip.Prefix
+	eachPrefix4(start, end, func(first uint32, n int) bool {
+		ps = append(ps, netip.PrefixFrom(uint32ToAddr(first), n))
+		return true
+	})
+
+	return ps
+}
+
+// uint32 に変換した IPv4 アドレスの範囲 [start, end] を覆うプレフィックスのうち、
+// x を含むものを返す。 x が範囲外の場合は無効な値を返す。
+func prefixContaining4(start, end, x uint32) netip.Prefix {
+	var p netip.Prefix
+	eachPrefix4(start, end, func(first uint32, n int) bool {
+		if x < first {
+			return false
+		}
+		if uint64(x) < uint64(first)+1<<(32-n) {
+			p = netip.PrefixFrom(uint32ToAddr(first), n)
+			return false
+		}
+		return true
+	})
+
+	return p
+}
+
+// IP アドレスの範囲
+// Start と End は同じ種類（ IPv4 または IPv6 ）で、 Start <= End であること。
+type AddrRange struct {
+	Start netip.Addr
+	End   netip.Addr
+}
+
+// 範囲が有効かを返す。
+func (r AddrRange) IsValid() bool {
+	return r.Start.IsValid() && r.End.IsValid() && r.Start.Is4() == r.End.Is4() && r.Start.Compare(r.End) <= 0
+}
+
+// start から end までの範囲を覆う、最小の個数のプレフィックスの一覧を返す。
+// IPv4 と IPv6 のどちらも扱え、範囲の最後が 255.255.255.255 などの
+// 最大のアドレスでも 0.0.0.0 に戻らない。
+// IPv4 射影アドレスは IPv4 アドレスとして扱う。
+// 種類が異なる場合や start が end より大きい場合は nil を返す。
+func RangeToPrefixes(start, end netip.Addr) []netip.Prefix {
+	r := AddrRange{Start: start.Unmap().WithZone(""), End: end.Unmap().WithZone("")}
+	if !r.IsValid() {
+		return nil
+	}
+
+	var ps []netip.Prefix
+	for s := r.Start; ; {
+		// s を最初のアドレスとするプレフィックスのうち、範囲に収まる最大のもの
+		var p netip.Prefix
+		for n := 0; n <= s.BitLen(); n++ {
+			p = netip.PrefixFrom(s, n)
+			if p.Masked().Addr() == s && getLastAddrOfPrefix(p).Compare(r.End) <= 0 {
+				break
+			}
+		}
+		ps = append(ps, p)
+		last := getLastAddrOfPrefix(p)
+		if last == r.End {
+			return ps
+		}
+		s = last.Next()
+	}
+}
+
+// プレフィックスに含まれる最初と最後のアドレスを返す。
+// ホスト部が 0 でない場合は、ホスト部を 0 にしたプレフィックスとして扱う。
+// プレフィックスが無効な場合は、無効なアドレスを返す。
+func PrefixToRange(p netip.Prefix) (netip.Addr, netip.Addr) {
+	if !p.IsValid() {
+		return netip.Addr{}, netip.Addr{}
+	}
+	p = p.Masked()
+
+	return p.Addr(), getLastAddrOfPrefix(p)
+}
+
+// RIR statistics exchange format の ipv4 の value （アドレスの個数）に
+// 対応するプレフィックス長を返す。
+// value が 1 〜 4294967296 の範囲の２のべき乗でない場合は false を返す。
+func ValueToPrefixLen(value int) (int, bool) {
+	if value < 1 || value > 1<<32 || value&(value-1) != 0 {
+		return 0, false
+	}
+
+	return 32 - bits.TrailingZeros64(uint64(value)), true
+}
+
+// string で渡された IPv4 アドレスと RIR statistics exchange format の
+// value の値が表すブロックを、１つのプレフィックスとして返す。
+// IPv4 アドレスでない場合は ErrFirstArgumentOutOfRange を、
+// value が２のべき乗でない場合や、アドレスがプレフィックスの最初のアドレスでない場合は
+// ErrSecondArgumentOutOfRange を返す。
+func ValueToPrefix(adrs string, value int) (netip.Prefix, error) {
+	start, err := netip.ParseAddr(adrs)
+	// 渡されたアドレスをパースしてエラー
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	// IPv4アドレスでない
+	if !start.Is4() {
+		return netip.Prefix{}, ErrFirstArgumentOutOfRange
+	}
+
+	n, ok := ValueToPrefixLen(value)
+	if !ok {
+		return netip.Prefix{}, ErrSecondArgumentOutOfRange
+	}
+	p := netip.PrefixFrom(start, n)
+	if p.Masked().Addr() != start {
+		return netip.Prefix{}, ErrSecondArgumentOutOfRange
+	}
+
+	return p, nil
+}
+
+// 範囲の一覧を、重なりと隣接をまとめた最小の個数の範囲の一覧にして返す。
+// 戻り値はアドレス順で、 IPv4 の範囲が IPv6 の範囲より先になる。
+// 無効な範囲は無視する。
+func MergeRanges(rs []AddrRange) []AddrRange {
+	var sorted []AddrRange
+	for _, r := range rs {
+		r = AddrRange{Start: r.Start.Unmap().WithZone(""), End: r.End.Unmap().WithZone("")}
+		if r.IsValid() {
+			sorted = append(sorted, r)
+		}
+	}
+	slices.SortFunc(sorted, func(a, b AddrRange) int {
+		return a.Start.Compare(b.Start)
+	})
+
+	var merged []AddrRange
+	for _, r := range sorted {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			// 重なるか、隣接する場合はまとめる。
+			// 最大のアドレスの Next は無効な値なので、種類の異なる範囲はまとめない。
+			if r.Start.Compare(last.End) <= 0 || r.Start == last.End.Next() {
+				if r.End.Compare(last.End) > 0 {
+					last.End = r.End
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// 範囲の一覧を覆う、最小の個数のプレフィックスの一覧を返す。
+// 重なりと隣接はまとめる。戻り値はアドレス順。
+func AggregateRanges(rs []AddrRange) []netip.Prefix {
+	var ps []netip.Prefix
+	for _, r := range MergeRanges(rs) {
+		ps = append(ps, RangeToPrefixes(r.Start, r.End)...)
+	}
+
+	return ps
+}
+
+// プレフィックスの一覧を、重なりと隣接をまとめた最小の個数のプレフィックスの一覧にして返す。
+// 戻り値はアドレス順。無効なプレフィックスは無視する。
+func AggregatePrefixes(ps []netip.Prefix) []netip.Prefix {
+	rs := make([]AddrRange, 0, len(ps))
+	for _, p := range ps {
+		if p.IsValid() {
+			s, e := PrefixToRange(p)
+			rs = append(rs, AddrRange{Start: s, End: e})
+		}
+	}
+
+	return AggregateRanges(rs)
+}
@@ -0,0 +1,263 @@
+package ccipv4
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRangeToPrefixes4(t *testing.T) {
+	for _, tc := range []struct {
+		start string
+		end   string
+		want  []string
+	}{
+		{start: "1.0.0.0", end: "1.0.0.0", want: []string{"1.0.0.0/32"}},
+		{start: "1.0.0.0", end: "1.0.0.255", want: []string{"1.0.0.0/24"}},
+		{start: "114.48.0.0", end: "114.51.255.255", want: []string{"114.48.0.0/14"}},
+		// value が２のべき乗でない場合
+		{start: "1.0.0.0", end: "1.0.2.255", want: []string{"1.0.0.0/23", "1.0.2.0/24"}},
+		{start: "1.0.0.1", end: "1.0.0.6", want: []string{"1.0.0.1/32", "1.0.0.2/31", "1.0.0.4/31", "1.0.0.6/32"}},
+		{start: "10.0.0.128", end: "10.0.2.63", want: []string{"10.0.0.128/25", "10.0.1.0/24", "10.0.2.0/26"}},
+		// 全体と端
+		{start: "0.0.0.0", end: "255.255.255.255", want: []string{"0.0.0.0/0"}},
+		{start: "255.255.255.254", end: "255.255.255.255", want: []string{"255.255.255.254/31"}},
+		{start: "0.0.0.0", end: "0.0.0.2", want: []string{"0.0.0.0/31", "0.0.0.2/32"}},
+		// start が end より大きい場合は空
+		{start: "1.0.0.1", end: "1.0.0.0", want: nil},
+	} {
+		s, e := u32(tc.start), u32(tc.end)
+		var got []string
+		for _, p := range rangeToPrefixes4(s, e) {
+			got = append(got, p.String())
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("rangeToPrefixes4: %s - %s, want %v, but %v", tc.start, tc.end, tc.want, got)
+		}
+
+		// 各アドレスを含むプレフィックス
+		for _, x := range []uint32{s, s + (e-s)/2, e} {
+			if s > e {
+				break
+			}
+			p := prefixContaining4(s, e, x)
+			if !p.Contains(uint32ToAddr(x)) || !slices.Contains(tc.want, p.String()) {
+				t.Errorf("prefixContaining4: %s - %s, %v, but %v", tc.start, tc.end, uint32ToAddr(x), p)
+			}
+		}
+	}
+
+	// 範囲外
+	if p := prefixContaining4(u32("1.0.0.0"), u32("1.0.2.255"), u32("1.0.3.0")); p.IsValid() {
+		t.Errorf("prefixContaining4: out of range, but %v", p)
+	}
+	if p := prefixContaining4(u32("1.0.0.0"), u32("1.0.2.255"), u32("0.255.255.255")); p.IsValid() {
+		t.Errorf("prefixContaining4: out of range, but %v", p)
+	}
+}
+
+func TestSearchInfoPrefixes(t *testing.T) {
+	db := GetDB()
+	err := db.setTmpIPBlocks(strings.NewReader(strings.Join([]string{
+		"apnic|JP|ipv4|1.0.0.0|768|20110811|allocated",
+		"apnic|HK|ipv6|2001:df2:6180::|48|20191216|assigned",
+	}, "\n")), "")
+	if err != nil {
+		t.Fatalf("setTmpIPBlocks: but error: %v", err)
+	}
+	db.SwitchIPBData()
+
+	sr := db.SearchInfo("1.0.2.1")
+	want := []netip.Prefix{netip.MustParsePrefix("1.0.0.0/23"), netip.MustParsePrefix("1.0.2.0/24")}
+	if !slices.Equal(sr.Prefixes, want) {
+		t.Errorf("SearchInfo: want Prefixes %v, but %v", want, sr.Prefixes)
+	}
+	if sr.Prefix != want[1] {
+		t.Errorf("SearchInfo: want Prefix %v, but %v", want[1], sr.Prefix)
+	}
+	if r := db.Lookup(netip.MustParseAddr("1.0.1.1")); r.Prefix != want[0] {
+		t.Errorf("Lookup: want Prefix %v, but %v", want[0], r.Prefix)
+	}
+
+	sr = db.SearchInfo("2001:df2:6180::1")
+	p := netip.MustParsePrefix("2001:df2:6180::/48")
+	if !slices.Equal(sr.Prefixes, []netip.Prefix{p}) || sr.Prefix != p {
+		t.Errorf("SearchInfo: invalid prefixes: %v, %v", sr.Prefixes, sr.Prefix)
+	}
+
+	// みつからない場合は空
+	sr = db.SearchInfo("2.0.0.1")
+	if sr.Prefixes != nil || sr.Prefix.IsValid() {
+		t.Errorf("SearchInfo: not found, but prefixes: %v, %v", sr.Prefixes, sr.Prefix)
+	}
+}
+
+func TestRangeToPrefixes(t *testing.T) {
+	for _, tc := range []struct {
+		start string
+		end   string
+		want  []string
+	}{
+		{start: "1.0.0.0", end: "1.0.2.255", want: []string{"1.0.0.0/23", "1.0.2.0/24"}},
+		{start: "10.0.0.128", end: "10.0.2.63", want: []string{"10.0.0.128/25", "10.0.1.0/24", "10.0.2.0/26"}},
+		{start: "0.0.0.0", end: "255.255.255.255", want: []string{"0.0.0.0/0"}},
+		// 255.255.255.255 で 0.0.0.0 に戻らない。
+		{start: "255.255.255.0", end: "255.255.255.255", want: []string{"255.255.255.0/24"}},
+		{start: "255.255.255.253", end: "255.255.255.255", want: []string{"255.255.255.253/32", "255.255.255.254/31"}},
+		{start: "::ffff:1.0.0.0", end: "1.0.0.255", want: []string{"1.0.0.0/24"}},
+		// IPv6
+		{start: "2001:db8::", end: "2001:db8::ffff", want: []string{"2001:db8::/112"}},
+		{start: "2001:db8::1", end: "2001:db8::2", want: []string{"2001:db8::1/128", "2001:db8::2/128"}},
+		{start: "::", end: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", want: []string{"::/0"}},
+		{start: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe", end: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", want: []string{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe/127"}},
+		// 無効
+		{start: "1.0.0.1", end: "1.0.0.0", want: nil},
+		{start: "1.0.0.0", end: "2001:db8::", want: nil},
+	} {
+		var got []string
+		for _, p := range RangeToPrefixes(netip.MustParseAddr(tc.start), netip.MustParseAddr(tc.end)) {
+			got = append(got, p.String())
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("RangeToPrefixes: %s - %s, want %v, but %v", tc.start, tc.end, tc.want, got)
+		}
+	}
+	if ps := RangeToPrefixes(netip.Addr{}, netip.MustParseAddr("1.0.0.0")); ps != nil {
+		t.Errorf("RangeToPrefixes: invalid address, but %v", ps)
+	}
+
+	// rangeToPrefixes4 と同じ結果
+	for _, file := range benchIPBlockFiles[:2] {
+		data, _ := loadBenchData(t, file)
+		for k, v := range data {
+			if uint64(k)+uint64(v.value) > 1<<32 {
+				continue
+			}
+			want := rangeToPrefixes4(k, k+v.value-1)
+			got := RangeToPrefixes(uint32ToAddr(k), uint32ToAddr(k+v.value-1))
+			if !slices.Equal(got, want) {
+				t.Errorf("RangeToPrefixes: %v, %d, want %v, but %v", uint32ToAddr(k), v.value, want, got)
+			}
+		}
+	}
+}
+
+func TestPrefixToRange(t *testing.T) {
+	for _, tc := range []struct {
+		prefix string
+		start  string
+		end    string
+	}{
+		{prefix: "1.0.0.0/24", start: "1.0.0.0", end: "1.0.0.255"},
+		{prefix: "1.0.0.1/24", start: "1.0.0.0", end: "1.0.0.255"},
+		{prefix: "0.0.0.0/0", start: "0.0.0.0", end: "255.255.255.255"},
+		{prefix: "255.255.255.255/32", start: "255.255.255.255", end: "255.255.255.255"},
+		{prefix: "2001:db8::/32", start: "2001:db8::", end: "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+	} {
+		s, e := PrefixToRange(netip.MustParsePrefix(tc.prefix))
+		if s.String() != tc.start || e.String() != tc.end {
+			t.Errorf("PrefixToRange: %s, want %s - %s, but %v - %v", tc.prefix, tc.start, tc.end, s, e)
+		}
+	}
+	if s, e := PrefixToRange(netip.Prefix{}); s.IsValid() || e.IsValid() {
+		t.Errorf("PrefixToRange: invalid prefix, but %v - %v", s, e)
+	}
+}
+
+func TestValueToPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		value int
+		want  int
+		ok    bool
+	}{
+		{value: 1, want: 32, ok: true},
+		{value: 256, want: 24, ok: true},
+		{value: 262144, want: 14, ok: true},
+		{value: 4294967296, want: 0, ok: true},
+		{value: 0},
+		{value: -256},
+		{value: 768},
+		{value: 8589934592},
+	} {
+		n, ok := ValueToPrefixLen(tc.value)
+		if ok != tc.ok || n != tc.want {
+			t.Errorf("ValueToPrefixLen: %d, want %d, %t, but %d, %t", tc.value, tc.want, tc.ok, n, ok)
+		}
+	}
+
+	p, err := ValueToPrefix("114.48.0.0", 262144)
+	if err != nil || p != netip.MustParsePrefix("114.48.0.0/14") {
+		t.Errorf("ValueToPrefix: want 114.48.0.0/14, but %v, %v", p, err)
+	}
+	if _, err := ValueToPrefix("", 256); err == nil {
+		t.Error("ValueToPrefix: addr is invalid, but no error")
+	}
+	if _, err := ValueToPrefix("2001:db8::", 256); !errors.Is(err, ErrFirstArgumentOutOfRange) {
+		t.Errorf("ValueToPrefix: not ipv4, but invalid error: %v", err)
+	}
+	// ２のべき乗でない
+	if _, err := ValueToPrefix("1.0.0.0", 768); !errors.Is(err, ErrSecondArgumentOutOfRange) {
+		t.Errorf("ValueToPrefix: value is not a power of 2, but invalid error: %v", err)
+	}
+	// プレフィックスの最初のアドレスでない
+	if _, err := ValueToPrefix("1.0.1.0", 1024); !errors.Is(err, ErrSecondArgumentOutOfRange) {
+		t.Errorf("ValueToPrefix: addr is not aligned, but invalid error: %v", err)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	r := func(s, e string) AddrRange {
+		return AddrRange{Start: netip.MustParseAddr(s), End: netip.MustParseAddr(e)}
+	}
+
+	rs := MergeRanges([]AddrRange{
+		r("1.0.2.0", "1.0.2.255"),
+		r("2001:db8::", "2001:db8::ff"),
+		r("1.0.0.0", "1.0.0.255"),
+		// 隣接
+		r("1.0.1.0", "1.0.1.255"),
+		// 重なり
+		r("1.0.0.128", "1.0.0.200"),
+		r("2001:db8::80", "2001:db8::1ff"),
+		// 最大のアドレスと種類の異なる範囲はまとめない。
+		r("255.255.255.0", "255.255.255.255"),
+		r("::", "::ff"),
+		// 無効
+		r("1.0.0.1", "1.0.0.0"),
+		{},
+	})
+	want := []AddrRange{
+		r("1.0.0.0", "1.0.2.255"),
+		r("255.255.255.0", "255.255.255.255"),
+		r("::", "::ff"),
+		r("2001:db8::", "2001:db8::1ff"),
+	}
+	if !slices.Equal(rs, want) {
+		t.Errorf("MergeRanges: want %v, but %v", want, rs)
+	}
+
+	ps := AggregateRanges([]AddrRange{r("1.0.2.0", "1.0.2.255"), r("1.0.0.0", "1.0.1.255"), r("1.0.3.0", "1.0.3.255")})
+	if !slices.Equal(ps, []netip.Prefix{netip.MustParsePrefix("1.0.0.0/22")}) {
+		t.Errorf("AggregateRanges: want [1.0.0.0/22], but %v", ps)
+	}
+
+	ps = AggregatePrefixes([]netip.Prefix{
+		netip.MustParsePrefix("10.0.1.0/24"),
+		netip.MustParsePrefix("10.0.0.0/24"),
+		netip.MustParsePrefix("10.0.0.5/32"),
+		netip.MustParsePrefix("10.0.2.0/24"),
+		netip.MustParsePrefix("2001:db8:1::/48"),
+		netip.MustParsePrefix("2001:db8::/48"),
+		{},
+	})
+	wantPs := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/23"),
+		netip.MustParsePrefix("10.0.2.0/24"),
+		netip.MustParsePrefix("2001:db8::/47"),
+	}
+	if !slices.Equal(ps, wantPs) {
+		t.Errorf("AggregatePrefixes: want %v, but %v", wantPs, ps)
+	}
+}
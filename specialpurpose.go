@@ -0,0 +1,94 @@
+package ccipv4
+
+import (
+	"net/netip"
+	"slices"
+)
+
+// IANA IPv4 Special-Purpose Address Registry の登録内容
+// https://www.iana.org/assignments/iana-ipv4-special-registry/
+type SpecialPurpose struct {
+	Prefix netip.Prefix
+	// 登録名（ "Shared Address Space" など）
+	Name string
+	// 定義している RFC （ "RFC 6598" など）
+	RFC string
+	// グローバルに到達可能か。
+	// false の場合は RIR のデータを検索せずに SearchInfo が StatusReserved などを返す。
+	GloballyReachable bool
+}
+
+// IANA IPv4 Special-Purpose Address Registry の一覧
+var specialPurposes = []SpecialPurpose{
+	{Prefix: netip.MustParsePrefix("0.0.0.0/8"), Name: "This network", RFC: "RFC 791"},
+	{Prefix: netip.MustParsePrefix("0.0.0.0/32"), Name: "This host on this network", RFC: "RFC 1122"},
+	{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Name: "Private-Use", RFC: "RFC 1918"},
+	{Prefix: netip.MustParsePrefix("100.64.0.0/10"), Name: "Shared Address Space", RFC: "RFC 6598"},
+	{Prefix: netip.MustParsePrefix("127.0.0.0/8"), Name: "Loopback", RFC: "RFC 1122"},
+	{Prefix: netip.MustParsePrefix("169.254.0.0/16"), Name: "Link Local", RFC: "RFC 3927"},
+	{Prefix: netip.MustParsePrefix("172.16.0.0/12"), Name: "Private-Use", RFC: "RFC 1918"},
+	{Prefix: netip.MustParsePrefix("192.0.0.0/24"), Name: "IETF Protocol Assignments", RFC: "RFC 6890"},
+	{Prefix: netip.MustParsePrefix("192.0.0.0/29"), Name: "IPv4 Service Continuity Prefix", RFC: "RFC 7335"},
+	{Prefix: netip.MustParsePrefix("192.0.0.8/32"), Name: "IPv4 dummy address", RFC: "RFC 7600"},
+	{Prefix: netip.MustParsePrefix("192.0.0.9/32"), Name: "Port Control Protocol Anycast", RFC: "RFC 7723", GloballyReachable: true},
+	{Prefix: netip.MustParsePrefix("192.0.0.10/32"), Name: "Traversal Using Relays around NAT Anycast", RFC: "RFC 8155", GloballyReachable: true},
+	{Prefix: netip.MustParsePrefix("192.0.0.170/32"), Name: "NAT64/DNS64 Discovery", RFC: "RFC 8880"},
+	{Prefix: netip.MustParsePrefix("192.0.0.171/32"), Name: "NAT64/DNS64 Discovery", RFC: "RFC 8880"},
+	{Prefix: netip.MustParsePrefix("192.0.2.0/24"), Name: "Documentation (TEST-NET-1)", RFC: "RFC 5737"},
+	{Prefix: netip.MustParsePrefix("192.31.196.0/24"), Name: "AS112-v4", RFC: "RFC 7535", GloballyReachable: true},
+	{Prefix: netip.MustParsePrefix("192.52.193.0/24"), Name: "AMT", RFC: "RFC 7450", GloballyReachable: true},
+	{Prefix: netip.MustParsePrefix("192.88.99.0/24"), Name: "Deprecated (6to4 Relay Anycast)", RFC: "RFC 7526"},
+	{Prefix: netip.MustParsePrefix("192.168.0.0/16"), Name: "Private-Use", RFC: "RFC 1918"},
+	{Prefix: netip.MustParsePrefix("192.175.48.0/24"), Name: "Direct Delegation AS112 Service", RFC: "RFC 7534", GloballyReachable: true},
+	{Prefix: netip.MustParsePrefix("198.18.0.0/15"), Name: "Benchmarking", RFC: "RFC 2544"},
+	{Prefix: netip.MustParsePrefix("198.51.100.0/24"), Name: "Documentation (TEST-NET-2)", RFC: "RFC 5737"},
+	{Prefix: netip.MustParsePrefix("203.0.113.0/24"), Name: "Documentation (TEST-NET-3)", RFC: "RFC 5737"},
+	{Prefix: netip.MustParsePrefix("240.0.0.0/4"), Name: "Reserved", RFC: "RFC 1112"},
+	{Prefix: netip.MustParsePrefix("255.255.255.255/32"), Name: "Limited Broadcast", RFC: "RFC 919"},
+}
+
+// 検索を速くするため、 specialPurposes の各プレフィックスの範囲を
+// uint32 に変換したもの。添字は specialPurposes と同じ。
+var specialRanges = func() []ipRange {
+	rs := make([]ipRange, len(specialPurposes))
+	for i, sp := range specialPurposes {
+		start := addrToUint32(sp.Prefix.Addr())
+		rs[i] = ipRange{start: start, end: start + uint32(1<<(32-sp.Prefix.Bits())-1)}
+	}
+	return rs
+}()
+
+// IANA IPv4 Special-Purpose Address Registry の一覧を返す。
+func SpecialPurposes() []SpecialPurpose {
+	return slices.Clone(specialPurposes)
+}
+
+// IPv4 アドレスが含まれる IANA IPv4 Special-Purpose Address Registry の登録内容を返す。
+// 複数に含まれる場合は、プレフィックス長の最も長いものを返す。
+// 含まれない場合や IPv4 アドレスでない場合は false を返す。
+func LookupSpecialPurpose(addr netip.Addr) (SpecialPurpose, bool) {
+	i := specialPurposeIndex(addr.Unmap())
+	if i < 0 {
+		return SpecialPurpose{}, false
+	}
+
+	return specialPurposes[i], true
+}
+
+// IPv4 アドレスが含まれる登録内容のうち、プレフィックス長の最も長いものの
+// specialPurposes の添字を返す。含まれない場合や IPv4 アドレスでない場合は -1 。
+func specialPurposeIndex(addr netip.Addr) int {
+	if !addr.Is4() {
+		return -1
+	}
+
+	x := addrToUint32(addr)
+	found := -1
+	for i, r := range specialRanges {
+		if r.start <= x && x <= r.end && (found < 0 || r.end-r.start < specialRanges[found].end-specialRanges[found].start) {
+			found = i
+		}
+	}
+
+	return found
+}
@@ -0,0 +1,117 @@
+package ccipv4
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestLookupSpecialPurpose(t *testing.T) {
+	for _, tc := range []struct {
+		addr   string
+		ok     bool
+		prefix string
+		rfc    string
+		global bool
+	}{
+		{addr: "0.0.0.0", ok: true, prefix: "0.0.0.0/32", rfc: "RFC 1122"},
+		{addr: "0.1.2.3", ok: true, prefix: "0.0.0.0/8", rfc: "RFC 791"},
+		{addr: "100.64.0.1", ok: true, prefix: "100.64.0.0/10", rfc: "RFC 6598"},
+		{addr: "100.127.255.255", ok: true, prefix: "100.64.0.0/10", rfc: "RFC 6598"},
+		{addr: "100.128.0.0", ok: false},
+		{addr: "169.254.1.1", ok: true, prefix: "169.254.0.0/16", rfc: "RFC 3927"},
+		{addr: "192.0.0.1", ok: true, prefix: "192.0.0.0/29", rfc: "RFC 7335"},
+		{addr: "192.0.0.9", ok: true, prefix: "192.0.0.9/32", rfc: "RFC 7723", global: true},
+		{addr: "192.0.0.100", ok: true, prefix: "192.0.0.0/24", rfc: "RFC 6890"},
+		{addr: "192.0.2.1", ok: true, prefix: "192.0.2.0/24", rfc: "RFC 5737"},
+		{addr: "198.19.255.255", ok: true, prefix: "198.18.0.0/15", rfc: "RFC 2544"},
+		{addr: "198.51.100.1", ok: true, prefix: "198.51.100.0/24", rfc: "RFC 5737"},
+		{addr: "203.0.113.1", ok: true, prefix: "203.0.113.0/24", rfc: "RFC 5737"},
+		{addr: "240.0.0.1", ok: true, prefix: "240.0.0.0/4", rfc: "RFC 1112"},
+		{addr: "255.255.255.255", ok: true, prefix: "255.255.255.255/32", rfc: "RFC 919"},
+		{addr: "::ffff:100.64.0.1", ok: true, prefix: "100.64.0.0/10", rfc: "RFC 6598"},
+		{addr: "8.8.8.8", ok: false},
+		{addr: "2001:db8::1", ok: false},
+	} {
+		sp, ok := LookupSpecialPurpose(netip.MustParseAddr(tc.addr))
+		if ok != tc.ok {
+			t.Errorf("LookupSpecialPurpose: %s, want %t, but %t: %v", tc.addr, tc.ok, ok, sp)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if sp.Prefix.String() != tc.prefix || sp.RFC != tc.rfc || sp.GloballyReachable != tc.global {
+			t.Errorf("LookupSpecialPurpose: %s, invalid result: %v", tc.addr, sp)
+		}
+	}
+
+	// 一覧は複製を返す。
+	sps := SpecialPurposes()
+	if len(sps) != len(specialPurposes) {
+		t.Fatalf("SpecialPurposes: length want %d, but %d", len(specialPurposes), len(sps))
+	}
+	sps[0].Name = "changed"
+	if specialPurposes[0].Name == "changed" {
+		t.Error("SpecialPurposes: returns the original slice")
+	}
+	for _, sp := range sps {
+		if !sp.Prefix.Addr().Is4() || sp.Prefix != sp.Prefix.Masked() || !strings.HasPrefix(sp.RFC, "RFC ") {
+			t.Errorf("SpecialPurposes: invalid entry: %v", sp)
+		}
+	}
+}
+
+func TestSearchInfoSpecialPurpose(t *testing.T) {
+	db := GetDB()
+	err := db.setTmpIPBlocks(strings.NewReader(strings.Join([]string{
+		"apnic|JP|ipv4|100.0.0.0|16777216|20080422|allocated",
+		"iana|ZZ|ipv4|192.0.0.0|256|20080422|reserved",
+		"arin|US|ipv4|8.8.8.0|256|20080422|allocated",
+	}, "\n")), "")
+	if err != nil {
+		t.Fatalf("setTmpIPBlocks: but error: %v", err)
+	}
+	db.SwitchIPBData()
+
+	for _, tc := range []struct {
+		addr   string
+		status Status
+		name   string
+	}{
+		{addr: "100.64.0.1", status: StatusReserved, name: "Shared Address Space"},
+		{addr: "169.254.1.1", status: StatusReserved, name: "Link Local"},
+		{addr: "192.0.2.1", status: StatusReserved, name: "Documentation (TEST-NET-1)"},
+		{addr: "198.18.0.1", status: StatusReserved, name: "Benchmarking"},
+		{addr: "0.0.0.0", status: StatusReserved, name: "This host on this network"},
+		{addr: "240.0.0.1", status: StatusReserved, name: "Reserved"},
+		{addr: "255.255.255.255", status: StatusReserved, name: "Limited Broadcast"},
+		{addr: "::ffff:100.64.0.1", status: StatusReserved, name: "Shared Address Space"},
+		// 従来の判定を優先し、登録内容を付ける。
+		{addr: "127.0.0.1", status: StatusLoopback, name: "Loopback"},
+		{addr: "10.0.0.1", status: StatusPrivate, name: "Private-Use"},
+		{addr: "224.0.0.1", status: StatusMulticast},
+		// グローバルに到達可能なものはデータベースを検索する。
+		{addr: "192.0.0.9", status: StatusFound, name: "Port Control Protocol Anycast"},
+		{addr: "192.31.196.1", status: StatusNotFound, name: "AS112-v4"},
+		// 登録されていないもの
+		{addr: "100.128.0.1", status: StatusFound},
+		{addr: "8.8.8.8", status: StatusFound},
+		{addr: "2001:db8::1", status: StatusNotFound},
+	} {
+		sr := db.SearchInfo(tc.addr)
+		if sr.Status != tc.status || sr.Message != tc.status.String() {
+			t.Errorf("SearchInfo: %s, want %v, but %v: %v", tc.addr, tc.status, sr.Status, sr)
+		}
+		if sr.IsFound != (tc.status == StatusFound) {
+			t.Errorf("SearchInfo: %s, invalid IsFound: %v", tc.addr, sr)
+		}
+		if tc.name == "" {
+			if sr.SpecialPurpose != nil {
+				t.Errorf("SearchInfo: %s, want SpecialPurpose nil, but %v", tc.addr, *sr.SpecialPurpose)
+			}
+		} else if sr.SpecialPurpose == nil || sr.SpecialPurpose.Name != tc.name {
+			t.Errorf("SearchInfo: %s, want SpecialPurpose %s, but %v", tc.addr, tc.name, sr.SpecialPurpose)
+		}
+	}
+}
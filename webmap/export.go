@@ -0,0 +1,60 @@
+package webmap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/suka-test/ccipv4"
+	"github.com/suka-test/ccipv4/internal/atomicfile"
+)
+
+// 書き込むファイルのパスと形式
+type Target struct {
+	Path   string
+	Format Format
+}
+
+// 検索用データベースから設定ファイルを作成して書き込む。
+// ccipv4.WithSwitchHook に SwitchHook を渡すと、
+// 検索用データベースを更新するたびに設定ファイルを書き換える。
+type Exporter struct {
+	Targets []Target
+	Options Options
+	// SwitchHook で書き込んだ後に、 Export の戻り値を渡して呼ぶ。
+	// Web サーバーの設定の再読込などに使う。 nil の場合は呼ばない。
+	OnExport func(err error)
+}
+
+// 検索用データベースから設定ファイルを作成し、各 Target に書き込む。
+// 各ファイルは一時ファイルに書き込んでから名前を変更するので、
+// Web サーバーが書込途中のファイルを読み込むことはない。
+// 異常が発生したファイルは変更せず、他のファイルの書込は続ける。
+// 出力する割当ブロックがない場合は、いずれのファイルも変更せずに ErrEmptyMap を返す。
+func (e *Exporter) Export(db *ccipv4.DB) error {
+	m, err := Build(db, e.Options)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, t := range e.Targets {
+		err := atomicfile.Write(t.Path, func(w io.Writer) error {
+			return m.Write(w, t.Format)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", t.Path, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Export を実行し、結果を OnExport に渡す。
+// ccipv4.WithSwitchHook に渡して使う。
+func (e *Exporter) SwitchHook(db *ccipv4.DB) {
+	err := e.Export(db)
+	if e.OnExport != nil {
+		e.OnExport(err)
+	}
+}
@@ -0,0 +1,80 @@
+package webmap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/suka-test/ccipv4"
+)
+
+func TestExporter(t *testing.T) {
+	dir := t.TempDir()
+	nginx := filepath.Join(dir, "geo.conf")
+	haproxy := filepath.Join(dir, "country.map")
+
+	var (
+		calls int
+		last  error
+	)
+	e := &Exporter{
+		Targets: []Target{
+			{Path: nginx, Format: FormatNginx},
+			{Path: haproxy, Format: FormatHAProxy},
+		},
+		OnExport: func(err error) {
+			calls++
+			last = err
+		},
+	}
+	db := ccipv4.NewDB(ccipv4.WithSwitchHook(e.SwitchHook))
+
+	// 検索用データベースを更新すると書き込む。
+	if err := db.LoadIPBDataByFile("../testdata/validIPBlockFile-1"); err != nil {
+		t.Fatalf("LoadIPBDataByFile: but error: %v", err)
+	}
+	db.SwitchIPBData()
+	if calls != 1 || last != nil {
+		t.Fatalf("SwitchHook: calls want 1, but %d: %v", calls, last)
+	}
+	b, err := os.ReadFile(haproxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), "114.48.0.0/14 JP\n2001:df2:6180::/48 HK\n") {
+		t.Errorf("SwitchHook: invalid haproxy map:\n%s", b)
+	}
+	if b, _ := os.ReadFile(nginx); !strings.Contains(string(b), "\t114.48.0.0/14 JP;\n") {
+		t.Errorf("SwitchHook: invalid nginx geo:\n%s", b)
+	}
+
+	// 空のデータベースに更新した場合は、既存のファイルを変更しない。
+	db.SwitchIPBData()
+	if calls != 2 || !errors.Is(last, ErrEmptyMap) {
+		t.Errorf("SwitchHook: empty db, want error %v, but %v", ErrEmptyMap, last)
+	}
+	if b2, _ := os.ReadFile(haproxy); string(b2) != string(b) {
+		t.Errorf("SwitchHook: empty db, file changed:\n%s", b2)
+	}
+
+	// 書き込めないファイルがあっても、他のファイルは書き込む。
+	e.Targets = append([]Target{{Path: filepath.Join(dir, "none", "apache.map"), Format: FormatApache}}, e.Targets...)
+	e.Options.Countries = []string{"JP"}
+	if err := db.LoadIPBDataByFile("../testdata/validIPBlockFile-1"); err != nil {
+		t.Fatalf("LoadIPBDataByFile: but error: %v", err)
+	}
+	db.SwitchIPBData()
+	if last == nil || !strings.Contains(last.Error(), "apache.map") {
+		t.Errorf("SwitchHook: want error, but %v", last)
+	}
+	if b, _ := os.ReadFile(haproxy); string(b) != "114.48.0.0/14 JP\n" {
+		t.Errorf("SwitchHook: invalid haproxy map:\n%s", b)
+	}
+
+	// 一時ファイルが残らない。
+	if es, _ := os.ReadDir(dir); len(es) != 2 {
+		t.Errorf("Export: temporary files remain: %v", es)
+	}
+}
@@ -0,0 +1,231 @@
+// 検索用データベースから、 Web サーバーで IP アドレスからカントリーコードを
+// 求めるための設定ファイルを作成する。
+// nginx の geo ブロック、 HAProxy の map ファイル、
+// Apache の mod_rewrite の RewriteMap 用のファイルの形式で出力できる。
+package webmap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/suka-test/ccipv4"
+)
+
+// 出力形式
+type Format int
+
+const (
+	// nginx の geo ブロック
+	FormatNginx Format = iota
+	// HAProxy の map_ip などで使う map ファイル
+	FormatHAProxy
+	// Apache の mod_rewrite の RewriteMap で使う txt 形式のファイル
+	FormatApache
+)
+
+// 出力形式の名前を返す。
+func (f Format) String() string {
+	switch f {
+	case FormatNginx:
+		return "nginx"
+	case FormatHAProxy:
+		return "haproxy"
+	case FormatApache:
+		return "apache"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+// 出力形式の名前から Format を返す。
+func ParseFormat(s string) (Format, error) {
+	for _, f := range []Format{FormatNginx, FormatHAProxy, FormatApache} {
+		if strings.EqualFold(s, f.String()) {
+			return f, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrUnknownFormat, s)
+}
+
+var (
+	ErrUnknownFormat = errors.New("unknown format")
+	// 出力する割当ブロックがない。
+	// 空の設定で既存の設定を置き換えないよう、作成しない。
+	ErrEmptyMap        = errors.New("no blocks to export")
+	ErrInvalidVariable = errors.New("invalid variable name")
+)
+
+// nginx の geo ブロックの既定の変数名
+const DefaultVariable = "ccipv4_country"
+
+// 設定ファイルの作成条件
+type Options struct {
+	// 含めるカントリーコード。空の場合はデータベースの全てのカントリーコード。
+	Countries []string
+	// nginx の geo ブロックで設定する変数名（ $ を除く）。
+	// 空の場合は DefaultVariable 。
+	Variable string
+	// nginx の geo ブロックで、一致しない場合の値。
+	Default string
+}
+
+// プレフィックスとカントリーコードの組
+type Entry struct {
+	Prefix netip.Prefix
+	Code   string
+}
+
+// プレフィックスとカントリーコードの対応表
+type Map struct {
+	// アドレス順（ IPv4 、 IPv6 の順）
+	Entries  []Entry
+	variable string
+	def      string
+}
+
+// 検索用データベースから、 opts で選択したカントリーコードの
+// 割当ブロックを覆うプレフィックスとカントリーコードの対応表を作成する。
+// 同じカントリーコードの隣接する割当ブロックはまとめる。
+// 該当する割当ブロックがない場合は ErrEmptyMap を返す。
+func Build(db *ccipv4.DB, opts Options) (*Map, error) {
+	m := &Map{variable: opts.Variable, def: opts.Default}
+	if m.variable == "" {
+		m.variable = DefaultVariable
+	}
+	if !isValidVariable(m.variable) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidVariable, m.variable)
+	}
+
+	sel := map[string]bool{}
+	for _, cc := range opts.Countries {
+		sel[strings.ToUpper(strings.TrimSpace(cc))] = true
+	}
+	var v4, v6 []Entry
+	// 同じ時点の検索用データベースから、全てのカントリーコードのものを取得する。
+	for cc, ps := range db.PrefixesByCountries() {
+		if len(opts.Countries) > 0 && !sel[cc] {
+			continue
+		}
+		for _, p := range ps {
+			if p.Addr().Is4() {
+				v4 = append(v4, Entry{Prefix: p, Code: cc})
+			} else {
+				v6 = append(v6, Entry{Prefix: p, Code: cc})
+			}
+		}
+	}
+	// カントリーコードごとのプレフィックスは重ならないので、
+	// 最初のアドレスの順に並べればアドレス順になる。
+	for _, es := range [][]Entry{v4, v6} {
+		slices.SortFunc(es, func(a, b Entry) int {
+			return a.Prefix.Addr().Compare(b.Prefix.Addr())
+		})
+	}
+	m.Entries = append(v4, v6...)
+	if len(m.Entries) == 0 {
+		return nil, ErrEmptyMap
+	}
+
+	return m, nil
+}
+
+// nginx の変数名として使える名前かを返す。
+func isValidVariable(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', c == '_':
+		case '0' <= c && c <= '9':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// 指定の形式で w に書き込む。
+func (m *Map) Write(w io.Writer, f Format) error {
+	switch f {
+	case FormatNginx:
+		return m.WriteNginx(w)
+	case FormatHAProxy:
+		return m.WriteHAProxy(w)
+	case FormatApache:
+		return m.WriteApache(w)
+	default:
+		return fmt.Errorf("%w: %v", ErrUnknownFormat, f)
+	}
+}
+
+// nginx の geo ブロックを書き込む。
+// http ブロックで include して使う。
+func (m *Map) WriteNginx(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "geo $%s {\n", m.variable)
+	fmt.Fprintf(bw, "\tdefault %s;\n", quoteNginx(m.def))
+	for _, e := range m.Entries {
+		fmt.Fprintf(bw, "\t%s %s;\n", e.Prefix, e.Code)
+	}
+	fmt.Fprintln(bw, "}")
+
+	return bw.Flush()
+}
+
+// nginx の設定の値として、引用符で囲んだ文字列を返す。
+func quoteNginx(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// HAProxy の map ファイルを書き込む。
+// １行に１つの CIDR とカントリーコードを空白で区切って並べる。
+func (m *Map) WriteHAProxy(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range m.Entries {
+		fmt.Fprintf(bw, "%s %s\n", e.Prefix, e.Code)
+	}
+
+	return bw.Flush()
+}
+
+// Apache の mod_rewrite の RewriteMap で使う txt 形式のファイルを書き込む。
+// RewriteMap はキーの完全一致で検索するので、 IPv4 アドレスの上位２オクテット
+// （ "1.0" など）または上位３オクテット（ "1.0.0" など）をキーとする。
+// /16 全体が同じカントリーコードの場合は上位２オクテットを、
+// それ以外は /24 ごとに上位３オクテットをキーとする。
+// /24 より小さいブロックと IPv6 のブロックは含まない。
+func (m *Map) WriteApache(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range m.Entries {
+		p := e.Prefix
+		if !p.Addr().Is4() || p.Bits() > 24 {
+			continue
+		}
+		a := p.Addr().As4()
+		first := uint32(a[0])<<24 | uint32(a[1])<<16 | uint32(a[2])<<8
+		if p.Bits() <= 16 {
+			for i := uint64(0); i < 1<<(16-p.Bits()); i++ {
+				x := first + uint32(i<<16)
+				fmt.Fprintf(bw, "%d.%d %s\n", x>>24, x>>16&0xff, e.Code)
+			}
+			continue
+		}
+		for i := uint64(0); i < 1<<(24-p.Bits()); i++ {
+			x := first + uint32(i<<8)
+			fmt.Fprintf(bw, "%d.%d.%d %s\n", x>>24, x>>16&0xff, x>>8&0xff, e.Code)
+		}
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,189 @@
+package webmap
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/suka-test/ccipv4"
+)
+
+// テスト用のレコードを読み込んだデータベースを返す。
+func loadDB(t *testing.T, records ...string) *ccipv4.DB {
+	t.Helper()
+	f := filepath.Join(t.TempDir(), "delegated")
+	if err := os.WriteFile(f, []byte(strings.Join(records, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db := ccipv4.GetDB()
+	if err := db.LoadIPBDataByFile(f); err != nil {
+		t.Fatalf("LoadIPBDataByFile: but error: %v", err)
+	}
+	db.SwitchIPBData()
+
+	return db
+}
+
+var testRecords = []string{
+	"apnic|JP|ipv4|1.0.0.0|512|20110811|assigned",
+	"apnic|JP|ipv4|1.0.2.0|256|20110811|assigned",
+	"apnic|CN|ipv4|1.1.0.0|131072|20110414|allocated",
+	"apnic|AU|ipv4|1.3.0.0|128|20110412|allocated",
+	"apnic|HK|ipv6|2001:df2:6180::|48|20191216|assigned",
+	"apnic|JP|asn|173|1|20020801|allocated",
+}
+
+func TestBuild(t *testing.T) {
+	db := loadDB(t, testRecords...)
+
+	m, err := Build(db, Options{})
+	if err != nil {
+		t.Fatalf("Build: but error: %v", err)
+	}
+	want := []Entry{
+		{Prefix: netip.MustParsePrefix("1.0.0.0/23"), Code: "JP"},
+		{Prefix: netip.MustParsePrefix("1.0.2.0/24"), Code: "JP"},
+		{Prefix: netip.MustParsePrefix("1.1.0.0/16"), Code: "CN"},
+		{Prefix: netip.MustParsePrefix("1.2.0.0/16"), Code: "CN"},
+		{Prefix: netip.MustParsePrefix("1.3.0.0/25"), Code: "AU"},
+		{Prefix: netip.MustParsePrefix("2001:df2:6180::/48"), Code: "HK"},
+	}
+	if !slices.Equal(m.Entries, want) {
+		t.Errorf("Build: want %v, but %v", want, m.Entries)
+	}
+
+	// カントリーコードの選択
+	m, err = Build(db, Options{Countries: []string{"hk", "AU"}})
+	if err != nil {
+		t.Fatalf("Build: countries, but error: %v", err)
+	}
+	want = []Entry{
+		{Prefix: netip.MustParsePrefix("1.3.0.0/25"), Code: "AU"},
+		{Prefix: netip.MustParsePrefix("2001:df2:6180::/48"), Code: "HK"},
+	}
+	if !slices.Equal(m.Entries, want) {
+		t.Errorf("Build: countries, want %v, but %v", want, m.Entries)
+	}
+
+	for _, tc := range []struct {
+		name string
+		db   *ccipv4.DB
+		opts Options
+		err  error
+	}{
+		{name: "empty db", db: ccipv4.GetDB(), err: ErrEmptyMap},
+		{name: "unknown country", db: db, opts: Options{Countries: []string{"XX"}}, err: ErrEmptyMap},
+		{name: "invalid variable", db: db, opts: Options{Variable: "$country"}, err: ErrInvalidVariable},
+		{name: "invalid variable", db: db, opts: Options{Variable: "1country"}, err: ErrInvalidVariable},
+	} {
+		if _, err := Build(tc.db, tc.opts); !errors.Is(err, tc.err) {
+			t.Errorf("Build: %s, want error %v, but %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	db := loadDB(t, testRecords...)
+	m, err := Build(db, Options{Variable: "country", Default: `"ZZ"`})
+	if err != nil {
+		t.Fatalf("Build: but error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		format Format
+		want   []string
+	}{
+		{
+			format: FormatNginx,
+			want: []string{
+				"geo $country {",
+				"\tdefault \"\\\"ZZ\\\"\";",
+				"\t1.0.0.0/23 JP;",
+				"\t1.0.2.0/24 JP;",
+				"\t1.1.0.0/16 CN;",
+				"\t1.2.0.0/16 CN;",
+				"\t1.3.0.0/25 AU;",
+				"\t2001:df2:6180::/48 HK;",
+				"}",
+			},
+		},
+		{
+			format: FormatHAProxy,
+			want: []string{
+				"1.0.0.0/23 JP",
+				"1.0.2.0/24 JP",
+				"1.1.0.0/16 CN",
+				"1.2.0.0/16 CN",
+				"1.3.0.0/25 AU",
+				"2001:df2:6180::/48 HK",
+			},
+		},
+		{
+			// /24 より小さいブロックと IPv6 のブロックは含まない。
+			format: FormatApache,
+			want: []string{
+				"1.0.0 JP",
+				"1.0.1 JP",
+				"1.0.2 JP",
+				"1.1 CN",
+				"1.2 CN",
+			},
+		},
+	} {
+		var sb strings.Builder
+		if err := m.Write(&sb, tc.format); err != nil {
+			t.Errorf("Write: %v, but error: %v", tc.format, err)
+			continue
+		}
+		if want := strings.Join(tc.want, "\n") + "\n"; sb.String() != want {
+			t.Errorf("Write: %v, want\n%s\nbut\n%s", tc.format, want, sb.String())
+		}
+	}
+
+	if err := m.Write(&strings.Builder{}, Format(-1)); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("Write: unknown format, want error %v, but %v", ErrUnknownFormat, err)
+	}
+}
+
+func TestWriteApacheLargeBlock(t *testing.T) {
+	// /16 より大きいブロックは /16 ごとのキーにする。
+	db := loadDB(t, "arin|US|ipv4|6.0.0.0|16777216|19940201|allocated")
+	m, err := Build(db, Options{})
+	if err != nil {
+		t.Fatalf("Build: but error: %v", err)
+	}
+	var sb strings.Builder
+	if err := m.WriteApache(&sb); err != nil {
+		t.Fatalf("WriteApache: but error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n")
+	if len(lines) != 256 || lines[0] != "6.0 US" || lines[255] != "6.255 US" {
+		t.Errorf("WriteApache: invalid keys: %d lines, %q ... %q", len(lines), lines[0], lines[len(lines)-1])
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want Format
+	}{
+		{s: "nginx", want: FormatNginx},
+		{s: "HAProxy", want: FormatHAProxy},
+		{s: "apache", want: FormatApache},
+	} {
+		f, err := ParseFormat(tc.s)
+		if err != nil || f != tc.want {
+			t.Errorf("ParseFormat: %s, want %v, but %v, %v", tc.s, tc.want, f, err)
+		}
+	}
+	if _, err := ParseFormat("caddy"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("ParseFormat: caddy, want error %v, but %v", ErrUnknownFormat, err)
+	}
+	if s := Format(9).String(); s != "Format(9)" {
+		t.Errorf("Format.String: want Format(9), but %s", s)
+	}
+}